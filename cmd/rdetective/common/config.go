@@ -1,22 +1,90 @@
 package common
 
 import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+
+	"github.com/sol1du2/rdetective/rhttp"
+	"github.com/sol1du2/rdetective/rsync"
+)
+
+// Hash algorithms of signatures. Chunks are found with a rolling adler32 and
+// confirmed with sha256, which also verifies whole files. No others are
+// supported yet, the settings let profiles and configuration files state them.
+const (
+	HashAdler32 = "adler32"
+	HashSHA256  = "sha256"
+)
+
+// Formats of written deltas.
+const (
+	FormatBinary = "binary"
+	FormatJSON   = "json"
 )
 
 var (
 	// Generic settings.
 	LogTimestamp bool
 	LogLevel     string
+	Progress     bool
 
 	OriginalFilePath string
 	UpdatedFilePath  string
+	UpdatedFilePaths []string
+	DeltaFilePath    string
+
+	ChunkSize          int
+	WeakHash           string
+	StrongHash         string
+	Jobs               int
+	SignatureCachePath string
+	Format             string
 
-	ChunkSize int
+	// Remote settings.
+	Root         string
+	Listen       string
+	Stdio        bool
+	RemoteCmd    string
+	Tree         bool
+	MaxPartSize  int64
+	MaxFrameSize int64
+
+	Output string
+	Local  string
+
+	// Store settings.
+	StorePath        string
+	SnapshotInterval int
+	Keep             int
+	ChunkStorePath   string
+
+	// Watch settings.
+	WatchFilePath string
+	Debounce      time.Duration
+	OutputDir     string
+	Hook          string
+
+	// Signing settings.
+	SignKeyPath   string
+	VerifyKeyPath string
+	Detached      bool
+
+	// Encryption settings.
+	Encrypt           bool
+	Encryption        bool
+	Decrypt           bool
+	EncryptionKeyPath string
+	Passphrase        string
 )
 
-func SetDefaults(cmd *cobra.Command) {
+// SetLogDefaults registers the settings shared by all commands.
+func SetLogDefaults(cmd *cobra.Command) {
 	// Defaults
 	viper.SetDefault("LOG_TIMESTAMP", true)
 	viper.SetDefault("LOG_LEVEL", "info")
@@ -24,33 +92,315 @@ func SetDefaults(cmd *cobra.Command) {
 	// Command line flags
 	cmd.Flags().Bool("log-timestamp", true, "prefix each log line with timestamp")
 	cmd.Flags().String("log-level", "info", "log level (one of panic, fatal, error, warn, info or debug)")
+	cmd.Flags().String("config", "", "configuration file (default $XDG_CONFIG_HOME/rdetective/config.yaml or .toml)")
+	cmd.Flags().String("profile", "", "named profile of settings to apply (e.g. text or vm-image)")
+
+	// Setup env.
+	viper.SetEnvPrefix("rdetective")
+	viper.AutomaticEnv()
+}
+
+// SetDefaults registers the settings of the diff command.
+func SetDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	// Defaults
+	viper.SetDefault("PROGRESS", true)
+
+	// Command line flags
+	cmd.Flags().Bool("progress", true, "show a progress indicator when running on a terminal")
 
 	cmd.Flags().String("original", "", "original file")
-	cmd.Flags().String("updated", "", "updated file")
+	cmd.Flags().StringSlice("updated", nil, "updated file (repeat to diff several files against the same original)")
 
 	cmd.Flags().Int("chunk-size", 2, "the size of each hashed chunk (window)")
+	setHashDefaults(cmd)
+	cmd.Flags().Int("jobs", runtime.NumCPU(), "the number of updated files to diff in parallel")
+	cmd.Flags().String("signature-cache", "", "directory to cache the signatures of original files in (disabled if empty)")
+}
 
-	_ = viper.BindPFlag("LOG_TIMESTAMP", cmd.Flags().Lookup("log-timestamp"))
-	_ = viper.BindPFlag("LOG_LEVEL", cmd.Flags().Lookup("log-level"))
+// setHashDefaults registers the hash algorithms, next to the chunk size of
+// commands that sign files.
+func setHashDefaults(cmd *cobra.Command) {
+	cmd.Flags().String("weak-hash", HashAdler32, "rolling hash to find chunks with (only adler32)")
+	cmd.Flags().String("strong-hash", HashSHA256, "hash to confirm chunks and verify files with (only sha256)")
+}
 
-	_ = viper.BindPFlag("ORIGINAL", cmd.Flags().Lookup("original"))
-	_ = viper.BindPFlag("UPDATED", cmd.Flags().Lookup("updated"))
+// SetDeltaDefaults registers the settings of the delta command.
+func SetDeltaDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
 
-	_ = viper.BindPFlag("CHUNK_SIZE", cmd.Flags().Lookup("chunk-size"))
+	cmd.Flags().String("original", "", "original file")
+	cmd.Flags().String("updated", "", "updated file")
+	cmd.Flags().Int("chunk-size", 1024, "the size of each hashed chunk (window)")
+	setHashDefaults(cmd)
+	cmd.Flags().String("signature-cache", "", "directory to cache the signatures of original files in (disabled if empty)")
+	cmd.Flags().StringP("output", "o", "", "delta file to write (default stdout)")
+	SetFormatDefaults(cmd)
+	SetSignDefaults(cmd)
+}
 
-	// Setup env.
-	viper.SetEnvPrefix("rdetective")
-	viper.AutomaticEnv()
+// SetPatchDefaults registers the settings of the patch and invert commands.
+func SetPatchDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	cmd.Flags().String("original", "", "original file")
+	cmd.Flags().String("delta", "", "delta file")
+	cmd.Flags().StringP("output", "o", "", "file to write (default stdout)")
+	SetVerifyKeyDefaults(cmd)
+}
+
+// SetVerifyDefaults registers the settings of the verify command.
+func SetVerifyDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	cmd.Flags().String("original", "", "original file")
+	cmd.Flags().String("delta", "", "delta file")
+	SetVerifyKeyDefaults(cmd)
+}
+
+// SetComposeDefaults registers the settings of the compose command.
+func SetComposeDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	cmd.Flags().StringP("output", "o", "", "delta file to write (default stdout)")
+	SetFormatDefaults(cmd)
+	SetSignDefaults(cmd)
+	SetVerifyKeyDefaults(cmd)
+}
+
+// SetFormatDefaults registers the settings of commands that write deltas.
+func SetFormatDefaults(cmd *cobra.Command) {
+	cmd.Flags().String("format", FormatBinary, "format of the written delta (one of binary or json)")
+}
+
+// SetSignDefaults registers the settings of commands that write signed or
+// encrypted files.
+func SetSignDefaults(cmd *cobra.Command) {
+	cmd.Flags().String("sign-key", "", "private key to sign the written file with (unsigned if empty)")
+	cmd.Flags().Bool("detached", false, "write the signature to a separate file.sig instead of embedding it")
+	cmd.Flags().Bool("encrypt", false, "encrypt the written file with --encryption-key or --passphrase")
+	setEncryptionKeyDefaults(cmd)
+}
+
+// SetVerifyKeyDefaults registers the settings of commands that read signed or
+// encrypted files.
+func SetVerifyKeyDefaults(cmd *cobra.Command) {
+	cmd.Flags().String("verify-key", "", "public key the read files must be signed with (unchecked if empty)")
+	cmd.Flags().Bool("decrypt", false, "decrypt the read files with --encryption-key or --passphrase")
+	setEncryptionKeyDefaults(cmd)
+}
+
+// setEncryptionKeyDefaults registers the keys of encrypted files, once for
+// commands that read and write them.
+func setEncryptionKeyDefaults(cmd *cobra.Command) {
+	if cmd.Flags().Lookup("encryption-key") != nil {
+		return
+	}
+
+	cmd.Flags().String("encryption-key", "", "file with the 32 byte key, raw or hex encoded, to encrypt or decrypt with")
+	cmd.Flags().String("passphrase", "", "passphrase to encrypt or decrypt with, preferably set as RDETECTIVE_PASSPHRASE")
+}
+
+// SetKeygenDefaults registers the settings of the keygen command.
+func SetKeygenDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	cmd.Flags().StringP("output", "o", "", "private key file to write, the public key is written to file.pub (required)")
+	cmd.Flags().Bool("encryption", false, "write a key file for --encryption-key instead of a key pair")
+}
+
+// SetServeDefaults registers the settings of the serve command.
+func SetServeDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	cmd.Flags().String("root", ".", "directory with the files that can be synced")
+	cmd.Flags().String("listen", "127.0.0.1:7873", "address to listen on")
+	cmd.Flags().Bool("stdio", false, "serve a single sync over stdin and stdout instead of listening")
+	cmd.Flags().Int64("max-frame-size", rsync.DefaultMaxFrameSize, "the maximum size in bytes of a message, like a signature, from a client")
+}
+
+// SetSyncDefaults registers the settings of the sync command.
+func SetSyncDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	cmd.Flags().Int("chunk-size", 1024, "the size of each hashed chunk (window)")
+	setHashDefaults(cmd)
+	cmd.Flags().String("remote-cmd", "", "command that runs rdetective server --stdio on the remote")
+	cmd.Flags().Bool("tree", false, "compare tree signatures and only exchange the parts that differ, for files changed in place")
 }
 
-func ApplyConfiguration() error {
+// SetHTTPDefaults registers the settings of the http command.
+func SetHTTPDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	cmd.Flags().String("listen", "127.0.0.1:8080", "address to listen on")
+	cmd.Flags().Int("chunk-size", 1024, "the chunk size of signatures if a request does not set one")
+	setHashDefaults(cmd)
+	cmd.Flags().Int64("max-part-size", rhttp.DefaultMaxPartSize, "the maximum size in bytes of a signature or delta in a request")
+}
+
+// SetPublishDefaults registers the settings of the publish command.
+func SetPublishDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	cmd.Flags().Int("chunk-size", 1024, "the size of each block")
+	setHashDefaults(cmd)
+	cmd.Flags().StringP("output", "o", "", "control file to write (default file.rdz)")
+}
+
+// SetFetchDefaults registers the settings of the fetch command.
+func SetFetchDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	cmd.Flags().StringP("output", "o", "", "file to write (default the name of the published file)")
+	cmd.Flags().String("local", "", "older local copy to reuse blocks from (default the output file)")
+}
+
+// SetStoreDefaults registers the settings shared by all store commands.
+func SetStoreDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	cmd.Flags().String("store", ".rdetective", "directory of the store")
+}
+
+// SetStoreInitDefaults registers the settings of the store init command.
+func SetStoreInitDefaults(cmd *cobra.Command) {
+	SetStoreDefaults(cmd)
+
+	cmd.Flags().Int("chunk-size", 1024, "the size of each hashed chunk (window)")
+	setHashDefaults(cmd)
+	cmd.Flags().Int("snapshot-interval", 16, "the maximum number of deltas between full snapshots")
+}
+
+// SetStoreCheckoutDefaults registers the settings of the store checkout
+// command.
+func SetStoreCheckoutDefaults(cmd *cobra.Command) {
+	SetStoreDefaults(cmd)
+
+	cmd.Flags().StringP("output", "o", "", "file to write (default the file itself)")
+}
+
+// SetStorePruneDefaults registers the settings of the store prune command.
+func SetStorePruneDefaults(cmd *cobra.Command) {
+	SetStoreDefaults(cmd)
+
+	cmd.Flags().Int("keep", 0, "the number of newest versions to keep (required)")
+}
+
+// SetDedupDefaults registers the settings shared by all dedup commands.
+func SetDedupDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	cmd.Flags().String("chunk-store", ".rdetective-dedup", "directory of the chunk store")
+}
+
+// SetDedupInitDefaults registers the settings of the dedup init command.
+func SetDedupInitDefaults(cmd *cobra.Command) {
+	SetDedupDefaults(cmd)
+
+	cmd.Flags().Int("chunk-size", 4096, "the size of each chunk")
+	setHashDefaults(cmd)
+}
+
+// SetDedupRestoreDefaults registers the settings of the dedup restore command.
+func SetDedupRestoreDefaults(cmd *cobra.Command) {
+	SetDedupDefaults(cmd)
+
+	cmd.Flags().StringP("output", "o", "", "file to write (default the file itself)")
+}
+
+// SetWatchDefaults registers the settings of the watch command.
+func SetWatchDefaults(cmd *cobra.Command) {
+	SetLogDefaults(cmd)
+
+	cmd.Flags().String("file", "", "file to watch")
+	cmd.Flags().Int("chunk-size", 1024, "the size of each hashed chunk (window)")
+	setHashDefaults(cmd)
+	cmd.Flags().Duration("debounce", 500*time.Millisecond, "how long the file must be left alone before a delta is generated")
+	cmd.Flags().String("output-dir", "", "directory to write each delta to")
+	cmd.Flags().String("hook", "", "command to run with each delta on stdin")
+}
+
+// BindFlags binds all flags of cmd to their configuration keys, e.g. the flag
+// chunk-size to CHUNK_SIZE. Several commands share keys, so this must only be
+// called for the command that is being run.
+func BindFlags(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		key := strings.ToUpper(strings.ReplaceAll(flag.Name, "-", "_"))
+		_ = viper.BindPFlag(key, flag)
+	})
+}
+
+// ApplyConfiguration binds the flags of cmd and loads the configuration, from
+// flags, environment variables, the selected profile and the configuration
+// file, in that order of precedence.
+func ApplyConfiguration(cmd *cobra.Command) error {
+	BindFlags(cmd)
+	if err := loadConfigFile(cmd); err != nil {
+		return err
+	}
+
 	LogTimestamp = viper.GetBool("LOG_TIMESTAMP")
 	LogLevel = viper.GetString("LOG_LEVEL")
+	Progress = viper.GetBool("PROGRESS")
 
 	OriginalFilePath = viper.GetString("ORIGINAL")
 	UpdatedFilePath = viper.GetString("UPDATED")
+	UpdatedFilePaths = viper.GetStringSlice("UPDATED")
+	DeltaFilePath = viper.GetString("DELTA")
 
 	ChunkSize = viper.GetInt("CHUNK_SIZE")
+	WeakHash = viper.GetString("WEAK_HASH")
+	StrongHash = viper.GetString("STRONG_HASH")
+	Jobs = viper.GetInt("JOBS")
+	SignatureCachePath = viper.GetString("SIGNATURE_CACHE")
+	Format = viper.GetString("FORMAT")
+
+	Root = viper.GetString("ROOT")
+	Listen = viper.GetString("LISTEN")
+	Stdio = viper.GetBool("STDIO")
+	RemoteCmd = viper.GetString("REMOTE_CMD")
+	Tree = viper.GetBool("TREE")
+	MaxPartSize = viper.GetInt64("MAX_PART_SIZE")
+	MaxFrameSize = viper.GetInt64("MAX_FRAME_SIZE")
+
+	Output = viper.GetString("OUTPUT")
+	Local = viper.GetString("LOCAL")
+
+	StorePath = viper.GetString("STORE")
+	SnapshotInterval = viper.GetInt("SNAPSHOT_INTERVAL")
+	Keep = viper.GetInt("KEEP")
+	ChunkStorePath = viper.GetString("CHUNK_STORE")
+
+	WatchFilePath = viper.GetString("FILE")
+	Debounce = viper.GetDuration("DEBOUNCE")
+	OutputDir = viper.GetString("OUTPUT_DIR")
+	Hook = viper.GetString("HOOK")
+
+	SignKeyPath = viper.GetString("SIGN_KEY")
+	VerifyKeyPath = viper.GetString("VERIFY_KEY")
+	Detached = viper.GetBool("DETACHED")
+
+	Encrypt = viper.GetBool("ENCRYPT")
+	Encryption = viper.GetBool("ENCRYPTION")
+	Decrypt = viper.GetBool("DECRYPT")
+	EncryptionKeyPath = viper.GetString("ENCRYPTION_KEY")
+	Passphrase = viper.GetString("PASSPHRASE")
+
+	return checkHashes()
+}
+
+// checkHashes rejects hash algorithms that are not supported. Commands that do
+// not sign files have no hash settings.
+func checkHashes() error {
+	if WeakHash != "" && WeakHash != HashAdler32 {
+		return fmt.Errorf("%w: unsupported weak hash %q, expected %s", ErrInvalidConfiguration, WeakHash, HashAdler32)
+	}
+
+	if StrongHash != "" && StrongHash != HashSHA256 {
+		return fmt.Errorf("%w: unsupported strong hash %q, expected %s", ErrInvalidConfiguration, StrongHash, HashSHA256)
+	}
 
 	return nil
 }
@@ -0,0 +1,380 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// ErrInvalidConfiguration is returned for configuration files that cannot be
+// read and for unknown settings and profiles.
+var ErrInvalidConfiguration = errors.New("invalid configuration")
+
+// Settings of a configuration file are named like their flags, with
+// underscores or dashes, e.g. chunk_size. Besides the settings themselves a
+// file can have a profile setting, which selects a profile unless --profile is
+// given, and profiles, a map of named profiles that add to or replace the
+// builtinProfiles.
+const (
+	profileKey  = "profile"
+	profilesKey = "profiles"
+)
+
+// builtinProfiles bundle settings for common kinds of files.
+var builtinProfiles = map[string]map[string]interface{}{
+	// Text files change in small places.
+	"text": {
+		"chunk_size":  64,
+		"weak_hash":   HashAdler32,
+		"strong_hash": HashSHA256,
+		"format":      FormatJSON,
+	},
+	// Disk images are large and change in place.
+	"vm-image": {
+		"chunk_size":  64 * 1024,
+		"weak_hash":   HashAdler32,
+		"strong_hash": HashSHA256,
+		"format":      FormatBinary,
+		"tree":        true,
+	},
+}
+
+// configuration remembers where the settings in the configuration layer of
+// viper came from.
+type configuration struct {
+	path    string
+	values  map[string]interface{}
+	profile string
+	// profileOrigin is the path of the file that defines the profile, or empty
+	// for builtin profiles.
+	profileOrigin string
+	profileValues map[string]interface{}
+}
+
+var loaded configuration
+
+// ConfigPath returns the configuration file set with --config, or the first of
+// config.yaml, config.yml or config.toml that exists in rdetective below
+// $XDG_CONFIG_HOME, or ~/.config if it is not set. It returns an empty path if
+// there is none.
+func ConfigPath() string {
+	if path := viper.GetString("CONFIG"); path != "" {
+		return path
+	}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "" // Nothing to discover.
+		}
+
+		dir = filepath.Join(home, ".config")
+	}
+
+	for _, name := range []string{"config.yaml", "config.yml", "config.toml"} {
+		path := filepath.Join(dir, "rdetective", name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// loadConfigFile reads the configuration file, if there is one, and applies
+// the selected profile on top of it. Settings that are not known to any
+// command of the root of cmd are rejected.
+func loadConfigFile(cmd *cobra.Command) error {
+	loaded = configuration{}
+	path := ConfigPath()
+	known := knownKeys(cmd.Root())
+	profiles := make(map[string]map[string]interface{}, len(builtinProfiles))
+	for name, values := range builtinProfiles {
+		profiles[name] = values
+	}
+
+	origins := make(map[string]string)
+	if path != "" {
+		settings, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+
+		loaded.path = path
+		loaded.values = make(map[string]interface{})
+		for key, value := range settings {
+			switch key {
+			case profilesKey:
+				defined, ok := stringMap(value)
+				if !ok {
+					return fmt.Errorf("%w: %s: profiles must be a map", ErrInvalidConfiguration, path)
+				}
+
+				for name, values := range defined {
+					profile, ok := stringMap(values)
+					if !ok {
+						return fmt.Errorf("%w: %s: profile %q must be a map", ErrInvalidConfiguration, path, name)
+					}
+
+					profiles[name] = profile
+					origins[name] = path
+				}
+			default:
+				loaded.values[key] = value
+			}
+		}
+	}
+
+	if err := checkKeys(loaded.values, known, "config "+path); err != nil {
+		return err
+	}
+
+	if err := setConfig(loaded.values); err != nil {
+		return err
+	}
+
+	name := viper.GetString("PROFILE")
+	if name == "" {
+		return nil
+	}
+
+	profile, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf("%w: unknown profile %q", ErrInvalidConfiguration, name)
+	}
+
+	values := normalizeKeys(profile)
+	if err := checkKeys(values, known, "profile "+name); err != nil {
+		return err
+	}
+
+	loaded.profile = name
+	loaded.profileOrigin = origins[name]
+	loaded.profileValues = values
+
+	return setConfig(loaded.values, values)
+}
+
+// setConfig replaces the configuration layer of viper with layers of values,
+// later ones override earlier ones. Viper does not merge values of different
+// types, e.g. an int of a profile into an int64 of a TOML file, so the layers
+// are merged here.
+func setConfig(layers ...map[string]interface{}) error {
+	merged := make(map[string]interface{})
+	for _, values := range layers {
+		for key, value := range values {
+			merged[key] = value
+		}
+	}
+
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(bytes.NewReader(nil)); err != nil {
+		return err
+	}
+
+	return viper.MergeConfigMap(merged)
+}
+
+// readConfigFile returns the settings of the YAML or TOML file at path, with
+// normalized keys.
+func readConfigFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfiguration, err)
+	}
+
+	file := viper.New()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		file.SetConfigType("yaml")
+	case ".toml":
+		file.SetConfigType("toml")
+	default:
+		return nil, fmt.Errorf("%w: %s: only .yaml, .yml and .toml files are supported", ErrInvalidConfiguration, path)
+	}
+
+	if err = file.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidConfiguration, path, err)
+	}
+
+	settings := make(map[string]interface{})
+	for _, key := range file.AllKeys() {
+		top := strings.SplitN(key, ".", 2)[0]
+		settings[top] = file.Get(top)
+	}
+
+	return normalizeKeys(settings), nil
+}
+
+// knownKeys returns the keys of the flags of cmd and all its subcommands.
+func knownKeys(cmd *cobra.Command) map[string]bool {
+	known := map[string]bool{profileKey: true}
+
+	var visit func(cmd *cobra.Command)
+	visit = func(cmd *cobra.Command) {
+		cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+			known[configKey(flag.Name)] = true
+		})
+
+		for _, child := range cmd.Commands() {
+			visit(child)
+		}
+	}
+	visit(cmd)
+
+	delete(known, "help")
+
+	return known
+}
+
+func checkKeys(values map[string]interface{}, known map[string]bool, origin string) error {
+	for key := range values {
+		if !known[key] {
+			return fmt.Errorf("%w: unknown setting %q in %s", ErrInvalidConfiguration, key, origin)
+		}
+	}
+
+	return nil
+}
+
+// configKey returns the key of a setting in configuration files and profiles,
+// e.g. chunk_size for the flag chunk-size.
+func configKey(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "-", "_"))
+}
+
+func normalizeKeys(values map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		normalized[configKey(key)] = value
+	}
+
+	return normalized
+}
+
+// stringMap converts maps decoded from YAML or TOML.
+func stringMap(value interface{}) (map[string]interface{}, bool) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(m))
+		for key, value := range m {
+			converted[fmt.Sprint(key)] = value
+		}
+
+		return converted, true
+	}
+
+	return nil, false
+}
+
+// Setting is the effective value of a setting and where it came from.
+type Setting struct {
+	Key    string
+	Value  interface{}
+	Source string
+}
+
+// ConfigFile returns the configuration file that was loaded, if any.
+func ConfigFile() string {
+	return loaded.path
+}
+
+// Profile returns the selected profile and where it is defined, if any.
+func Profile() (name, origin string) {
+	origin = loaded.profileOrigin
+	if origin == "" {
+		origin = "built-in"
+	}
+
+	return loaded.profile, origin
+}
+
+// Settings returns the effective settings of the flags of cmd, which is run,
+// and of target, in order of their keys. Flags of cmd take precedence over
+// flags of target with the same key. If target is nil the settings of all
+// commands are returned, but only those that do not have their default value.
+// Otherwise all settings of target are returned.
+func Settings(cmd, target *cobra.Command) []Setting {
+	flags := make(map[string]*pflag.Flag)
+	collect := func(flag *pflag.Flag) {
+		if _, ok := flags[configKey(flag.Name)]; !ok {
+			flags[configKey(flag.Name)] = flag
+		}
+	}
+
+	if target != nil {
+		BindFlags(target)
+	}
+	BindFlags(cmd)
+
+	cmd.Flags().VisitAll(collect)
+	if target != nil {
+		target.Flags().VisitAll(collect)
+	} else {
+		var visit func(cmd *cobra.Command)
+		visit = func(cmd *cobra.Command) {
+			cmd.Flags().VisitAll(collect)
+			for _, child := range cmd.Commands() {
+				visit(child)
+			}
+		}
+		visit(cmd.Root())
+	}
+	delete(flags, "help")
+
+	var settings []Setting
+	for key, flag := range flags {
+		source := settingSource(key, flag)
+		if target == nil && source == "default" {
+			continue
+		}
+
+		settings = append(settings, Setting{
+			Key:    key,
+			Value:  viper.Get(strings.ToUpper(key)),
+			Source: source,
+		})
+	}
+
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Key < settings[j].Key
+	})
+
+	return settings
+}
+
+// settingSource returns where the effective value of a setting comes from,
+// following the precedence of viper.
+func settingSource(key string, flag *pflag.Flag) string {
+	env := "RDETECTIVE_" + strings.ToUpper(key)
+
+	if flag.Changed {
+		return "flag --" + flag.Name
+	}
+
+	if os.Getenv(env) != "" {
+		return "env " + env
+	}
+
+	if _, ok := loaded.profileValues[key]; ok {
+		name, origin := Profile()
+		return fmt.Sprintf("profile %s (%s)", name, origin)
+	}
+
+	if _, ok := loaded.values[key]; ok {
+		return "config " + loaded.path
+	}
+
+	return "default"
+}
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	// Empty variables are ignored, like unset ones.
+	t.Setenv("RDETECTIVE_CONFIG", "")
+	t.Setenv("RDETECTIVE_PROFILE", "")
+	t.Setenv("RDETECTIVE_CHUNK_SIZE", "")
+
+	if err := os.MkdirAll(filepath.Join(dir, "rdetective"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := filepath.Join(dir, "rdetective", "config.toml")
+	err := os.WriteFile(config, []byte(`
+log-level = "debug"
+chunk_size = 512
+signature_cache = "/tmp/cache"
+
+[profiles.small]
+chunk-size = 16
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCommand := func(args ...string) *cobra.Command {
+		root := &cobra.Command{Use: "rdetective"}
+		cmd := &cobra.Command{Use: "delta"}
+		SetDeltaDefaults(cmd)
+		root.AddCommand(cmd)
+
+		if err := cmd.ParseFlags(args); err != nil {
+			t.Fatal(err)
+		}
+
+		return cmd
+	}
+
+	tests := []struct {
+		name      string
+		args      []string
+		chunkSize int
+		format    string
+		source    string
+	}{
+		{"config", nil, 512, FormatBinary, "config " + config},
+		{"built-in profile", []string{"--profile", "text"}, 64, FormatJSON, "profile text (built-in)"},
+		{"file profile", []string{"--profile", "small"}, 16, FormatBinary, "profile small (" + config + ")"},
+		{"flag", []string{"--profile", "text", "--chunk-size", "8"}, 8, FormatJSON, "flag --chunk-size"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := newCommand(test.args...)
+			if err := ApplyConfiguration(cmd); err != nil {
+				t.Fatal(err)
+			}
+
+			if ChunkSize != test.chunkSize {
+				t.Errorf("unexpected chunk size, got %d, expected %d", ChunkSize, test.chunkSize)
+			}
+
+			if Format != test.format {
+				t.Errorf("unexpected format, got %s, expected %s", Format, test.format)
+			}
+
+			if LogLevel != "debug" {
+				t.Errorf("unexpected log level, got %s, expected debug", LogLevel)
+			}
+
+			if SignatureCachePath != "/tmp/cache" {
+				t.Errorf("unexpected signature cache, got %s, expected /tmp/cache", SignatureCachePath)
+			}
+
+			for _, setting := range Settings(cmd, cmd) {
+				if setting.Key == "chunk_size" && setting.Source != test.source {
+					t.Errorf("unexpected source, got %s, expected %s", setting.Source, test.source)
+				}
+			}
+		})
+	}
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("RDETECTIVE_CHUNK_SIZE", "32")
+
+		cmd := newCommand("--profile", "text")
+		if err := ApplyConfiguration(cmd); err != nil {
+			t.Fatal(err)
+		}
+
+		if ChunkSize != 32 {
+			t.Errorf("unexpected chunk size, got %d, expected 32", ChunkSize)
+		}
+	})
+
+	t.Run("unknown profile", func(t *testing.T) {
+		err := ApplyConfiguration(newCommand("--profile", "nope"))
+		if !errors.Is(err, ErrInvalidConfiguration) {
+			t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidConfiguration)
+		}
+	})
+
+	t.Run("unknown setting", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte("chunk_size: 8\nbogus: 1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := ApplyConfiguration(newCommand("--config", path))
+		if !errors.Is(err, ErrInvalidConfiguration) {
+			t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidConfiguration)
+		}
+	})
+}
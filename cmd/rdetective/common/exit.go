@@ -0,0 +1,72 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sol1du2/rdetective/rcrypt"
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rfile"
+	"github.com/sol1du2/rdetective/rsign"
+	"github.com/sol1du2/rdetective/rsync"
+)
+
+// Exit codes used by all commands. They are documented in the README.
+const (
+	ExitOK                 = 0
+	ExitFailure            = 1
+	ExitUsage              = 2
+	ExitInvalidInput       = 3
+	ExitSourceOpen         = 4
+	ExitCorruptInput       = 5
+	ExitVerificationFailed = 6
+	ExitInterrupted        = 130
+)
+
+// ExitCode maps an error returned by a command to its exit code.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.Is(err, ErrInvalidConfiguration),
+		errors.Is(err, rdiff.ErrInvalidChunkSize), errors.Is(err, rdiff.ErrMissingSource), errors.Is(err, rsign.ErrInvalidKey),
+		errors.Is(err, rcrypt.ErrInvalidKey), errors.Is(err, ErrEncrypted),
+		errors.Is(err, rfile.ErrInvalidArgument), errors.Is(err, rfile.ErrNotFound):
+		return ExitInvalidInput
+	case errors.Is(err, rdiff.ErrSourceOpen):
+		return ExitSourceOpen
+	case errors.Is(err, rdiff.ErrCorruptInput), errors.Is(err, rsync.ErrProtocol):
+		return ExitCorruptInput
+	case errors.Is(err, rdiff.ErrVerificationFailed), errors.Is(err, rsign.ErrUnsigned), errors.Is(err, rsign.ErrInvalidSignature),
+		errors.Is(err, rcrypt.ErrDecryptionFailed):
+		return ExitVerificationFailed
+	}
+
+	return ExitFailure
+}
+
+// SignalContext returns a context that is canceled on SIGINT or SIGTERM.
+func SignalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// Exit reports err, if any, and exits with the matching exit code.
+func Exit(err error) {
+	if err == nil {
+		return
+	}
+
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "Interrupted")
+	} else {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+
+	os.Exit(ExitCode(err))
+}
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/sol1du2/rdetective/rdedup"
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rstore"
+	"github.com/sol1du2/rdetective/rsync"
+	"github.com/sol1du2/rdetective/rzsync"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{nil, ExitOK},
+		{fmt.Errorf("failed: %w", context.Canceled), ExitInterrupted},
+		{rstore.ErrNotFound, ExitInvalidInput},
+		{rdedup.ErrInvalidArgument, ExitInvalidInput},
+		{rsync.ErrProtocol, ExitCorruptInput},
+		{rdiff.ErrVerificationFailed, ExitVerificationFailed},
+		{fmt.Errorf("%w: file", rsync.ErrVerificationFailed), ExitVerificationFailed},
+		{fmt.Errorf("%w: file", rzsync.ErrVerificationFailed), ExitVerificationFailed},
+		{fmt.Errorf("%w: version 1", rstore.ErrVerificationFailed), ExitVerificationFailed},
+		{fmt.Errorf("%w: file", rdedup.ErrVerificationFailed), ExitVerificationFailed},
+		{fmt.Errorf("unknown"), ExitFailure},
+	}
+
+	for _, test := range tests {
+		if code := ExitCode(test.err); code != test.expected {
+			t.Errorf("unexpected exit code for %v, got %d, expected %d", test.err, code, test.expected)
+		}
+	}
+}
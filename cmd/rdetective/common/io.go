@@ -0,0 +1,77 @@
+package common
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/sol1du2/rdetective/rcache"
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rfile"
+)
+
+// OpenFile opens the file at path, failures are reported as rdiff.SourceError
+// for the named source.
+func OpenFile(source, path string) (*os.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, &rdiff.SourceError{Source: source, Op: "open", Err: err}
+	}
+
+	return file, nil
+}
+
+// SignFile returns the signature of the file at path for the named source. The
+// signature cache is used if one is configured with --signature-cache. This is
+// the only place the cache is used, the commands that call SignFile are the
+// only ones that offer --signature-cache.
+func SignFile(ctx context.Context, options rdiff.Options, source, path string) (rdiff.Signature, error) {
+	file, err := OpenFile(source, path)
+	if err != nil {
+		return rdiff.Signature{}, err
+	}
+	defer file.Close()
+
+	if SignatureCachePath == "" {
+		return rdiff.NewSigner(options).SignContext(ctx, file)
+	}
+
+	cache := rcache.New(&rcache.Config{
+		Logger: options.Logger,
+		Dir:    SignatureCachePath,
+	})
+
+	return cache.Sign(ctx, options, file)
+}
+
+// WriterToFunc adapts a function to io.WriterTo.
+type WriterToFunc func(io.Writer) (int64, error)
+
+func (f WriterToFunc) WriteTo(w io.Writer) (int64, error) {
+	return f(w)
+}
+
+// WriteFile atomically replaces the file name with the data written by
+// writerTo. If name is empty or "-" the data is written to stdout instead.
+// New files get 0644 minus the umask, replaced files keep their permissions.
+func WriteFile(name string, writerTo io.WriterTo) error {
+	return writeFile(name, 0o644, writerTo)
+}
+
+// WriteKeyFile is like WriteFile, but new files are only accessible by their
+// owner.
+func WriteKeyFile(name string, writerTo io.WriterTo) error {
+	return writeFile(name, 0o600, writerTo)
+}
+
+func writeFile(name string, perm os.FileMode, writerTo io.WriterTo) error {
+	if name == "" || name == "-" {
+		_, err := writerTo.WriteTo(os.Stdout)
+		return err
+	}
+
+	return rfile.WriteFile(name, perm, func(f *os.File) error {
+		_, err := writerTo.WriteTo(f)
+		return err
+	})
+}
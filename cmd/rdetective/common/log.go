@@ -1,4 +1,4 @@
-package diff
+package common
 
 import (
 	"os"
@@ -6,7 +6,8 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func newLogger(disableTimestamp bool, logLevelString string) (logrus.FieldLogger, error) {
+// NewLogger creates the logger used by all commands.
+func NewLogger(disableTimestamp bool, logLevelString string) (logrus.FieldLogger, error) {
 	logLevel, err := logrus.ParseLevel(logLevelString)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,254 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sol1du2/rdetective/rcrypt"
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rsign"
+)
+
+// signatureExtension is appended to the name of a file for its detached
+// signature.
+const signatureExtension = ".sig"
+
+// peekSize is enough of a file to recognize signed and encrypted files by
+// their magic.
+const peekSize = 16
+
+// ErrEncrypted is returned for encrypted files that are read without --decrypt.
+var ErrEncrypted = errors.New("encrypted input")
+
+// ReadProtected calls decode with the content of the file at path for the
+// named source. If --verify-key is set, the file must be signed with it,
+// either embedded or with a detached signature next to it. Otherwise embedded
+// signatures are removed unchecked. With --decrypt the content is decrypted
+// after its signature was checked.
+// Unsigned files are streamed to decode. Signatures cover the whole file, so
+// signed files are read into memory before anything of them is decoded.
+func ReadProtected(source, path string, decode func(r io.Reader) error) error {
+	file, err := OpenFile(source, path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	content := bufio.NewReader(file)
+	if head, _ := content.Peek(peekSize); VerifyKeyPath != "" || rsign.IsSigned(head) {
+		data, err := readSigned(source, path, content)
+		if err != nil {
+			return err
+		}
+
+		content = bufio.NewReader(bytes.NewReader(data))
+	}
+
+	head, _ := content.Peek(peekSize)
+	if !Decrypt {
+		if rcrypt.IsEncrypted(head) {
+			return fmt.Errorf("%w: %s %s is encrypted, use --decrypt", ErrEncrypted, source, path)
+		}
+
+		return decode(content)
+	}
+
+	key, err := encryptionKey()
+	if err != nil {
+		return err
+	}
+
+	decrypter, err := rcrypt.NewReader(content, key)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", source, path, err)
+	}
+
+	// Decoders report errors of their reader as corrupt input, failed
+	// decryption takes precedence. The rest is read to authenticate the end.
+	decrypted := &errorReader{r: decrypter}
+	if err = decode(decrypted); err == nil {
+		_, err = io.Copy(io.Discard, decrypted)
+	}
+
+	if decrypted.err != nil {
+		return fmt.Errorf("%s %s: %w", source, path, decrypted.err)
+	}
+
+	return err
+}
+
+// readSigned reads the signed file at path from r and returns its content
+// once the signature is checked, see ReadProtected.
+func readSigned(source, path string, r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &rdiff.SourceError{Source: source, Op: "read", Err: err}
+	}
+
+	if VerifyKeyPath == "" {
+		return rsign.Unwrap(data)
+	}
+
+	key, err := readPublicKey(VerifyKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if rsign.IsSigned(data) {
+		if data, err = rsign.Open(key, data); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", source, path, err)
+		}
+
+		return data, nil
+	}
+
+	signature, err := os.ReadFile(path + signatureExtension)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s %s has no signature", rsign.ErrUnsigned, source, path)
+	} else if err != nil {
+		return nil, &rdiff.SourceError{Source: "signature", Op: "open", Err: err}
+	}
+
+	if err = rsign.VerifyDetached(key, data, signature); err != nil {
+		return nil, fmt.Errorf("%s %s: %w", source, path, err)
+	}
+
+	return data, nil
+}
+
+// errorReader remembers the first error of r other than io.EOF.
+type errorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errorReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+
+	return n, err
+}
+
+// WriteProtected is like WriteFile, but encrypts the data with --encrypt and
+// signs it with the key of --sign-key if it is set. Encrypted data is signed
+// after encryption, so that signatures can be checked without the encryption
+// key. The signature is embedded, or written to name.sig with --detached.
+// Unsigned data is streamed to the file, data that is signed is held in memory
+// until it is signed as a whole.
+func WriteProtected(name string, writerTo io.WriterTo) error {
+	if Encrypt {
+		key, err := encryptionKey()
+		if err != nil {
+			return err
+		}
+
+		writerTo = encrypted(writerTo, key)
+	}
+
+	if SignKeyPath == "" {
+		return WriteFile(name, writerTo)
+	}
+
+	if Detached && (name == "" || name == "-") {
+		return fmt.Errorf("%w: --detached needs an output file", rdiff.ErrMissingSource)
+	}
+
+	key, err := readPrivateKey(SignKeyPath)
+	if err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+	if _, err = writerTo.WriteTo(&data); err != nil {
+		return err
+	}
+
+	if !Detached {
+		return WriteFile(name, bytes.NewReader(rsign.Sign(key, data.Bytes())))
+	}
+
+	signature := rsign.SignDetached(key, data.Bytes())
+	if err = WriteFile(name, &data); err != nil {
+		return err
+	}
+
+	return WriteFile(name+signatureExtension, bytes.NewReader(signature))
+}
+
+func readPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &rdiff.SourceError{Source: "sign key", Op: "open", Err: err}
+	}
+
+	key, err := rsign.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("sign key %s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+func readPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &rdiff.SourceError{Source: "verify key", Op: "open", Err: err}
+	}
+
+	key, err := rsign.ParsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("verify key %s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+// encrypted returns a WriterTo that writes the data of writerTo encrypted with
+// key.
+func encrypted(writerTo io.WriterTo, key rcrypt.Key) io.WriterTo {
+	return WriterToFunc(func(w io.Writer) (int64, error) {
+		encrypter, err := rcrypt.NewWriter(w, key)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := writerTo.WriteTo(encrypter)
+		if err != nil {
+			return n, err
+		}
+
+		return n, encrypter.Close()
+	})
+}
+
+// encryptionKey returns the key of --encryption-key, or the one derived from
+// --passphrase.
+func encryptionKey() (rcrypt.Key, error) {
+	switch {
+	case EncryptionKeyPath != "" && Passphrase != "":
+		return rcrypt.Key{}, fmt.Errorf("%w: --encryption-key and --passphrase are exclusive", rcrypt.ErrInvalidKey)
+	case EncryptionKeyPath != "":
+		data, err := os.ReadFile(EncryptionKeyPath)
+		if err != nil {
+			return rcrypt.Key{}, &rdiff.SourceError{Source: "encryption key", Op: "open", Err: err}
+		}
+
+		key, err := rcrypt.ParseKey(data)
+		if err != nil {
+			return rcrypt.Key{}, fmt.Errorf("encryption key %s: %w", EncryptionKeyPath, err)
+		}
+
+		return key, nil
+	case Passphrase != "":
+		return rcrypt.NewPassphrase(Passphrase)
+	}
+
+	return rcrypt.Key{}, fmt.Errorf("%w: --encryption-key or --passphrase is required", rdiff.ErrMissingSource)
+}
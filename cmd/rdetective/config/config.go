@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+)
+
+func CommandConfig() *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspects the configuration",
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show [command...]",
+		Short: "Prints the effective configuration and where each value comes from",
+		Long: `Prints the effective configuration and where each value comes from.
+
+Values come from flags, RDETECTIVE_* environment variables, the selected
+profile, the configuration file and the defaults, in that order of precedence.
+With a command, e.g. "config show store init", all settings of that command are
+printed. Without one only settings that do not have their default value are.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			common.Exit(show(cmd, args))
+		},
+	}
+	common.SetLogDefaults(showCmd)
+
+	configCmd.AddCommand(showCmd)
+
+	return configCmd
+}
+
+func show(cmd *cobra.Command, args []string) error {
+	var target *cobra.Command
+	if len(args) > 0 {
+		found, rest, err := cmd.Root().Find(args)
+		if err != nil || len(rest) > 0 || found == cmd.Root() {
+			return fmt.Errorf("unknown command %q", strings.Join(args, " "))
+		}
+
+		// Bind the settings of the target first, so that those of show win.
+		target = found
+		common.BindFlags(target)
+	}
+
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	configFile := common.ConfigFile()
+	if configFile == "" {
+		configFile = "none"
+	}
+
+	profile, origin := common.Profile()
+	if profile == "" {
+		profile = "none"
+	} else {
+		profile = fmt.Sprintf("%s (%s)", profile, origin)
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "config file: %s\nprofile: %s\n\n", configFile, profile)
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+	for _, setting := range common.Settings(cmd, target) {
+		fmt.Fprintf(w, "%s\t%v\t%s\n", setting.Key, setting.Value, setting.Source)
+	}
+
+	return w.Flush()
+}
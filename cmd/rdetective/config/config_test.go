@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+)
+
+// runShow runs config show with args, which are flags of show followed by the
+// command to show, and returns the value and source of every setting printed.
+func runShow(t *testing.T, args ...string) (map[string][2]string, error) {
+	root := &cobra.Command{Use: "rdetective"}
+	deltaCmd := &cobra.Command{Use: "delta"}
+	common.SetDeltaDefaults(deltaCmd)
+	configCmd := CommandConfig()
+	root.AddCommand(configCmd, deltaCmd)
+
+	showCmd, _, err := configCmd.Find([]string{"show"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = showCmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	showCmd.SetOut(&output)
+	if err = show(showCmd, showCmd.Flags().Args()); err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string][2]string)
+	for _, line := range strings.Split(output.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 3 {
+			settings[fields[0]] = [2]string{fields[1], strings.Join(fields[2:], " ")}
+		}
+	}
+
+	return settings, nil
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestShowPrecedence(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("RDETECTIVE_CONFIG", "")
+	t.Setenv("RDETECTIVE_PROFILE", "")
+	t.Setenv("RDETECTIVE_LOG_LEVEL", "")
+	t.Setenv("RDETECTIVE_CHUNK_SIZE", "")
+
+	config := writeConfig(t, `
+log_level: warn
+chunk_size: 512
+profiles:
+  quiet:
+    log_level: error
+`)
+
+	tests := []struct {
+		name   string
+		env    string
+		args   []string
+		value  string
+		source string
+	}{
+		{"config", "", nil, "warn", "config " + config},
+		{"profile", "", []string{"--profile", "quiet"}, "error", "profile quiet (" + config + ")"},
+		{"env", "debug", []string{"--profile", "quiet"}, "debug", "env RDETECTIVE_LOG_LEVEL"},
+		{"flag", "debug", []string{"--profile", "quiet", "--log-level", "panic"}, "panic", "flag --log-level"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("RDETECTIVE_LOG_LEVEL", test.env)
+
+			settings, err := runShow(t, append([]string{"--config", config}, test.args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if expected := [2]string{test.value, test.source}; settings["log_level"] != expected {
+				t.Errorf("unexpected log_level, got %v, expected %v", settings["log_level"], expected)
+			}
+		})
+	}
+
+	// The built-in profiles bundle the chunk size, hash algorithms and format
+	// of the shown command, and take precedence over the file.
+	settings, err := runShow(t, "--config", config, "--profile", "text", "delta")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, value := range map[string]string{
+		"chunk_size":  "64",
+		"weak_hash":   common.HashAdler32,
+		"strong_hash": common.HashSHA256,
+		"format":      common.FormatJSON,
+	} {
+		if expected := [2]string{value, "profile text (built-in)"}; settings[key] != expected {
+			t.Errorf("unexpected %s, got %v, expected %v", key, settings[key], expected)
+		}
+	}
+}
+
+func TestShowRejects(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("RDETECTIVE_CONFIG", "")
+	t.Setenv("RDETECTIVE_PROFILE", "")
+
+	tests := []struct {
+		name   string
+		config string
+		args   []string
+	}{
+		{"unknown setting", "chunk_size: 8\nbogus: 1\n", nil},
+		{"unknown profile setting", "profiles:\n  mine:\n    bogus: 1\n", []string{"--profile", "mine"}},
+		{"unknown profile", "chunk_size: 8\n", []string{"--profile", "nope"}},
+		{"unsupported hash", "weak_hash: md5\n", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := append([]string{"--config", writeConfig(t, test.config)}, test.args...)
+			if _, err := runShow(t, args...); !errors.Is(err, common.ErrInvalidConfiguration) {
+				t.Errorf("unexpected error, got %v, expected %v", err, common.ErrInvalidConfiguration)
+			}
+		})
+	}
+}
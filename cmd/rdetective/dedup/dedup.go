@@ -0,0 +1,235 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rdedup"
+)
+
+func CommandDedup() *cobra.Command {
+	dedupCmd := &cobra.Command{
+		Use:   "dedup",
+		Short: "Stores files in a deduplicating chunk store",
+	}
+
+	initCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Creates an empty chunk store",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			common.Exit(initStore(cmd))
+		},
+	}
+	common.SetDedupInitDefaults(initCmd)
+
+	addCmd := &cobra.Command{
+		Use:   "add file...",
+		Short: "Adds files, replacing earlier files with the same name",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(add(ctx, cmd, args))
+		},
+	}
+	common.SetDedupDefaults(addCmd)
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore file",
+		Short: "Restores a file from its chunks",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(restore(ctx, cmd, args[0]))
+		},
+	}
+	common.SetDedupRestoreDefaults(restoreCmd)
+
+	removeCmd := &cobra.Command{
+		Use:   "remove file...",
+		Short: "Removes files, their chunks are freed by gc",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			common.Exit(remove(cmd, args))
+		},
+	}
+	common.SetDedupDefaults(removeCmd)
+
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Removes chunks that no file refers to",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(gc(ctx, cmd))
+		},
+	}
+	common.SetDedupDefaults(gcCmd)
+
+	reportCmd := &cobra.Command{
+		Use:   "report",
+		Short: "Reports how well the stored files deduplicate",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			common.Exit(report(cmd))
+		},
+	}
+	common.SetDedupDefaults(reportCmd)
+
+	dedupCmd.AddCommand(initCmd, addCmd, restoreCmd, removeCmd, gcCmd, reportCmd)
+
+	return dedupCmd
+}
+
+// open applies the configuration of cmd and opens the configured store.
+func open(cmd *cobra.Command) (*rdedup.Store, error) {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return nil, fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	logger, err := common.NewLogger(!common.LogTimestamp, common.LogLevel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	return rdedup.Open(&rdedup.Config{
+		Logger: logger,
+		Dir:    common.ChunkStorePath,
+	})
+}
+
+func initStore(cmd *cobra.Command) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	logger, err := common.NewLogger(!common.LogTimestamp, common.LogLevel)
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	_, err = rdedup.Init(&rdedup.Config{
+		Logger: logger,
+		Dir:    common.ChunkStorePath,
+	}, rdedup.Settings{
+		ChunkSize: common.ChunkSize,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize chunk store: %w", err)
+	}
+
+	logger.Info("initialized chunk store in ", common.ChunkStorePath)
+
+	return nil
+}
+
+func add(ctx context.Context, cmd *cobra.Command, paths []string) error {
+	s, err := open(cmd)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		file, err := common.OpenFile("original", path)
+		if err != nil {
+			return err
+		}
+
+		_, stats, err := s.Add(ctx, fileName(path), file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", path, err)
+		}
+
+		fmt.Printf("%s: %d chunks, %d new with %d bytes\n", path, stats.Chunks, stats.NewChunks, stats.NewBytes)
+	}
+
+	return nil
+}
+
+func restore(ctx context.Context, cmd *cobra.Command, path string) error {
+	s, err := open(cmd)
+	if err != nil {
+		return err
+	}
+
+	output := common.Output
+	if output == "" {
+		output = path
+	}
+
+	return common.WriteFile(output, common.WriterToFunc(func(w io.Writer) (int64, error) {
+		if err := s.Restore(ctx, fileName(path), w); err != nil {
+			return 0, fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+
+		return 0, nil
+	}))
+}
+
+func remove(cmd *cobra.Command, paths []string) error {
+	s, err := open(cmd)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err = s.Remove(fileName(path)); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+func gc(ctx context.Context, cmd *cobra.Command) error {
+	s, err := open(cmd)
+	if err != nil {
+		return err
+	}
+
+	stats, err := s.GC(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to collect garbage: %w", err)
+	}
+
+	fmt.Printf("removed %d chunks with %d bytes\n", stats.Chunks, stats.Bytes)
+
+	return nil
+}
+
+func report(cmd *cobra.Command) error {
+	s, err := open(cmd)
+	if err != nil {
+		return err
+	}
+
+	r, err := s.Report()
+	if err != nil {
+		return fmt.Errorf("failed to generate report: %w", err)
+	}
+
+	fmt.Printf("files:        %d\n", r.Files)
+	fmt.Printf("size:         %d bytes\n", r.Size)
+	fmt.Printf("stored:       %d bytes in %d chunks\n", r.Stored, r.Chunks)
+	fmt.Printf("unreferenced: %d bytes in %d chunks\n", r.UnreferencedBytes, r.Unreferenced)
+	fmt.Printf("dedup ratio:  %.2f\n", r.Ratio())
+
+	return nil
+}
+
+// fileName returns the name of the file at path in the store.
+func fileName(path string) string {
+	return filepath.ToSlash(filepath.Clean(path))
+}
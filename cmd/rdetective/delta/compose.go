@@ -0,0 +1,54 @@
+package delta
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+func CommandCompose() *cobra.Command {
+	composeCmd := &cobra.Command{
+		Use:   "compose delta1 delta2 [delta...]",
+		Short: "Collapses a chain of deltas into a single delta",
+		Long: `Collapses a chain of deltas into a single delta.
+
+Each delta must have been generated from the result of the previous one. The
+result turns the original of the first delta into the result of the last one,
+none of the files are needed.`,
+		Args: cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			common.Exit(compose(cmd, args))
+		},
+	}
+
+	common.SetComposeDefaults(composeCmd)
+
+	return composeCmd
+}
+
+func compose(cmd *cobra.Command, paths []string) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	composed, err := readDelta(paths[0])
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths[1:] {
+		d, err := readDelta(path)
+		if err != nil {
+			return err
+		}
+
+		if composed, err = rdiff.Compose(composed, d); err != nil {
+			return fmt.Errorf("failed to compose %s: %w", path, err)
+		}
+	}
+
+	return writeDelta(composed)
+}
@@ -0,0 +1,138 @@
+package delta
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+func CommandDelta() *cobra.Command {
+	deltaCmd := &cobra.Command{
+		Use:   "delta",
+		Short: "Writes the delta from the original to the updated file",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(delta(ctx, cmd))
+		},
+	}
+
+	common.SetDeltaDefaults(deltaCmd)
+
+	return deltaCmd
+}
+
+func delta(ctx context.Context, cmd *cobra.Command) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	if err := requireFlags("original", common.OriginalFilePath, "updated", common.UpdatedFilePath); err != nil {
+		return err
+	}
+
+	logger, err := common.NewLogger(!common.LogTimestamp, common.LogLevel)
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	options := rdiff.Options{
+		Logger:    logger,
+		ChunkSize: common.ChunkSize,
+	}
+
+	signature, err := common.SignFile(ctx, options, "original", common.OriginalFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to generate signature: %w", err)
+	}
+
+	updated, err := common.OpenFile("updated", common.UpdatedFilePath)
+	if err != nil {
+		return err
+	}
+	defer updated.Close()
+
+	d, err := rdiff.NewDiffer(signature, options).DiffContext(ctx, updated)
+	if err != nil {
+		return fmt.Errorf("failed to generate delta: %w", err)
+	}
+
+	return writeDelta(d)
+}
+
+// requireFlags takes pairs of flag names and values and reports the first flag
+// without a value as missing source.
+func requireFlags(namesAndValues ...string) error {
+	for i := 0; i+1 < len(namesAndValues); i += 2 {
+		if namesAndValues[i+1] == "" {
+			return fmt.Errorf("%w: --%s is required", rdiff.ErrMissingSource, namesAndValues[i])
+		}
+	}
+
+	return nil
+}
+
+// writeDelta writes d to the output in the configured format, signed and
+// encrypted as configured (see common.WriteProtected).
+func writeDelta(d rdiff.Delta) error {
+	switch common.Format {
+	case common.FormatBinary:
+		return common.WriteProtected(common.Output, d)
+	case common.FormatJSON:
+		return common.WriteProtected(common.Output, common.WriterToFunc(func(w io.Writer) (int64, error) {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+
+			return 0, encoder.Encode(d)
+		}))
+	}
+
+	return fmt.Errorf("%w: unknown format %q, expected binary or json", common.ErrInvalidConfiguration, common.Format)
+}
+
+// readDelta reads the delta file at path, in either format, checking its
+// signature and decrypting it as configured (see common.ReadProtected).
+func readDelta(path string) (rdiff.Delta, error) {
+	var d rdiff.Delta
+	err := common.ReadProtected("delta", path, func(r io.Reader) error {
+		reader := bufio.NewReader(r)
+		if isJSON(reader) {
+			return json.NewDecoder(reader).Decode(&d)
+		}
+
+		var err error
+		d, err = rdiff.ReadDelta(reader)
+
+		return err
+	})
+	if err != nil {
+		return d, fmt.Errorf("failed to read delta: %w", err)
+	}
+
+	return d, nil
+}
+
+// isJSON reports whether the next character that is not JSON white space is
+// the start of an object, binary deltas start with their magic.
+func isJSON(reader *bufio.Reader) bool {
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return false
+		}
+
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			_ = reader.UnreadByte()
+			return c == '{'
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package delta
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+func CommandInvert() *cobra.Command {
+	invertCmd := &cobra.Command{
+		Use:   "invert",
+		Short: "Writes the rollback delta from the updated back to the original file",
+		Long: `Writes the rollback delta from the updated back to the original file.
+
+Only the original file and the delta to the updated file are needed, the
+updated file is reconstructed on the fly.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(invert(ctx, cmd))
+		},
+	}
+
+	common.SetPatchDefaults(invertCmd)
+	common.SetFormatDefaults(invertCmd)
+	common.SetSignDefaults(invertCmd)
+
+	return invertCmd
+}
+
+func invert(ctx context.Context, cmd *cobra.Command) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	if err := requireFlags("original", common.OriginalFilePath, "delta", common.DeltaFilePath); err != nil {
+		return err
+	}
+
+	d, err := readDelta(common.DeltaFilePath)
+	if err != nil {
+		return err
+	}
+
+	original, err := common.OpenFile("original", common.OriginalFilePath)
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	inverse, err := rdiff.InvertContext(ctx, original, d)
+	if err != nil {
+		return fmt.Errorf("failed to invert delta: %w", err)
+	}
+
+	return writeDelta(inverse)
+}
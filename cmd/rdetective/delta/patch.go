@@ -0,0 +1,71 @@
+package delta
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+func CommandPatch() *cobra.Command {
+	patchCmd := &cobra.Command{
+		Use:   "patch",
+		Short: "Applies a delta to the original file",
+		Long: `Applies a delta to the original file.
+
+Deltas record the size and sha256 of the original and the updated file. The
+original is checked before the delta is applied and the result before the
+output is replaced.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(patch(ctx, cmd))
+		},
+	}
+
+	common.SetPatchDefaults(patchCmd)
+
+	return patchCmd
+}
+
+func patch(ctx context.Context, cmd *cobra.Command) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	if err := requireFlags("original", common.OriginalFilePath, "delta", common.DeltaFilePath); err != nil {
+		return err
+	}
+
+	d, err := readDelta(common.DeltaFilePath)
+	if err != nil {
+		return err
+	}
+
+	original, err := common.OpenFile("original", common.OriginalFilePath)
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	if err = rdiff.VerifyOriginal(ctx, io.NewSectionReader(original, 0, math.MaxInt64), d); err != nil {
+		return err
+	}
+
+	return common.WriteFile(common.Output, common.WriterToFunc(func(w io.Writer) (int64, error) {
+		buffered := bufio.NewWriter(w)
+		if err := rdiff.Apply(original, d, buffered); err != nil {
+			return 0, fmt.Errorf("failed to apply delta: %w", err)
+		}
+
+		return 0, buffered.Flush()
+	}))
+}
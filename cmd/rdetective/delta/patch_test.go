@@ -0,0 +1,92 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/cmd/rdetective/internal/clitest"
+)
+
+func TestPatchVerifyKey(t *testing.T) {
+	binary := clitest.Build(t)
+	dir := t.TempDir()
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	run := func(args ...string) ([]byte, error) {
+		return exec.Command(binary, append(args, "--log-level", "warn")...).CombinedOutput()
+	}
+
+	updated := make([]byte, 100*1024)
+	rand.New(rand.NewSource(1)).Read(updated)
+	original := append(append([]byte{}, updated[:40000]...), updated[50000:]...)
+
+	if err := os.WriteFile(path("v1"), original, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path("v2"), updated, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"keygen", "-o", path("key")},
+		{"keygen", "-o", path("other")},
+		{"delta", "--original", path("v1"), "--updated", path("v2"), "-o", path("unsigned.delta")},
+		{"delta", "--original", path("v1"), "--updated", path("v2"), "--sign-key", path("key"), "-o", path("signed.delta")},
+	} {
+		if output, err := run(args...); err != nil {
+			t.Fatalf("error running %v: %s\n%s", args, err.Error(), output)
+		}
+	}
+
+	signed, err := os.ReadFile(path("signed.delta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte{}, signed...)
+	tampered[len(tampered)/2] ^= 0x01
+	if err = os.WriteFile(path("tampered.delta"), tampered, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := run("patch", "--original", path("v1"), "--delta", path("signed.delta"), "--verify-key", path("key.pub"), "-o", path("patched"))
+	if err != nil {
+		t.Fatalf("error patching: %s\n%s", err.Error(), output)
+	}
+
+	if patched, _ := os.ReadFile(path("patched")); !bytes.Equal(patched, updated) {
+		t.Errorf("unexpected content after patch, got %d bytes, expected %d bytes", len(patched), len(updated))
+	}
+
+	tests := []struct {
+		name  string
+		delta string
+		key   string
+	}{
+		{"unsigned", "unsigned.delta", "key.pub"},
+		{"tampered", "tampered.delta", "key.pub"},
+		{"other key", "signed.delta", "other.pub"},
+	}
+
+	for _, test := range tests {
+		output, err := run("patch", "--original", path("v1"), "--delta", path(test.delta), "--verify-key", path(test.key), "-o", path(test.name))
+
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != common.ExitVerificationFailed {
+			t.Errorf("%s: unexpected result, got %v, expected exit code %d\n%s", test.name, err, common.ExitVerificationFailed, output)
+		}
+
+		if _, err = os.Stat(path(test.name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: output written despite failed verification", test.name)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package delta
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+func CommandVerify() *cobra.Command {
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Checks that a delta applies to the original file",
+		Long: `Checks that a delta applies to the original file, without writing the result.
+
+The original must match the size and sha256 the delta records for it, and
+applying the delta must result in the recorded updated file.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(verify(ctx, cmd))
+		},
+	}
+
+	common.SetVerifyDefaults(verifyCmd)
+
+	return verifyCmd
+}
+
+func verify(ctx context.Context, cmd *cobra.Command) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	if err := requireFlags("original", common.OriginalFilePath, "delta", common.DeltaFilePath); err != nil {
+		return err
+	}
+
+	d, err := readDelta(common.DeltaFilePath)
+	if err != nil {
+		return err
+	}
+
+	if d.Original == nil || d.Updated == nil {
+		return fmt.Errorf("%w: delta does not record the digests of its files", rdiff.ErrVerificationFailed)
+	}
+
+	original, err := common.OpenFile("original", common.OriginalFilePath)
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	if err = rdiff.VerifyOriginal(ctx, io.NewSectionReader(original, 0, math.MaxInt64), d); err != nil {
+		return err
+	}
+
+	if err = rdiff.Apply(original, d, io.Discard); err != nil {
+		return fmt.Errorf("failed to apply delta: %w", err)
+	}
+
+	fmt.Printf("%s: delta applies, result has %s\n", common.OriginalFilePath, d.Updated)
+
+	return nil
+}
@@ -1,10 +1,14 @@
 package diff
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"sort"
+	"sync"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"github.com/sol1du2/rdetective/cmd/rdetective/common"
@@ -15,11 +19,11 @@ func CommandDiff() *cobra.Command {
 	diffCmd := &cobra.Command{
 		Use:   "diff",
 		Short: "Computes difference",
-		Run: func(_ *cobra.Command, _ []string) {
-			if err := diff(); err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-				os.Exit(1)
-			}
+		Run: func(cmd *cobra.Command, _ []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(diff(ctx, cmd))
 		},
 	}
 
@@ -28,33 +32,46 @@ func CommandDiff() *cobra.Command {
 	return diffCmd
 }
 
-func diff() error {
-	if err := common.ApplyConfiguration(); err != nil {
+func diff(ctx context.Context, cmd *cobra.Command) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
 		return fmt.Errorf("failed to apply configuration: %w", err)
 	}
 
-	logger, err := newLogger(!common.LogTimestamp, common.LogLevel)
+	logger, err := common.NewLogger(!common.LogTimestamp, common.LogLevel)
 	if err != nil {
 		return fmt.Errorf("failed to create logger: %w", err)
 	}
 
 	logger.Debugln("chunk size ", common.ChunkSize)
 	logger.Debugln("original file ", common.OriginalFilePath)
-	logger.Debugln("updated file ", common.UpdatedFilePath)
+	logger.Debugln("updated files ", common.UpdatedFilePaths)
 	logger.Debugln("diff start")
 
-	rd, err := rdiff.New(&rdiff.Config{
+	if common.OriginalFilePath == "" {
+		return fmt.Errorf("%w: original", rdiff.ErrMissingSource)
+	}
+
+	if len(common.UpdatedFilePaths) == 0 {
+		return fmt.Errorf("%w: updated", rdiff.ErrMissingSource)
+	}
+
+	options := rdiff.Options{
 		Logger:    logger,
 		ChunkSize: common.ChunkSize,
+	}
+	if err = options.Validate(); err != nil {
+		return err
+	}
 
-		OriginalSource: FileSource{fileName: common.OriginalFilePath},
-		UpdatedSource:  FileSource{fileName: common.UpdatedFilePath},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create rolling diff: %w", err)
+	// Progress is only shown if there is a single line of work to follow.
+	if common.Progress && len(common.UpdatedFilePaths) == 1 && isTerminal(os.Stderr) {
+		printer := newProgressPrinter(os.Stderr)
+		defer printer.Finish()
+
+		options.Progress = printer.Report
 	}
 
-	signature, err := rd.GenerateSignature()
+	signature, err := common.SignFile(ctx, options, "original", common.OriginalFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to generate signature: %w", err)
 	}
@@ -65,17 +82,92 @@ func diff() error {
 		logger.Info("chunk ", i, ", hash ", s.Adler32, ", bytes ", s.Window)
 	}
 
-	delta, err := rd.GenerateDelta()
+	deltas, err := diffFiles(ctx, rdiff.NewDiffer(signature, options), common.UpdatedFilePaths, common.Jobs)
 	if err != nil {
 		return fmt.Errorf("failed to generate delta: %w", err)
 	}
 
+	for i, delta := range deltas {
+		if len(deltas) == 1 {
+			logger.Info("\n---delta---")
+		} else {
+			logger.Info("\n---delta ", common.UpdatedFilePaths[i], "---")
+		}
+
+		printDelta(logger, signature, delta)
+	}
+
+	return nil
+}
+
+// diffFiles computes the deltas of all paths in parallel, using up to jobs
+// goroutines. The deltas are returned in the order of paths.
+func diffFiles(ctx context.Context, differ *rdiff.Differ, paths []string, jobs int) ([]rdiff.Delta, error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	deltas := make([]rdiff.Delta, len(paths))
+	errs := make([]error, len(paths))
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, jobs)
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			deltas[i], errs[i] = diffFile(ctx, differ, path)
+			if errs[i] != nil {
+				cancel() // No need to continue with the other files.
+			}
+		}(i, path)
+	}
+	wg.Wait()
+
+	// Report the root cause rather than the cancellations it caused.
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return deltas, nil
+}
+
+func diffFile(ctx context.Context, differ *rdiff.Differ, path string) (rdiff.Delta, error) {
+	reader, err := common.OpenFile("updated", path)
+	if err != nil {
+		return rdiff.Delta{}, err
+	}
+	defer reader.Close()
+
+	delta, err := differ.DiffContext(ctx, reader)
+	if err != nil {
+		return delta, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return delta, nil
+}
+
+func printDelta(logger logrus.FieldLogger, signature rdiff.Signature, delta rdiff.Delta) {
 	// Sort it by position for easy printing.
 	sort.SliceStable(delta.Changes, func(i, j int) bool {
 		return delta.Changes[i].Position < delta.Changes[j].Position
 	})
 
-	logger.Info("\n---delta---")
 	logger.Debugln(delta)
 	for _, v := range delta.Changes {
 		if len(v.NewBytes) > 0 {
@@ -92,6 +184,4 @@ func diff() error {
 	for _, index := range delta.MissingChunks {
 		logger.Info("chunk ", index, " is missing")
 	}
-
-	return nil
 }
@@ -0,0 +1,51 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+// isTerminal reports whether the file is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressPrinter renders a single, continuously updated progress line.
+type progressPrinter struct {
+	out   io.Writer
+	stage rdiff.Stage
+}
+
+func newProgressPrinter(out io.Writer) *progressPrinter {
+	return &progressPrinter{out: out}
+}
+
+func (p *progressPrinter) Report(progress rdiff.Progress) {
+	if p.stage != "" && p.stage != progress.Stage {
+		fmt.Fprintln(p.out)
+	}
+	p.stage = progress.Stage
+
+	if progress.Total > 0 {
+		percent := float64(progress.Processed) * 100 / float64(progress.Total)
+		fmt.Fprintf(p.out, "\r%s: %5.1f%% (%d/%d bytes)", progress.Stage, percent, progress.Processed, progress.Total)
+	} else {
+		fmt.Fprintf(p.out, "\r%s: %d bytes", progress.Stage, progress.Processed)
+	}
+}
+
+// Finish terminates the progress line, if any was printed.
+func (p *progressPrinter) Finish() {
+	if p.stage != "" {
+		fmt.Fprintln(p.out)
+		p.stage = ""
+	}
+}
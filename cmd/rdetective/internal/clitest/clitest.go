@@ -0,0 +1,27 @@
+// Package clitest helps testing the commands of the rdetective binary.
+package clitest
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// Build builds the rdetective binary into a temporary directory and returns
+// its path. The test is skipped if the go tool is not available.
+func Build(t *testing.T) string {
+	t.Helper()
+
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not available")
+	}
+
+	binary := filepath.Join(t.TempDir(), "rdetective")
+	output, err := exec.Command(goTool, "build", "-o", binary, "github.com/sol1du2/rdetective/cmd/rdetective").CombinedOutput()
+	if err != nil {
+		t.Fatalf("error building rdetective: %s\n%s", err.Error(), output)
+	}
+
+	return binary
+}
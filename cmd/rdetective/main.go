@@ -5,7 +5,14 @@ import (
 	"os"
 
 	"github.com/sol1du2/rdetective/cmd"
+	"github.com/sol1du2/rdetective/cmd/rdetective/config"
+	"github.com/sol1du2/rdetective/cmd/rdetective/dedup"
+	"github.com/sol1du2/rdetective/cmd/rdetective/delta"
 	"github.com/sol1du2/rdetective/cmd/rdetective/diff"
+	"github.com/sol1du2/rdetective/cmd/rdetective/remote"
+	"github.com/sol1du2/rdetective/cmd/rdetective/sign"
+	"github.com/sol1du2/rdetective/cmd/rdetective/store"
+	"github.com/sol1du2/rdetective/cmd/rdetective/watch"
 )
 
 func main() {
@@ -13,6 +20,21 @@ func main() {
 
 	cmd.RootCmd.AddCommand(cmd.CommandVersion())
 	cmd.RootCmd.AddCommand(diff.CommandDiff())
+	cmd.RootCmd.AddCommand(delta.CommandDelta())
+	cmd.RootCmd.AddCommand(delta.CommandPatch())
+	cmd.RootCmd.AddCommand(delta.CommandVerify())
+	cmd.RootCmd.AddCommand(delta.CommandInvert())
+	cmd.RootCmd.AddCommand(delta.CommandCompose())
+	cmd.RootCmd.AddCommand(sign.CommandKeygen())
+	cmd.RootCmd.AddCommand(store.CommandStore())
+	cmd.RootCmd.AddCommand(dedup.CommandDedup())
+	cmd.RootCmd.AddCommand(watch.CommandWatch())
+	cmd.RootCmd.AddCommand(remote.CommandServe())
+	cmd.RootCmd.AddCommand(remote.CommandSync())
+	cmd.RootCmd.AddCommand(remote.CommandHTTP())
+	cmd.RootCmd.AddCommand(remote.CommandPublish())
+	cmd.RootCmd.AddCommand(remote.CommandFetch())
+	cmd.RootCmd.AddCommand(config.CommandConfig())
 
 	if err := cmd.RootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
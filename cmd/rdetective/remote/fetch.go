@@ -0,0 +1,75 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rzsync"
+)
+
+func CommandFetch() *cobra.Command {
+	fetchCmd := &cobra.Command{
+		Use:   "fetch url",
+		Short: "Downloads a published file, reusing the blocks of a local older copy",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(fetch(ctx, cmd, args[0]))
+		},
+	}
+
+	common.SetFetchDefaults(fetchCmd)
+
+	return fetchCmd
+}
+
+func fetch(ctx context.Context, cmd *cobra.Command, controlURL string) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	logger, err := common.NewLogger(!common.LogTimestamp, common.LogLevel)
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	fetcher := rzsync.NewFetcher(&rzsync.FetcherConfig{
+		Logger: logger,
+	})
+
+	output := common.Output
+	if output == "" {
+		// Name the output after the published file.
+		control, err := fetcher.FetchControl(ctx, controlURL)
+		if err != nil {
+			return fmt.Errorf("failed to fetch control file: %w", err)
+		}
+
+		nameURL, err := url.Parse(control.Name)
+		if err != nil {
+			return fmt.Errorf("failed to fetch control file: %w", err)
+		}
+		output = path.Base(nameURL.Path)
+	}
+
+	local := common.Local
+	if local == "" {
+		local = output
+	}
+
+	stats, err := fetcher.Fetch(ctx, controlURL, local, output)
+	if err != nil {
+		return fmt.Errorf("failed to fetch: %w", err)
+	}
+
+	logger.Info("fetched ", stats.Size, " bytes to ", output, ", ", stats.Reused, " blocks reused, ", stats.Downloaded, " bytes downloaded")
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rhttp"
+)
+
+const (
+	// shutdownTimeout is how long running requests get to finish on shutdown.
+	shutdownTimeout = 10 * time.Second
+
+	// readHeaderTimeout and idleTimeout keep clients from holding connections
+	// open without sending requests. readTimeout bounds the whole request,
+	// which includes uploading the updated or original file.
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Minute
+	idleTimeout       = 2 * time.Minute
+)
+
+func CommandHTTP() *cobra.Command {
+	httpCmd := &cobra.Command{
+		Use:   "http",
+		Short: "Serves the signature, delta and patch HTTP API",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(serveHTTP(ctx, cmd))
+		},
+	}
+
+	common.SetHTTPDefaults(httpCmd)
+
+	return httpCmd
+}
+
+func serveHTTP(ctx context.Context, cmd *cobra.Command) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	logger, err := common.NewLogger(!common.LogTimestamp, common.LogLevel)
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", common.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	server := &http.Server{
+		Handler: rhttp.NewHandler(&rhttp.Config{
+			Logger:      logger,
+			ChunkSize:   common.ChunkSize,
+			MaxPartSize: common.MaxPartSize,
+		}),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	logger.Info("serving http on ", listener.Addr())
+
+	select {
+	case err = <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if err = <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
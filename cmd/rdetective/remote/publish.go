@@ -0,0 +1,56 @@
+package remote
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rzsync"
+)
+
+func CommandPublish() *cobra.Command {
+	publishCmd := &cobra.Command{
+		Use:   "publish file",
+		Short: "Writes the control file to fetch file from a static web server",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			common.Exit(publish(cmd, args[0]))
+		},
+	}
+
+	common.SetPublishDefaults(publishCmd)
+
+	return publishCmd
+}
+
+func publish(cmd *cobra.Command, path string) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	output := common.Output
+	if output == "" {
+		output = path + ".rdz"
+	}
+
+	// The control file refers to the file relative to itself.
+	name, err := filepath.Rel(filepath.Dir(output), path)
+	if err != nil {
+		name = filepath.Base(path)
+	}
+
+	file, err := common.OpenFile("original", path)
+	if err != nil {
+		return fmt.Errorf("failed to publish: %w", err)
+	}
+	defer file.Close()
+
+	control, err := rzsync.Publish(file, filepath.ToSlash(name), common.ChunkSize)
+	if err != nil {
+		return fmt.Errorf("failed to publish: %w", err)
+	}
+
+	return common.WriteFile(output, control)
+}
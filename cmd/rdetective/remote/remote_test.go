@@ -0,0 +1,113 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/internal/clitest"
+	"github.com/sol1du2/rdetective/rsync"
+)
+
+func TestSyncRemoteCmd(t *testing.T) {
+	binary := clitest.Build(t)
+	root := t.TempDir()
+	local := filepath.Join(t.TempDir(), "local")
+
+	updated := make([]byte, 100*1024)
+	rand.New(rand.NewSource(1)).Read(updated)
+	original := append(append([]byte{}, updated[:40000]...), updated[50000:]...)
+
+	if err := os.WriteFile(filepath.Join(root, "file"), updated, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(local, original, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	remoteCmd := binary + " server --stdio --log-level warn --root " + root
+
+	output, err := exec.Command(binary, "sync", "--remote-cmd", remoteCmd, "file", local).CombinedOutput()
+	if err != nil {
+		t.Fatalf("error syncing: %s\n%s", err.Error(), output)
+	}
+
+	synced, err := os.ReadFile(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(synced, updated) {
+		t.Errorf("unexpected content after sync, got %d bytes, expected %d bytes", len(synced), len(updated))
+	}
+
+	// A missing remote file must fail and leave the local file alone.
+	output, err = exec.Command(binary, "sync", "--remote-cmd", remoteCmd, "missing", local).CombinedOutput()
+	if err == nil {
+		t.Errorf("expected error syncing missing file, got output:\n%s", output)
+	}
+
+	if !bytes.Contains(output, []byte("missing: no such file")) {
+		t.Errorf("unexpected output syncing missing file:\n%s", output)
+	}
+
+	synced, err = os.ReadFile(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(synced, updated) {
+		t.Errorf("local file changed by failed sync")
+	}
+}
+
+func TestServeStdioInterrupted(t *testing.T) {
+	binary := clitest.Build(t)
+	root := t.TempDir()
+	local := filepath.Join(t.TempDir(), "local")
+
+	if err := os.WriteFile(filepath.Join(root, "file"), []byte("hello world"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(binary, "serve", "--stdio", "--log-level", "warn", "--root", root)
+	conn, err := rsync.StartCommand(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the sync is done the server waits for the next request on stdin,
+	// which is left open.
+	client := rsync.NewClient(&rsync.ClientConfig{Logger: &logrus.Logger{Out: io.Discard, Formatter: &logrus.TextFormatter{}}, ChunkSize: 4})
+	if _, err = client.Sync(context.Background(), conn, "file", local); err != nil {
+		t.Fatalf("error syncing: %s", err.Error())
+	}
+
+	if err = cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-exited:
+		if err != nil {
+			t.Errorf("unexpected error stopping the server: %s", err.Error())
+		}
+	case <-time.After(10 * time.Second):
+		_ = cmd.Process.Kill()
+		t.Fatal("server did not stop on interrupt")
+	}
+}
@@ -0,0 +1,93 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rsync"
+)
+
+func CommandServe() *cobra.Command {
+	serveCmd := &cobra.Command{
+		Use:     "serve",
+		Aliases: []string{"server"},
+		Short:   "Serves files to sync clients over TCP or stdio",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(serve(ctx, cmd))
+		},
+	}
+
+	common.SetServeDefaults(serveCmd)
+
+	return serveCmd
+}
+
+func serve(ctx context.Context, cmd *cobra.Command) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	logger, err := common.NewLogger(!common.LogTimestamp, common.LogLevel)
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	server := rsync.NewServer(&rsync.ServerConfig{
+		Logger:       logger,
+		Root:         common.Root,
+		MaxFrameSize: common.MaxFrameSize,
+	})
+
+	if common.Stdio {
+		// Stdout carries the protocol, logs go to stderr.
+		err = server.ServeConn(ctx, newStdio())
+		if ctx.Err() != nil {
+			// Reading the closed stdin failed, stop like Serve does.
+			return nil
+		}
+
+		return err
+	}
+
+	listener, err := net.Listen("tcp", common.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	logger.WithField("root", common.Root).Info("serving on ", listener.Addr())
+
+	return server.Serve(ctx, listener)
+}
+
+// stdio is the connection of a --stdio server. ServeConn closes it once ctx is
+// done, which unblocks a pending read of stdin.
+type stdio struct {
+	in  *os.File
+	out *os.File
+}
+
+func (s stdio) Read(p []byte) (int, error) {
+	return s.in.Read(p)
+}
+
+func (s stdio) Write(p []byte) (int, error) {
+	return s.out.Write(p)
+}
+
+func (s stdio) Close() error {
+	err := s.in.Close()
+	if outErr := s.out.Close(); err == nil {
+		err = outErr
+	}
+
+	return err
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package remote
+
+import "os"
+
+// newStdio returns the connection of a --stdio server. Closing it might not
+// unblock a pending read on this platform.
+func newStdio() stdio {
+	return stdio{in: os.Stdin, out: os.Stdout}
+}
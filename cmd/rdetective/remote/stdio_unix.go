@@ -0,0 +1,29 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package remote
+
+import (
+	"os"
+	"syscall"
+)
+
+// newStdio returns the connection of a --stdio server. Stdin and stdout are
+// switched to non-blocking mode, which lets the runtime poll them, so that
+// closing them unblocks pending reads and writes.
+func newStdio() stdio {
+	return stdio{
+		in:  nonBlocking(syscall.Stdin, os.Stdin),
+		out: nonBlocking(syscall.Stdout, os.Stdout),
+	}
+}
+
+// nonBlocking returns a new file for fd in non-blocking mode, or file if the
+// mode cannot be changed.
+func nonBlocking(fd int, file *os.File) *os.File {
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		return file
+	}
+
+	return os.NewFile(uintptr(fd), file.Name())
+}
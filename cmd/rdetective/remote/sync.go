@@ -0,0 +1,98 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rsync"
+)
+
+func CommandSync() *cobra.Command {
+	syncCmd := &cobra.Command{
+		Use:   "sync [host:port/]path local-file",
+		Short: "Updates a local file to match a file served by rdetective serve",
+		Long: `Updates a local file to match a file served by rdetective serve.
+
+The remote file is either given as host:port/path to connect over TCP, or as a
+path when --remote-cmd is used. The remote command is run with sh and must
+speak the protocol on its stdin and stdout, for example:
+
+  rdetective sync --remote-cmd "ssh host rdetective server --stdio --root /srv" path local-file`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(sync(ctx, cmd, args[0], args[1]))
+		},
+	}
+
+	common.SetSyncDefaults(syncCmd)
+
+	return syncCmd
+}
+
+func sync(ctx context.Context, cmd *cobra.Command, remote, local string) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	logger, err := common.NewLogger(!common.LogTimestamp, common.LogLevel)
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	conn, remotePath, err := connect(ctx, remote)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer func() {
+		if closeErr := conn.Close(); closeErr != nil {
+			logger.WithError(closeErr).Warnln("failed to close connection")
+		}
+	}()
+
+	stats, err := rsync.NewClient(&rsync.ClientConfig{
+		Logger:    logger,
+		ChunkSize: common.ChunkSize,
+		Tree:      common.Tree,
+	}).Sync(ctx, conn, remotePath, local)
+	if err != nil {
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+
+	logger.Info("synced ", stats.Size, " bytes, ", stats.Matched, " chunks reused, ", stats.Literal, " bytes transferred")
+
+	return nil
+}
+
+// connect opens the connection to the remote, either by running the remote
+// command or over TCP, and returns it along with the remote path.
+func connect(ctx context.Context, remote string) (io.ReadWriteCloser, string, error) {
+	if common.RemoteCmd != "" {
+		cmd := exec.CommandContext(ctx, "sh", "-c", common.RemoteCmd)
+		cmd.Stderr = os.Stderr
+
+		conn, err := rsync.StartCommand(cmd)
+
+		return conn, remote, err
+	}
+
+	slash := strings.Index(remote, "/")
+	if slash < 0 {
+		return nil, "", fmt.Errorf("invalid remote %q, expected host:port/path", remote)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", remote[:slash])
+
+	return conn, remote[slash+1:], err
+}
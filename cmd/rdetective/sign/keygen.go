@@ -0,0 +1,99 @@
+package sign
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rcrypt"
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rsign"
+)
+
+// publicKeyExtension is appended to the name of the private key file for the
+// public key.
+const publicKeyExtension = ".pub"
+
+func CommandKeygen() *cobra.Command {
+	keygenCmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generates a key pair to sign deltas with",
+		Long: `Generates an ed25519 key pair to sign deltas with.
+
+The private key is written to the output file and is passed to --sign-key when
+deltas are generated. The public key is written next to it with .pub appended
+and is passed to --verify-key when deltas are applied. Existing keys are never
+overwritten.
+
+With --encryption a hex encoded key file of 32 random bytes is written instead,
+which is passed to --encryption-key to encrypt and decrypt deltas.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			common.Exit(keygen(cmd))
+		},
+	}
+
+	common.SetKeygenDefaults(keygenCmd)
+
+	return keygenCmd
+}
+
+func keygen(cmd *cobra.Command) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	if common.Output == "" || common.Output == "-" {
+		return fmt.Errorf("%w: --output is required", rdiff.ErrMissingSource)
+	}
+
+	if common.Encryption {
+		return encryptionKeygen()
+	}
+
+	for _, name := range []string{common.Output, common.Output + publicKeyExtension} {
+		if _, err := os.Stat(name); err == nil {
+			return fmt.Errorf("%s already exists", name)
+		}
+	}
+
+	public, private, err := rsign.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	encodedPrivate, err := rsign.MarshalPrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	encodedPublic, err := rsign.MarshalPublicKey(public)
+	if err != nil {
+		return err
+	}
+
+	if err = common.WriteKeyFile(common.Output, bytes.NewReader(encodedPrivate)); err != nil {
+		return err
+	}
+
+	return common.WriteFile(common.Output+publicKeyExtension, bytes.NewReader(encodedPublic))
+}
+
+// encryptionKeygen writes a new key file for --encryption-key.
+func encryptionKeygen() error {
+	if _, err := os.Stat(common.Output); err == nil {
+		return fmt.Errorf("%s already exists", common.Output)
+	}
+
+	key, err := rcrypt.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	return common.WriteKeyFile(common.Output, strings.NewReader(hex.EncodeToString(key)+"\n"))
+}
@@ -0,0 +1,262 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rstore"
+)
+
+func CommandStore() *cobra.Command {
+	storeCmd := &cobra.Command{
+		Use:   "store",
+		Short: "Keeps the history of files as snapshots and deltas",
+	}
+
+	initCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Creates an empty store",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			common.Exit(initStore(cmd))
+		},
+	}
+	common.SetStoreInitDefaults(initCmd)
+
+	addCmd := &cobra.Command{
+		Use:   "add file...",
+		Short: "Adds the current content of files as their next version",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(add(ctx, cmd, args))
+		},
+	}
+	common.SetStoreDefaults(addCmd)
+
+	logCmd := &cobra.Command{
+		Use:   "log [file]",
+		Short: "Lists the versions of a file, or all files with their latest version",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			common.Exit(log(cmd, args))
+		},
+	}
+	common.SetStoreDefaults(logCmd)
+
+	checkoutCmd := &cobra.Command{
+		Use:   "checkout file version",
+		Short: "Restores a version of a file",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(checkout(ctx, cmd, args[0], args[1]))
+		},
+	}
+	common.SetStoreCheckoutDefaults(checkoutCmd)
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune file",
+		Short: "Drops all but the newest versions of a file",
+		Long: `Drops all but the newest versions of a file.
+
+If the oldest kept version is stored as delta it is rebased into a snapshot
+first, so that all kept versions can still be checked out.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(prune(ctx, cmd, args[0]))
+		},
+	}
+	common.SetStorePruneDefaults(pruneCmd)
+
+	storeCmd.AddCommand(initCmd, addCmd, logCmd, checkoutCmd, pruneCmd)
+
+	return storeCmd
+}
+
+// open applies the configuration of cmd and opens the configured store.
+func open(cmd *cobra.Command) (*rstore.Store, error) {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return nil, fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	logger, err := common.NewLogger(!common.LogTimestamp, common.LogLevel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	return rstore.Open(&rstore.Config{
+		Logger: logger,
+		Dir:    common.StorePath,
+	})
+}
+
+func initStore(cmd *cobra.Command) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	logger, err := common.NewLogger(!common.LogTimestamp, common.LogLevel)
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	_, err = rstore.Init(&rstore.Config{
+		Logger: logger,
+		Dir:    common.StorePath,
+	}, rstore.Settings{
+		ChunkSize:        common.ChunkSize,
+		SnapshotInterval: common.SnapshotInterval,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize store: %w", err)
+	}
+
+	logger.Info("initialized store in ", common.StorePath)
+
+	return nil
+}
+
+func add(ctx context.Context, cmd *cobra.Command, paths []string) error {
+	s, err := open(cmd)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		file, err := common.OpenFile("updated", path)
+		if err != nil {
+			return err
+		}
+
+		version, err := s.Add(ctx, fileName(path), file)
+		file.Close()
+
+		switch {
+		case errors.Is(err, rstore.ErrUnchanged):
+			fmt.Printf("%s: unchanged since version %d\n", path, version.Version)
+		case err != nil:
+			return fmt.Errorf("failed to add %s: %w", path, err)
+		default:
+			fmt.Printf("%s: added version %d (%s, %d bytes)\n", path, version.Version, kind(version), version.Stored)
+		}
+	}
+
+	return nil
+}
+
+func log(cmd *cobra.Command, args []string) error {
+	s, err := open(cmd)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if len(args) == 1 {
+		versions, err := s.Log(fileName(args[0]))
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w, "VERSION\tTIME\tSIZE\tSTORED\tSHA256")
+		for _, version := range versions {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%s, %d\t%s\n", version.Version, version.Time.Local().Format("2006-01-02 15:04:05"),
+				version.Size, kind(version), version.Stored, version.SHA256)
+		}
+
+		return w.Flush()
+	}
+
+	files, err := s.Files()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "FILE\tVERSIONS\tLATEST\tTIME\tSIZE")
+	for _, name := range files {
+		versions, err := s.Log(name)
+		if err != nil {
+			return err
+		}
+
+		if len(versions) == 0 {
+			continue
+		}
+
+		latest := versions[len(versions)-1]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\n", name, len(versions), latest.Version,
+			latest.Time.Local().Format("2006-01-02 15:04:05"), latest.Size)
+	}
+
+	return w.Flush()
+}
+
+func checkout(ctx context.Context, cmd *cobra.Command, path, versionArg string) error {
+	s, err := open(cmd)
+	if err != nil {
+		return err
+	}
+
+	version, err := strconv.Atoi(versionArg)
+	if err != nil {
+		return fmt.Errorf("%w: invalid version %q", rstore.ErrInvalidArgument, versionArg)
+	}
+
+	output := common.Output
+	if output == "" {
+		output = path
+	}
+
+	return common.WriteFile(output, common.WriterToFunc(func(w io.Writer) (int64, error) {
+		if err := s.Checkout(ctx, fileName(path), version, w); err != nil {
+			return 0, fmt.Errorf("failed to check out version %d of %s: %w", version, path, err)
+		}
+
+		return 0, nil
+	}))
+}
+
+func prune(ctx context.Context, cmd *cobra.Command, path string) error {
+	s, err := open(cmd)
+	if err != nil {
+		return err
+	}
+
+	dropped, err := s.Prune(ctx, fileName(path), common.Keep)
+	if err != nil {
+		return fmt.Errorf("failed to prune %s: %w", path, err)
+	}
+
+	fmt.Printf("%s: dropped %d versions\n", path, len(dropped))
+
+	return nil
+}
+
+// fileName returns the name of the file at path in the store.
+func fileName(path string) string {
+	return filepath.ToSlash(filepath.Clean(path))
+}
+
+func kind(version rstore.Version) string {
+	if version.Snapshot {
+		return "snapshot"
+	}
+
+	return "delta"
+}
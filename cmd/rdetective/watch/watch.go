@@ -0,0 +1,78 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sol1du2/rdetective/cmd/rdetective/common"
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rwatch"
+)
+
+func CommandWatch() *cobra.Command {
+	watchCmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Emits a delta whenever a file changes",
+		Long: `Emits a delta whenever a file changes.
+
+Each delta is relative to the previous change. Deltas are written to a
+directory with --output-dir, passed to a --hook command on stdin, or written
+to stdout as JSON lines if neither is set.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			ctx, stop := common.SignalContext()
+			defer stop()
+
+			common.Exit(watch(ctx, cmd))
+		},
+	}
+
+	common.SetWatchDefaults(watchCmd)
+
+	return watchCmd
+}
+
+func watch(ctx context.Context, cmd *cobra.Command) error {
+	if err := common.ApplyConfiguration(cmd); err != nil {
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	logger, err := common.NewLogger(!common.LogTimestamp, common.LogLevel)
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	if common.WatchFilePath == "" {
+		return fmt.Errorf("%w: --file is required", rdiff.ErrMissingSource)
+	}
+
+	var emitters []rwatch.EmitFunc
+	if common.OutputDir != "" {
+		if err = os.MkdirAll(common.OutputDir, 0o755); err != nil {
+			return err
+		}
+
+		emitters = append(emitters, rwatch.WriteDir(common.OutputDir))
+	}
+
+	if common.Hook != "" {
+		emitters = append(emitters, rwatch.RunHook(ctx, common.Hook, logger))
+	}
+
+	if len(emitters) == 0 {
+		emitters = append(emitters, rwatch.WriteJSONLines(os.Stdout))
+	}
+
+	watcher := rwatch.New(&rwatch.Config{
+		Logger:    logger,
+		ChunkSize: common.ChunkSize,
+		Debounce:  common.Debounce,
+	})
+
+	logger.Info("watching ", common.WatchFilePath)
+
+	return watcher.Watch(ctx, common.WatchFilePath, rwatch.Emitters(emitters...))
+}
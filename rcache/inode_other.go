@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package rcache
+
+import "os"
+
+// inode returns 0, the file identity is limited to the path, size and
+// modification time on this platform.
+func inode(os.FileInfo) uint64 {
+	return 0
+}
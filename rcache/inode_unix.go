@@ -0,0 +1,18 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package rcache
+
+import (
+	"os"
+	"syscall"
+)
+
+// inode returns the inode number of the file described by info.
+func inode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+
+	return 0
+}
@@ -0,0 +1,212 @@
+// Package rcache caches the signatures of files on disk, so that unchanged
+// files do not have to be read and hashed again.
+//
+// Every file and chunk size has one entry in the cache directory. An entry
+// records the identity of the file it was generated from, its path, size,
+// modification time and inode, and is only used while all of them still match
+// the file. Otherwise the file is signed again and the entry replaced.
+//
+// Entries store the strong hash of every chunk, so a cached signature matches
+// the same chunks as the signature of the file itself and both result in the
+// same deltas.
+//
+// The cache is not consulted by rdiff itself, RollingDiff.GenerateSignature and
+// Signer always read their source. Callers that want cached signatures of
+// files sign them with Cache.Sign instead, like the diff and delta commands do.
+package rcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rfile"
+)
+
+const entryExtension = ".sig"
+
+type Config struct {
+	Logger logrus.FieldLogger
+
+	// Dir is the directory of the cache, it is created when needed.
+	Dir string
+}
+
+type Cache struct {
+	config *Config
+
+	// signed is called whenever a file is signed rather than read from the
+	// cache, for tests.
+	signed func()
+}
+
+func New(config *Config) *Cache {
+	return &Cache{
+		config: config,
+	}
+}
+
+// key identifies the content of a file without reading it.
+type key struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	ModTime   int64  `json:"mod_time"`
+	Inode     uint64 `json:"inode"`
+	ChunkSize int    `json:"chunk_size"`
+}
+
+// fileKey returns the key of the opened file for the given options.
+func fileKey(file *os.File, options rdiff.Options) (key, error) {
+	path, err := filepath.Abs(file.Name())
+	if err != nil {
+		return key{}, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return key{}, err
+	}
+
+	return key{
+		Path:      path,
+		Size:      info.Size(),
+		ModTime:   info.ModTime().UnixNano(),
+		Inode:     inode(info),
+		ChunkSize: options.ChunkSize,
+	}, nil
+}
+
+// Sign returns the signature of file, which must be at its start. The
+// signature is read from the cache if the file did not change since it was
+// cached, otherwise the file is signed with options and the result cached.
+// Failures to use the cache are logged and the file is signed as if there was
+// no cache.
+func (c *Cache) Sign(ctx context.Context, options rdiff.Options, file *os.File) (rdiff.Signature, error) {
+	if err := options.Validate(); err != nil {
+		return rdiff.Signature{}, err
+	}
+
+	before, err := fileKey(file, options)
+	if err != nil {
+		return rdiff.Signature{}, &rdiff.SourceError{Source: "original", Op: "read", Err: err}
+	}
+
+	logger := c.config.Logger.WithField("file", before.Path)
+	entry := c.entryPath(before)
+
+	signature, err := readEntry(entry, before)
+	switch {
+	case err == nil:
+		logger.Debugln("using cached signature")
+		return signature, nil
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, errStale):
+	default:
+		logger.WithError(err).Warnln("failed to read cached signature")
+	}
+
+	if c.signed != nil {
+		c.signed()
+	}
+
+	if signature, err = rdiff.NewSigner(options).SignContext(ctx, file); err != nil {
+		return signature, err
+	}
+
+	// Only cache the signature if the file did not change while signing it.
+	if after, err := fileKey(file, options); err != nil || after != before {
+		logger.Debugln("file changed while signing, not caching its signature")
+		return signature, nil
+	}
+
+	if err = c.writeEntry(entry, before, signature); err != nil {
+		logger.WithError(err).Warnln("failed to cache signature")
+	}
+
+	return signature, nil
+}
+
+// entryPath returns the path of the entry for the file and chunk size of k.
+func (c *Cache) entryPath(k key) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", k.ChunkSize, k.Path)))
+
+	return filepath.Join(c.config.Dir, hex.EncodeToString(sum[:])+entryExtension)
+}
+
+// errStale is returned by readEntry for entries of an older version of a file.
+var errStale = errors.New("stale cache entry")
+
+// readEntry reads the signature from the entry at name if it was generated for
+// the file identified by k. Entries are the key encoded as one line of JSON,
+// followed by the encoded signature.
+func readEntry(name string, k key) (rdiff.Signature, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return rdiff.Signature{}, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return rdiff.Signature{}, fmt.Errorf("%w: %v", rdiff.ErrCorruptInput, err)
+	}
+
+	var cached key
+	if err = json.Unmarshal(line, &cached); err != nil {
+		return rdiff.Signature{}, fmt.Errorf("%w: %v", rdiff.ErrCorruptInput, err)
+	}
+
+	if cached != k {
+		return rdiff.Signature{}, errStale
+	}
+
+	signature, err := rdiff.ReadSignature(reader)
+	if err != nil {
+		return rdiff.Signature{}, err
+	}
+
+	if signature.ChunkSize != k.ChunkSize {
+		return rdiff.Signature{}, fmt.Errorf("%w: unexpected chunk size %d", rdiff.ErrCorruptInput, signature.ChunkSize)
+	}
+
+	// Entries of older versions only have the weak hashes of the chunks, which
+	// would match different chunks than the signature of the file itself.
+	if signature.Weak() {
+		return rdiff.Signature{}, errStale
+	}
+
+	return signature, nil
+}
+
+// writeEntry atomically replaces the entry at name.
+func (c *Cache) writeEntry(name string, k key, signature rdiff.Signature) error {
+	if err := os.MkdirAll(c.config.Dir, 0o755); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(append(line, '\n'))
+	if _, err = signature.WriteTo(&buf); err != nil {
+		return err
+	}
+
+	return rfile.WriteFile(name, 0o644, func(f *os.File) error {
+		_, err := buf.WriteTo(f)
+		return err
+	})
+}
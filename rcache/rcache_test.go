@@ -0,0 +1,312 @@
+package rcache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+// getCache returns a cache and a pointer to the number of files it signed.
+func getCache(t *testing.T) (*Cache, *int) {
+	c := New(&Config{
+		Logger: &logrus.Logger{
+			Out:       io.Discard,
+			Formatter: &logrus.TextFormatter{},
+			Level:     logrus.DebugLevel,
+		},
+		Dir: filepath.Join(t.TempDir(), "cache"),
+	})
+
+	signed := 0
+	c.signed = func() { signed++ }
+
+	return c, &signed
+}
+
+func sign(t *testing.T, c *Cache, path string, chunkSize int) rdiff.Signature {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	signature, err := c.Sign(context.Background(), rdiff.Options{ChunkSize: chunkSize}, file)
+	if err != nil {
+		t.Fatalf("error signing %s: %s", path, err.Error())
+	}
+
+	return signature
+}
+
+// writeFile writes data to path in place, keeping its inode, and sets its
+// modification time.
+func writeFile(t *testing.T, path, data string, modTime time.Time) {
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func compareSignatures(t *testing.T, got, expected rdiff.Signature) {
+	if got.ChunkSize != expected.ChunkSize || len(got.Chunks) != len(expected.Chunks) {
+		t.Fatalf("unexpected signature, got %d chunks of %d, expected %d chunks of %d",
+			len(got.Chunks), got.ChunkSize, len(expected.Chunks), expected.ChunkSize)
+	}
+
+	for i := range got.Chunks {
+		if got.Chunks[i].Adler32 != expected.Chunks[i].Adler32 {
+			t.Errorf("unexpected hash of chunk %d, got %d, expected %d", i, got.Chunks[i].Adler32, expected.Chunks[i].Adler32)
+		}
+	}
+}
+
+func TestSignCached(t *testing.T) {
+	c, signed := getCache(t)
+	path := filepath.Join(t.TempDir(), "file")
+	modTime := time.Now().Add(-time.Hour)
+	writeFile(t, path, "hello world", modTime)
+
+	expected, err := rdiff.NewSigner(rdiff.Options{ChunkSize: 4}).Sign(mustOpen(t, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compareSignatures(t, sign(t, c, path, 4), expected)
+	compareSignatures(t, sign(t, c, path, 4), expected)
+	if *signed != 1 {
+		t.Errorf("unexpected number of signed files, got %d, expected %d", *signed, 1)
+	}
+
+	// A cached signature can be used by a Differ.
+	delta, err := rdiff.NewDiffer(sign(t, c, path, 4), rdiff.Options{}).Diff(mustOpen(t, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(delta.MissingChunks) != 0 {
+		t.Errorf("unexpected missing chunks %v", delta.MissingChunks)
+	}
+}
+
+func TestSignInvalidation(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name string
+		// change changes the file at path, it returns the path to sign next
+		// and the chunk size to sign it with.
+		change func(t *testing.T, path string) (string, int)
+	}{{
+		name: "path",
+		change: func(t *testing.T, path string) (string, int) {
+			moved := path + ".moved"
+			if err := os.Rename(path, moved); err != nil {
+				t.Fatal(err)
+			}
+
+			return moved, 4
+		},
+	}, {
+		name: "size",
+		change: func(t *testing.T, path string) (string, int) {
+			writeFile(t, path, "hello world!", modTime)
+			return path, 4
+		},
+	}, {
+		name: "mtime",
+		change: func(t *testing.T, path string) (string, int) {
+			writeFile(t, path, "hello World", modTime.Add(time.Second))
+			return path, 4
+		},
+	}, {
+		name: "inode",
+		change: func(t *testing.T, path string) (string, int) {
+			if inode(mustStat(t, path)) == 0 {
+				t.Skip("inodes are not supported")
+			}
+
+			writeFile(t, path+".tmp", "hello World", modTime)
+			if err := os.Rename(path+".tmp", path); err != nil {
+				t.Fatal(err)
+			}
+
+			return path, 4
+		},
+	}, {
+		name: "chunk size",
+		change: func(t *testing.T, path string) (string, int) {
+			return path, 3
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, signed := getCache(t)
+			path := filepath.Join(t.TempDir(), "file")
+			writeFile(t, path, "hello world", modTime)
+			sign(t, c, path, 4)
+
+			path, chunkSize := tt.change(t, path)
+			expected, err := rdiff.NewSigner(rdiff.Options{ChunkSize: chunkSize}).Sign(mustOpen(t, path))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compareSignatures(t, sign(t, c, path, chunkSize), expected)
+			if *signed != 2 {
+				t.Errorf("unexpected number of signed files, got %d, expected %d", *signed, 2)
+			}
+
+			// The new signature is cached in turn.
+			sign(t, c, path, chunkSize)
+			if *signed != 2 {
+				t.Errorf("unexpected number of signed files, got %d, expected %d", *signed, 2)
+			}
+		})
+	}
+}
+
+func TestSignCorruptEntry(t *testing.T) {
+	c, signed := getCache(t)
+	path := filepath.Join(t.TempDir(), "file")
+	writeFile(t, path, "hello world", time.Now().Add(-time.Hour))
+	expected := sign(t, c, path, 4)
+
+	entries, err := filepath.Glob(filepath.Join(c.config.Dir, "*"+entryExtension))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected cache entries %v, %v", entries, err)
+	}
+
+	if err = os.WriteFile(entries[0], []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	compareSignatures(t, sign(t, c, path, 4), expected)
+	if *signed != 2 {
+		t.Errorf("unexpected number of signed files, got %d, expected %d", *signed, 2)
+	}
+}
+
+func TestCachedDelta(t *testing.T) {
+	// Small chunks of random data have many adler32 collisions, deltas from a
+	// cached signature must match the same chunks as from a fresh one.
+	r := rand.New(rand.NewSource(1))
+	original := make([]byte, 64*1024)
+	r.Read(original)
+	updated := make([]byte, 1024*1024)
+	r.Read(updated)
+	copy(updated[512*1024:], original[:32*1024])
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "original")
+	writeFile(t, path, string(original), time.Now().Add(-time.Hour))
+
+	c, signed := getCache(t)
+	diff := func() []byte {
+		delta, err := rdiff.NewDiffer(sign(t, c, path, 16), rdiff.Options{}).Diff(bytes.NewReader(updated))
+		if err != nil {
+			t.Fatalf("error generating delta: %s", err.Error())
+		}
+
+		var encoded bytes.Buffer
+		if _, err = delta.WriteTo(&encoded); err != nil {
+			t.Fatal(err)
+		}
+
+		return encoded.Bytes()
+	}
+
+	uncached, cached := diff(), diff()
+	if *signed != 1 {
+		t.Errorf("unexpected number of signed files, got %d, expected %d", *signed, 1)
+	}
+
+	if !bytes.Equal(cached, uncached) {
+		t.Errorf("unexpected delta from the cached signature")
+	}
+
+	delta, err := rdiff.ReadDelta(bytes.NewReader(cached))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var patched bytes.Buffer
+	if err = rdiff.Apply(bytes.NewReader(original), delta, &patched); err != nil {
+		t.Fatalf("error applying delta: %s", err.Error())
+	}
+
+	if !bytes.Equal(patched.Bytes(), updated) {
+		t.Errorf("unexpected result of patch")
+	}
+}
+
+func TestSignWeakEntry(t *testing.T) {
+	c, signed := getCache(t)
+	path := filepath.Join(t.TempDir(), "file")
+	writeFile(t, path, "hello world", time.Now().Add(-time.Hour))
+	expected := sign(t, c, path, 4)
+
+	entries, err := filepath.Glob(filepath.Join(c.config.Dir, "*"+entryExtension))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected cache entries %v, %v", entries, err)
+	}
+
+	// Replace the entry with one of an older version, without strong hashes.
+	data, err := os.ReadFile(entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := bytes.NewBuffer(data[:bytes.IndexByte(data, '\n')+1])
+	weak := rdiff.Signature{ChunkSize: expected.ChunkSize}
+	for _, chunk := range expected.Chunks {
+		weak.Chunks = append(weak.Chunks, rdiff.SignatureChunk{Adler32: chunk.Adler32})
+	}
+	_, _ = weak.WriteTo(entry)
+
+	if err = os.WriteFile(entries[0], entry.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	signature := sign(t, c, path, 4)
+	compareSignatures(t, signature, expected)
+	if *signed != 2 || signature.Weak() {
+		t.Errorf("unexpected number of signed files, got %d, expected %d", *signed, 2)
+	}
+
+	// The entry was replaced with one with strong hashes.
+	if signature = sign(t, c, path, 4); *signed != 2 || signature.Weak() {
+		t.Errorf("unexpected number of signed files, got %d, expected %d", *signed, 2)
+	}
+}
+
+func mustOpen(t *testing.T, path string) *os.File {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	return file
+}
+
+func mustStat(t *testing.T, path string) os.FileInfo {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info
+}
@@ -0,0 +1,340 @@
+// Package rcrypt encrypts serialized signatures and deltas with AES-256-GCM,
+// so that the data they carry stays confidential.
+//
+// Encrypted data starts with a header with a random salt, followed by the data
+// in segments of segmentSize bytes that are encrypted one by one, so neither
+// side ever holds more than a segment in memory. Every file is encrypted with
+// its own key that is derived from the salt and a Key, either a key file or a
+// passphrase. The nonce of a segment is its number and a flag for the last
+// segment, so segments cannot be reordered, dropped or cut off without
+// decryption failing.
+package rcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The header is magic, the version, the kdf the Key was turned into the file
+// key with, the scrypt work factor as log2 of N (0 for key files) and the
+// salt.
+const (
+	magic   = "RDEN"
+	version = 1
+
+	kdfKey        = 0
+	kdfPassphrase = 1
+
+	headerSize = len(magic) + 3 + saltSize
+	saltSize   = 16
+
+	// KeySize is the size of a key in a key file.
+	KeySize = 32
+
+	segmentSize = 64 * 1024
+
+	// scryptLogN is the work factor of passphrases, maxScryptLogN bounds the
+	// work factor of files that are decrypted. scrypt needs 128*8*2^logN bytes
+	// of memory, 32 MiB for scryptLogN and 64 MiB at most, so a crafted header
+	// cannot make decryption exhaust the memory.
+	scryptLogN    = 15
+	maxScryptLogN = 16
+)
+
+var (
+	// ErrInvalidKey is returned for keys of the wrong size and empty
+	// passphrases.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrDecryptionFailed is returned when encrypted data was encrypted with
+	// another key, was modified or is incomplete.
+	ErrDecryptionFailed = errors.New("decryption failed")
+)
+
+// Key is the secret that data is encrypted with.
+type Key struct {
+	kdf    byte
+	secret []byte
+}
+
+// NewKey returns a Key of KeySize random bytes, as they are stored in key
+// files.
+func NewKey(key []byte) (Key, error) {
+	if len(key) != KeySize {
+		return Key{}, fmt.Errorf("%w: key has %d bytes, expected %d", ErrInvalidKey, len(key), KeySize)
+	}
+
+	return Key{kdf: kdfKey, secret: append([]byte(nil), key...)}, nil
+}
+
+// ParseKey decodes the content of a key file, either KeySize bytes or their
+// hex encoding.
+func ParseKey(data []byte) (Key, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 2*KeySize {
+		if decoded, err := hex.DecodeString(string(trimmed)); err == nil {
+			return NewKey(decoded)
+		}
+	}
+
+	return NewKey(data)
+}
+
+// GenerateKey returns a new random key for a key file.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// NewPassphrase returns a Key that is derived from passphrase with scrypt.
+func NewPassphrase(passphrase string) (Key, error) {
+	if passphrase == "" {
+		return Key{}, fmt.Errorf("%w: empty passphrase", ErrInvalidKey)
+	}
+
+	return Key{kdf: kdfPassphrase, secret: []byte(passphrase)}, nil
+}
+
+// IsEncrypted reports whether data starts like encrypted data.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// aead returns the cipher of the file with the given header.
+func (k Key) aead(header []byte) (cipher.AEAD, error) {
+	salt := header[len(magic)+3:]
+
+	secret := k.secret
+	if k.kdf == kdfPassphrase {
+		var err error
+		if secret, err = scrypt.Key(k.secret, salt, 1<<header[len(magic)+2], 8, 1, KeySize); err != nil {
+			return nil, err
+		}
+	}
+
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte("rdetective encryption")), key); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the nonce of segment i.
+func nonce(aead cipher.AEAD, i uint64, last bool) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-9:], i)
+	if last {
+		n[len(n)-1] = 1
+	}
+
+	return n
+}
+
+// Writer encrypts the data written to it. It must be closed to write the last
+// segment.
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+
+	segment []byte
+	sealed  []byte
+	count   uint64
+	closed  bool
+	err     error
+}
+
+// NewWriter returns a Writer that writes the data written to it encrypted with
+// key to w.
+func NewWriter(w io.Writer, key Key) (*Writer, error) {
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[len(magic)] = version
+	header[len(magic)+1] = key.kdf
+	if key.kdf == kdfPassphrase {
+		header[len(magic)+2] = scryptLogN
+	}
+
+	if _, err := rand.Read(header[len(magic)+3:]); err != nil {
+		return nil, err
+	}
+
+	aead, err := key.aead(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		w:       w,
+		aead:    aead,
+		header:  header,
+		segment: make([]byte, 0, segmentSize),
+		sealed:  make([]byte, 0, segmentSize+aead.Overhead()),
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 && w.err == nil {
+		// A full segment is only written once more data follows, the last
+		// segment is written by Close.
+		if len(w.segment) == segmentSize {
+			w.seal(false)
+		}
+
+		n := copy(w.segment[len(w.segment):segmentSize], p)
+		w.segment = w.segment[:len(w.segment)+n]
+		written += n
+		p = p[n:]
+	}
+
+	return written, w.err
+}
+
+// Close writes the last segment. It does not close the underlying writer.
+// Nothing must be written after Close.
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+
+	w.closed = true
+	if w.err == nil {
+		w.seal(true)
+	}
+
+	return w.err
+}
+
+func (w *Writer) seal(last bool) {
+	w.sealed = w.aead.Seal(w.sealed[:0], nonce(w.aead, w.count, last), w.segment, w.header)
+	_, w.err = w.w.Write(w.sealed)
+	w.segment = w.segment[:0]
+	w.count++
+}
+
+// Reader decrypts data written by a Writer.
+type Reader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+
+	sealed []byte
+	opened []byte
+	count  uint64
+	last   bool
+	err    error
+}
+
+// NewReader returns a Reader that decrypts the data of r with key. It fails
+// with ErrDecryptionFailed if r is not encrypted or was encrypted with another
+// kind of key. Data that was encrypted with another key of the same kind, or
+// modified, fails when it is read.
+func NewReader(r io.Reader, key Key) (*Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: data is not encrypted", ErrDecryptionFailed)
+		}
+
+		return nil, err
+	}
+
+	switch {
+	case !IsEncrypted(header):
+		return nil, fmt.Errorf("%w: data is not encrypted", ErrDecryptionFailed)
+	case header[len(magic)] != version:
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrDecryptionFailed, header[len(magic)])
+	case header[len(magic)+1] != key.kdf && key.kdf == kdfKey:
+		return nil, fmt.Errorf("%w: data was encrypted with a passphrase, not a key file", ErrDecryptionFailed)
+	case header[len(magic)+1] != key.kdf:
+		return nil, fmt.Errorf("%w: data was encrypted with a key file, not a passphrase", ErrDecryptionFailed)
+	case key.kdf == kdfPassphrase && (header[len(magic)+2] == 0 || header[len(magic)+2] > maxScryptLogN):
+		return nil, fmt.Errorf("%w: invalid work factor %d", ErrDecryptionFailed, header[len(magic)+2])
+	}
+
+	aead, err := key.aead(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		r:      bufio.NewReader(r),
+		aead:   aead,
+		header: header,
+		sealed: make([]byte, segmentSize+aead.Overhead()),
+	}, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.opened) == 0 && r.err == nil {
+		if r.last {
+			r.err = io.EOF
+			break
+		}
+
+		r.open()
+	}
+
+	if len(r.opened) > 0 {
+		n := copy(p, r.opened)
+		r.opened = r.opened[n:]
+
+		return n, nil
+	}
+
+	return 0, r.err
+}
+
+// open decrypts the next segment.
+func (r *Reader) open() {
+	n, err := io.ReadFull(r.r, r.sealed)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		r.err = err
+		return
+	}
+
+	// Only the last segment is short, a full one is last if nothing follows.
+	r.last = n < len(r.sealed)
+	if !r.last {
+		if _, err = r.r.Peek(1); err == io.EOF {
+			r.last = true
+		} else if err != nil {
+			r.err = err
+			return
+		}
+	}
+
+	opened, err := r.aead.Open(r.sealed[:0], nonce(r.aead, r.count, r.last), r.sealed[:n], r.header)
+	if err != nil {
+		r.err = fmt.Errorf("%w: segment %d was encrypted with another key, modified or cut off", ErrDecryptionFailed, r.count)
+		return
+	}
+
+	r.opened = opened
+	r.count++
+}
@@ -0,0 +1,178 @@
+package rcrypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func newKey(t *testing.T) Key {
+	data, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := NewKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func encrypt(t *testing.T, key Key, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write in uneven pieces to cross segment boundaries.
+	for len(data) > 0 {
+		n := 1 + len(data)/3
+		if _, err = w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func decrypt(key Key, data []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(iotest.HalfReader(r))
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := newKey(t)
+	passphrase, err := NewPassphrase("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for _, size := range []int{0, 1, 1000, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 17} {
+		data := make([]byte, size)
+		r.Read(data)
+
+		for name, key := range map[string]Key{"key": key, "passphrase": passphrase} {
+			encrypted := encrypt(t, key, data)
+			if !IsEncrypted(encrypted) {
+				t.Errorf("%s %d: encrypted data is not recognized", name, size)
+			}
+
+			if size > 16 && bytes.Contains(encrypted, data[:16]) {
+				t.Errorf("%s %d: encrypted data contains plain text", name, size)
+			}
+
+			decrypted, err := decrypt(key, encrypted)
+			if err != nil {
+				t.Fatalf("%s %d: unexpected error decrypting: %v", name, size, err)
+			}
+
+			if !bytes.Equal(decrypted, data) {
+				t.Errorf("%s %d: unexpected decrypted data", name, size)
+			}
+		}
+	}
+
+	// The same data encrypts differently every time.
+	if bytes.Equal(encrypt(t, key, []byte("hello")), encrypt(t, key, []byte("hello"))) {
+		t.Errorf("encryption is deterministic")
+	}
+}
+
+func TestDecryptRefuses(t *testing.T) {
+	key := newKey(t)
+	passphrase, _ := NewPassphrase("secret")
+	otherPassphrase, _ := NewPassphrase("other secret")
+
+	data := make([]byte, 2*segmentSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	encrypted := encrypt(t, key, data)
+	segment := segmentSize + 16
+
+	tests := map[string]struct {
+		key  Key
+		data []byte
+	}{
+		"other key":               {newKey(t), encrypted},
+		"passphrase for key file": {passphrase, encrypted},
+		"key file for passphrase": {key, encrypt(t, passphrase, data)},
+		"other passphrase":        {otherPassphrase, encrypt(t, passphrase, data)},
+		"not encrypted":           {key, data},
+		"empty":                   {key, nil},
+		"header only":             {key, encrypted[:headerSize]},
+		"truncated segment":       {key, encrypted[:len(encrypted)-1]},
+		"last segment dropped":    {key, encrypted[:headerSize+2*segment]},
+		"segment dropped":         {key, append(append([]byte(nil), encrypted[:headerSize+segment]...), encrypted[headerSize+2*segment:]...)},
+		"segments swapped": {key, append(append(append([]byte(nil), encrypted[:headerSize]...),
+			encrypted[headerSize+segment:headerSize+2*segment]...), encrypted[headerSize:headerSize+segment]...)},
+		"appended": {key, append(append([]byte(nil), encrypted...), 0)},
+	}
+
+	for i := range encrypted {
+		if i%997 != 0 && i >= headerSize {
+			continue
+		}
+
+		tampered := append([]byte(nil), encrypted...)
+		tampered[i] ^= 0x01
+		tests[fmt.Sprintf("tampered byte %d", i)] = struct {
+			key  Key
+			data []byte
+		}{key, tampered}
+	}
+
+	for name, test := range tests {
+		if _, err := decrypt(test.key, test.data); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("%s: unexpected error, got %v, expected %v", name, err, ErrDecryptionFailed)
+		}
+	}
+
+	// Work factors that need too much memory are refused before deriving a key.
+	expensive := encrypt(t, passphrase, data)
+	expensive[len(magic)+2] = maxScryptLogN + 1
+	if _, err := NewReader(bytes.NewReader(expensive), passphrase); !errors.Is(err, ErrDecryptionFailed) ||
+		!strings.Contains(err.Error(), "work factor") {
+		t.Errorf("unexpected error for work factor %d, got %v", maxScryptLogN+1, err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	data, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hexKey := []byte("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n")
+	for _, encoded := range [][]byte{data, hexKey} {
+		if _, err = ParseKey(encoded); err != nil {
+			t.Errorf("unexpected error parsing key: %v", err)
+		}
+	}
+
+	for _, encoded := range [][]byte{nil, data[:16], append(data, 0), []byte("not hex")} {
+		if _, err = ParseKey(encoded); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidKey)
+		}
+	}
+
+	if _, err = NewPassphrase(""); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidKey)
+	}
+}
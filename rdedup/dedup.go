@@ -0,0 +1,221 @@
+// Package rdedup stores files as chunks addressed by the sha256 of their
+// content, so that chunks shared by several files, or repeated within one,
+// are only stored once.
+//
+// A store is an rfile store with its settings in dedup.json, one manifest per
+// file below files listing its chunks, the chunks below chunks and the
+// reference count of every chunk in index.json.
+// Chunks are written before the index and manifests that refer to them, and
+// references are dropped after the manifests, so an interrupted operation can
+// only leave chunks behind that GC removes, never lose data.
+package rdedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rfile"
+)
+
+const (
+	settingsName = "dedup.json"
+	indexName    = "index.json"
+	filesDir     = "files"
+	chunksDir    = "chunks"
+
+	manifestExtension = ".json"
+)
+
+var (
+	// ErrInvalidArgument is returned for invalid settings and file names.
+	ErrInvalidArgument = rfile.ErrInvalidArgument
+	// ErrNotFound is returned for stores and files that do not exist.
+	ErrNotFound = rfile.ErrNotFound
+	// ErrExists is returned when initializing a store in a directory that
+	// already has one.
+	ErrExists = rfile.ErrExists
+	// ErrVerificationFailed is returned when a restored file or one of its
+	// chunks does not match its digest. It wraps rdiff.ErrVerificationFailed.
+	ErrVerificationFailed = fmt.Errorf("%w", rdiff.ErrVerificationFailed)
+)
+
+// Settings are chosen when a store is initialized.
+type Settings struct {
+	// ChunkSize is the size files are split at, like for a Signature.
+	ChunkSize int `json:"chunk_size"`
+}
+
+func (s Settings) Validate() error {
+	if s.ChunkSize <= 0 || s.ChunkSize > rdiff.MaxChunkSize {
+		return fmt.Errorf("%w: %d", rdiff.ErrInvalidChunkSize, s.ChunkSize)
+	}
+
+	return nil
+}
+
+// Manifest lists the chunks a file consists of, in order.
+type Manifest struct {
+	Name   string   `json:"name"`
+	Size   int64    `json:"size"`
+	SHA256 string   `json:"sha256"`
+	Chunks []string `json:"chunks"`
+}
+
+// chunk is the index entry of a stored chunk.
+type chunk struct {
+	Size int64 `json:"size"`
+	Refs int   `json:"refs"`
+}
+
+type Config struct {
+	Logger logrus.FieldLogger
+
+	// Dir is the directory of the store.
+	Dir string
+}
+
+// Store is an opened store.
+type Store struct {
+	config   *Config
+	settings Settings
+}
+
+// Init creates an empty store in config.Dir, creating the directory if needed.
+func Init(config *Config, settings Settings) (*Store, error) {
+	err := rfile.InitStore(config.Dir, settingsName, settings, func() error {
+		for _, dir := range []string{filesDir, chunksDir} {
+			if err := os.MkdirAll(filepath.Join(config.Dir, dir), 0o755); err != nil {
+				return err
+			}
+		}
+
+		return rfile.WriteJSON(filepath.Join(config.Dir, indexName), map[string]chunk{})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		config:   config,
+		settings: settings,
+	}, nil
+}
+
+// Open opens the store in config.Dir.
+func Open(config *Config) (*Store, error) {
+	var settings Settings
+	if err := rfile.OpenStore(config.Dir, settingsName, &settings); err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		config:   config,
+		settings: settings,
+	}, nil
+}
+
+func (s *Store) Settings() Settings {
+	return s.settings
+}
+
+// Files returns the names of all files in the store, sorted.
+func (s *Store) Files() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.config.Dir, filesDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), manifestExtension) {
+			continue
+		}
+
+		name, err := url.PathUnescape(strings.TrimSuffix(entry.Name(), manifestExtension))
+		if err != nil {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Manifest returns the manifest of the named file.
+func (s *Store) Manifest(name string) (Manifest, error) {
+	manifestPath, err := s.manifestPath(name)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	var manifest Manifest
+	if err = rfile.ReadJSON(manifestPath, &manifest); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Manifest{}, fmt.Errorf("%w: %s is not in the store", ErrNotFound, name)
+		}
+
+		return Manifest{}, err
+	}
+
+	return manifest, nil
+}
+
+// manifestPath returns the path of the manifest of the named file. Names are
+// slash separated paths, they are stored escaped.
+func (s *Store) manifestPath(name string) (string, error) {
+	cleaned := path.Clean(name)
+	if name == "" || cleaned == "." || cleaned == ".." {
+		return "", fmt.Errorf("%w: file name %q", ErrInvalidArgument, name)
+	}
+
+	return filepath.Join(s.config.Dir, filesDir, url.PathEscape(cleaned)+manifestExtension), nil
+}
+
+// chunkPath returns the path of the chunk with the given hex encoded digest.
+// Chunks are spread over directories named after the first byte of their
+// digest.
+func (s *Store) chunkPath(digest string) string {
+	return filepath.Join(s.config.Dir, chunksDir, digest[:2], digest)
+}
+
+func (s *Store) readIndex() (map[string]chunk, error) {
+	index := map[string]chunk{}
+	if err := rfile.ReadJSON(filepath.Join(s.config.Dir, indexName), &index); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+func (s *Store) writeIndex(index map[string]chunk) error {
+	return rfile.WriteJSON(filepath.Join(s.config.Dir, indexName), index)
+}
+
+// digest returns the hex encoded sha256 of data.
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// validDigest reports whether name is a hex encoded sha256, as used for chunk
+// files.
+func validDigest(name string) bool {
+	decoded, err := hex.DecodeString(name)
+
+	return err == nil && len(decoded) == sha256.Size && strings.ToLower(name) == name
+}
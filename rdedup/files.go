@@ -0,0 +1,201 @@
+package rdedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rfile"
+)
+
+// AddStats describes how much of an added file was new to the store.
+type AddStats struct {
+	Chunks    int
+	NewChunks int
+	NewBytes  int64
+}
+
+// Add splits the content of r into chunks and stores the named file as a
+// manifest of them, replacing a file with the same name. Only chunks that are
+// not in the store yet are written.
+func (s *Store) Add(ctx context.Context, name string, r io.Reader) (Manifest, AddStats, error) {
+	manifestPath, err := s.manifestPath(name)
+	if err != nil {
+		return Manifest{}, AddStats{}, err
+	}
+
+	previous, err := s.Manifest(name)
+	replaced := err == nil
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Manifest{}, AddStats{}, err
+	}
+
+	index, err := s.readIndex()
+	if err != nil {
+		return Manifest{}, AddStats{}, err
+	}
+
+	var stats AddStats
+	manifest := Manifest{
+		Name:   path.Clean(name),
+		Chunks: []string{},
+	}
+
+	hash := sha256.New()
+	signer := rdiff.NewSigner(rdiff.Options{ChunkSize: s.settings.ChunkSize})
+	err = signer.EachChunk(ctx, r, func(data []byte) error {
+		hash.Write(data)
+
+		d := digest(data)
+		entry, ok := index[d]
+		if !ok {
+			if err := s.writeChunk(d, data); err != nil {
+				return err
+			}
+
+			stats.NewChunks++
+			stats.NewBytes += int64(len(data))
+		}
+
+		entry.Size = int64(len(data))
+		entry.Refs++
+		index[d] = entry
+
+		manifest.Size += int64(len(data))
+		manifest.Chunks = append(manifest.Chunks, d)
+
+		return nil
+	})
+	if err != nil {
+		return Manifest{}, AddStats{}, err
+	}
+
+	manifest.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	stats.Chunks = len(manifest.Chunks)
+
+	if err = s.writeIndex(index); err != nil {
+		return Manifest{}, AddStats{}, err
+	}
+
+	if err = rfile.WriteJSON(manifestPath, manifest); err != nil {
+		return Manifest{}, AddStats{}, err
+	}
+
+	if replaced {
+		release(index, previous)
+		if err = s.writeIndex(index); err != nil {
+			return Manifest{}, AddStats{}, err
+		}
+	}
+
+	s.config.Logger.WithField("file", manifest.Name).Debugf("added %d chunks, %d new", stats.Chunks, stats.NewChunks)
+
+	return manifest, stats, nil
+}
+
+// writeChunk stores data under its digest d, unless a chunk file with that
+// name was left behind already.
+func (s *Store) writeChunk(d string, data []byte) error {
+	chunkPath := s.chunkPath(d)
+	if _, err := os.Stat(chunkPath); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(chunkPath), 0o755); err != nil {
+		return err
+	}
+
+	return rfile.WriteFile(chunkPath, 0o644, func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+}
+
+// release drops the references of the chunks of manifest. Chunks without
+// references are kept until GC.
+func release(index map[string]chunk, manifest Manifest) {
+	for _, d := range manifest.Chunks {
+		if entry, ok := index[d]; ok && entry.Refs > 0 {
+			entry.Refs--
+			index[d] = entry
+		}
+	}
+}
+
+// Restore writes the named file to w. Every chunk is verified before it is
+// written and the whole file once it has been written completely, w must
+// discard the data if ErrVerificationFailed is returned.
+func (s *Store) Restore(ctx context.Context, name string, w io.Writer) error {
+	manifest, err := s.Manifest(name)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	for _, d := range manifest.Chunks {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		if !validDigest(d) {
+			return fmt.Errorf("%w: invalid chunk %q in manifest of %s", rdiff.ErrCorruptInput, d, name)
+		}
+
+		data, err := os.ReadFile(s.chunkPath(d))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("%w: chunk %s of %s is missing", rdiff.ErrCorruptInput, d, name)
+			}
+
+			return err
+		}
+
+		if digest(data) != d {
+			return fmt.Errorf("%w: chunk %s of %s", ErrVerificationFailed, d, name)
+		}
+
+		hash.Write(data)
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != manifest.SHA256 {
+		return fmt.Errorf("%w: %s", ErrVerificationFailed, name)
+	}
+
+	return nil
+}
+
+// Remove removes the named file from the store. Its chunks stay until GC.
+func (s *Store) Remove(name string) error {
+	manifest, err := s.Manifest(name)
+	if err != nil {
+		return err
+	}
+
+	index, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+
+	manifestPath, err := s.manifestPath(name)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(manifestPath); err != nil {
+		return err
+	}
+
+	release(index, manifest)
+
+	return s.writeIndex(index)
+}
@@ -0,0 +1,183 @@
+package rdedup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+// GCStats describes what a garbage collection removed.
+type GCStats struct {
+	Chunks int
+	Bytes  int64
+}
+
+// GC recounts the references of all chunks from the manifests and removes all
+// chunks that are not referenced anymore, including chunks left behind by
+// interrupted operations.
+func (s *Store) GC(ctx context.Context) (GCStats, error) {
+	var stats GCStats
+
+	previous, err := s.readIndex()
+	if err != nil {
+		return stats, err
+	}
+
+	names, err := s.Files()
+	if err != nil {
+		return stats, err
+	}
+
+	index := map[string]chunk{}
+	for _, name := range names {
+		if err = ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		manifest, err := s.Manifest(name)
+		if err != nil {
+			return stats, err
+		}
+
+		for _, d := range manifest.Chunks {
+			if !validDigest(d) {
+				return stats, fmt.Errorf("%w: invalid chunk %q in manifest of %s", rdiff.ErrCorruptInput, d, name)
+			}
+
+			entry, ok := index[d]
+			if !ok {
+				entry.Size, err = s.chunkSize(previous, d)
+				if err != nil {
+					return stats, err
+				}
+			}
+
+			entry.Refs++
+			index[d] = entry
+		}
+	}
+
+	// The index must never list a chunk that is gone, so it is written before
+	// anything is removed.
+	if err = s.writeIndex(index); err != nil {
+		return stats, err
+	}
+
+	root := filepath.Join(s.config.Dir, chunksDir)
+	err = filepath.WalkDir(root, func(name string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		if _, ok := index[entry.Name()]; ok {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if err = os.Remove(name); err != nil {
+			return err
+		}
+
+		if validDigest(entry.Name()) {
+			stats.Chunks++
+		}
+		stats.Bytes += info.Size()
+
+		return nil
+	})
+
+	s.config.Logger.Debugf("removed %d chunks with %d bytes", stats.Chunks, stats.Bytes)
+
+	return stats, err
+}
+
+// chunkSize returns the size of the chunk d from index or, for chunks that
+// are missing from it, from its file.
+func (s *Store) chunkSize(index map[string]chunk, d string) (int64, error) {
+	if entry, ok := index[d]; ok {
+		return entry.Size, nil
+	}
+
+	info, err := os.Stat(s.chunkPath(d))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, fmt.Errorf("%w: chunk %s is missing", rdiff.ErrCorruptInput, d)
+		}
+
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+// Report describes how well the files in a store deduplicate.
+type Report struct {
+	Files int
+	// Size is the total size of all files.
+	Size int64
+	// Chunks and Stored are the number and size of the chunks that are
+	// referenced by files.
+	Chunks int
+	Stored int64
+	// Unreferenced chunks are removed by the next GC.
+	Unreferenced      int
+	UnreferencedBytes int64
+}
+
+// Ratio returns how many times larger the files are than the chunks stored
+// for them.
+func (r Report) Ratio() float64 {
+	if r.Stored == 0 {
+		return 1
+	}
+
+	return float64(r.Size) / float64(r.Stored)
+}
+
+// Report returns the deduplication report of the store.
+func (s *Store) Report() (Report, error) {
+	var report Report
+
+	names, err := s.Files()
+	if err != nil {
+		return report, err
+	}
+
+	for _, name := range names {
+		manifest, err := s.Manifest(name)
+		if err != nil {
+			return report, err
+		}
+
+		report.Files++
+		report.Size += manifest.Size
+	}
+
+	index, err := s.readIndex()
+	if err != nil {
+		return report, err
+	}
+
+	for _, entry := range index {
+		if entry.Refs > 0 {
+			report.Chunks++
+			report.Stored += entry.Size
+		} else {
+			report.Unreferenced++
+			report.UnreferencedBytes += entry.Size
+		}
+	}
+
+	return report, nil
+}
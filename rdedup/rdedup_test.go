@@ -0,0 +1,303 @@
+package rdedup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+func getStore(t *testing.T, chunkSize int) *Store {
+	s, err := Init(&Config{
+		Logger: &logrus.Logger{
+			Out:       io.Discard,
+			Formatter: &logrus.TextFormatter{},
+			Level:     logrus.DebugLevel,
+		},
+		Dir: filepath.Join(t.TempDir(), "dedup"),
+	}, Settings{ChunkSize: chunkSize})
+	if err != nil {
+		t.Fatalf("error initializing store: %s", err.Error())
+	}
+
+	return s
+}
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+
+	return data
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func add(t *testing.T, s *Store, name string, data []byte) AddStats {
+	_, stats, err := s.Add(context.Background(), name, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error adding %s: %s", name, err.Error())
+	}
+
+	return stats
+}
+
+func restore(t *testing.T, s *Store, name string) []byte {
+	var buf bytes.Buffer
+	if err := s.Restore(context.Background(), name, &buf); err != nil {
+		t.Fatalf("error restoring %s: %s", name, err.Error())
+	}
+
+	return buf.Bytes()
+}
+
+// chunkFiles returns the number of chunk files in the store.
+func chunkFiles(t *testing.T, s *Store) int {
+	n := 0
+	err := filepath.WalkDir(filepath.Join(s.config.Dir, chunksDir), func(_ string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			n++
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestAddRestore(t *testing.T) {
+	s := getStore(t, 64)
+	a := randomData(64*4, 1)
+	b := randomData(64*2, 2)
+
+	files := map[string][]byte{
+		"a":       a,
+		"dir/ab":  join(a, b, []byte("tail")),
+		"repeats": join(b, b, b),
+		"empty":   {},
+	}
+
+	expectedNew := map[string]int{"a": 4, "dir/ab": 3, "repeats": 0, "empty": 0}
+	for _, name := range []string{"a", "dir/ab", "repeats", "empty"} {
+		stats := add(t, s, name, files[name])
+		if stats.NewChunks != expectedNew[name] {
+			t.Errorf("%s: unexpected new chunks, got %d, expected %d", name, stats.NewChunks, expectedNew[name])
+		}
+	}
+
+	for name, data := range files {
+		if restored := restore(t, s, name); !bytes.Equal(restored, data) {
+			t.Errorf("%s: unexpected restored content", name)
+		}
+	}
+
+	if n := chunkFiles(t, s); n != 7 {
+		t.Errorf("unexpected number of chunks, got %d, expected %d", n, 7)
+	}
+
+	report, err := s.Report()
+	if err != nil {
+		t.Fatalf("error generating report: %s", err.Error())
+	}
+
+	expected := Report{Files: 4, Size: 256 + 388 + 384, Chunks: 7, Stored: 64*6 + 4}
+	if report != expected {
+		t.Errorf("unexpected report, got %+v, expected %+v", report, expected)
+	}
+
+	if ratio := report.Ratio(); ratio < 2.6 || ratio > 2.7 {
+		t.Errorf("unexpected ratio %f", ratio)
+	}
+}
+
+func TestRemoveGC(t *testing.T) {
+	s := getStore(t, 64)
+	a := randomData(64*4, 1)
+	b := randomData(64*2, 2)
+
+	add(t, s, "a", a)
+	add(t, s, "ab", join(a, b))
+
+	if err := s.Remove("ab"); err != nil {
+		t.Fatalf("error removing: %s", err.Error())
+	}
+
+	report, err := s.Report()
+	if err != nil {
+		t.Fatalf("error generating report: %s", err.Error())
+	}
+
+	if report.Chunks != 4 || report.Unreferenced != 2 {
+		t.Errorf("unexpected chunks, got %d referenced and %d unreferenced, expected 4 and 2", report.Chunks, report.Unreferenced)
+	}
+
+	stats, err := s.GC(context.Background())
+	if err != nil {
+		t.Fatalf("error collecting garbage: %s", err.Error())
+	}
+
+	if stats.Chunks != 2 || stats.Bytes != 128 {
+		t.Errorf("unexpected collected chunks, got %d with %d bytes, expected 2 with 128 bytes", stats.Chunks, stats.Bytes)
+	}
+
+	if n := chunkFiles(t, s); n != 4 {
+		t.Errorf("unexpected number of chunks, got %d, expected %d", n, 4)
+	}
+
+	if restored := restore(t, s, "a"); !bytes.Equal(restored, a) {
+		t.Errorf("unexpected restored content")
+	}
+
+	if err = s.Restore(context.Background(), "ab", io.Discard); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	s := getStore(t, 64)
+	a := randomData(64*4, 1)
+	b := randomData(64*2, 2)
+
+	add(t, s, "file", a)
+	stats := add(t, s, "file", join(a[:128], b))
+	if stats.NewChunks != 2 {
+		t.Errorf("unexpected new chunks, got %d, expected %d", stats.NewChunks, 2)
+	}
+
+	gc, err := s.GC(context.Background())
+	if err != nil {
+		t.Fatalf("error collecting garbage: %s", err.Error())
+	}
+
+	if gc.Chunks != 2 {
+		t.Errorf("unexpected collected chunks, got %d, expected %d", gc.Chunks, 2)
+	}
+
+	if restored := restore(t, s, "file"); !bytes.Equal(restored, join(a[:128], b)) {
+		t.Errorf("unexpected restored content")
+	}
+}
+
+func TestGCRecountsReferences(t *testing.T) {
+	s := getStore(t, 64)
+	a := randomData(64*2, 1)
+	add(t, s, "a", a)
+
+	// An interrupted add leaves a chunk behind, and an interrupted remove
+	// references that are too high.
+	if err := s.writeChunk(digest([]byte("orphan")), []byte("orphan")); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := s.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for d, entry := range index {
+		entry.Refs += 5
+		index[d] = entry
+	}
+
+	if err = s.writeIndex(index); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := s.GC(context.Background())
+	if err != nil {
+		t.Fatalf("error collecting garbage: %s", err.Error())
+	}
+
+	if stats.Chunks != 1 {
+		t.Errorf("unexpected collected chunks, got %d, expected %d", stats.Chunks, 1)
+	}
+
+	if index, err = s.readIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	for d, entry := range index {
+		if entry.Refs != 1 {
+			t.Errorf("unexpected references of %s, got %d, expected %d", d, entry.Refs, 1)
+		}
+	}
+
+	if err = s.Remove("a"); err != nil {
+		t.Fatalf("error removing: %s", err.Error())
+	}
+
+	if stats, err = s.GC(context.Background()); err != nil {
+		t.Fatalf("error collecting garbage: %s", err.Error())
+	}
+
+	if stats.Chunks != 2 || chunkFiles(t, s) != 0 {
+		t.Errorf("unexpected collected chunks, got %d, expected %d", stats.Chunks, 2)
+	}
+}
+
+func TestRestoreCorrupt(t *testing.T) {
+	s := getStore(t, 64)
+	a := randomData(64*2, 1)
+	manifest, _, err := s.Add(context.Background(), "a", bytes.NewReader(a))
+	if err != nil {
+		t.Fatalf("error adding: %s", err.Error())
+	}
+
+	chunkPath := s.chunkPath(manifest.Chunks[1])
+	if err = os.WriteFile(chunkPath, a[:64], 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Restore(context.Background(), "a", io.Discard); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrVerificationFailed)
+	}
+
+	if err = os.Remove(chunkPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Restore(context.Background(), "a", io.Discard); !errors.Is(err, rdiff.ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, rdiff.ErrCorruptInput)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	s := getStore(t, 64)
+
+	opened, err := Open(s.config)
+	if err != nil {
+		t.Fatalf("error opening store: %s", err.Error())
+	}
+
+	if opened.Settings() != s.Settings() {
+		t.Errorf("unexpected settings, got %v, expected %v", opened.Settings(), s.Settings())
+	}
+
+	if _, err = Init(s.config, s.Settings()); !errors.Is(err, ErrExists) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrExists)
+	}
+
+	if _, err = Open(&Config{Dir: t.TempDir()}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrNotFound)
+	}
+
+	if _, err = Init(&Config{Dir: t.TempDir()}, Settings{}); !errors.Is(err, rdiff.ErrInvalidChunkSize) {
+		t.Errorf("unexpected error, got %v, expected %v", err, rdiff.ErrInvalidChunkSize)
+	}
+
+	if _, _, err = s.Add(context.Background(), "..", bytes.NewReader(nil)); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidArgument)
+	}
+}
@@ -0,0 +1,306 @@
+package rdiff
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Compose returns a single delta from the original file of d1 to the updated
+// file of d2, where d2 was generated from the updated file of d1. Neither file
+// is needed, the composed delta copies the ranges of the original that d2
+// copies through d1. Composing a chain of deltas one by one collapses it into
+// a single delta.
+// Ranges that do not line up with the chunks of the original are copied as
+// parts of chunks (see DeltaChunk).
+func Compose(d1, d2 Delta) (Delta, error) {
+	first, err := d1.segments()
+	if err != nil {
+		return Delta{}, err
+	}
+
+	second, err := d2.segments()
+	if err != nil {
+		return Delta{}, err
+	}
+
+	intermediate := newLayout(first, d1.ChunkSize)
+	if !intermediate.fits(d2) || (d1.Updated != nil && d2.Original != nil && *d1.Updated != *d2.Original) {
+		return Delta{}, fmt.Errorf("%w: second delta was not generated from the result of the first", ErrCorruptInput)
+	}
+
+	var composed []segment
+	for _, s := range second {
+		if s.literal != nil {
+			composed = appendSegment(composed, s)
+			continue
+		}
+
+		parts, err := intermediate.slice(s.offset, s.length, s.open)
+		if err != nil {
+			return Delta{}, err
+		}
+
+		for _, part := range parts {
+			composed = appendSegment(composed, part)
+		}
+	}
+
+	delta, err := composeDelta(composed, d1.ChunkSize, d1.ChunkCount)
+	if err != nil {
+		return delta, err
+	}
+
+	delta.Original = copyDigest(d1.Original)
+	delta.Updated = copyDigest(d2.Updated)
+
+	return delta, nil
+}
+
+// segment is a range of a file described by a delta, either literal bytes or
+// length bytes copied from offset in the original. An open segment copies up
+// to the end of the original, which is at most length bytes away; deltas do
+// not record the size of the original.
+type segment struct {
+	literal []byte
+	offset  int64
+	length  int64
+	open    bool
+}
+
+// segments returns the ranges the updated file of the delta consists of, in
+// order.
+func (d Delta) segments() ([]segment, error) {
+	if d.ChunkSize <= 0 || d.ChunkSize > MaxChunkSize {
+		return nil, fmt.Errorf("%w: delta has chunk size %d", ErrInvalidChunkSize, d.ChunkSize)
+	}
+
+	var segments []segment
+	for i, change := range d.Changes {
+		if len(change.NewBytes) > 0 {
+			segments = appendSegment(segments, segment{literal: change.NewBytes})
+		}
+
+		if change.ChunkIndex == d.ChunkCount {
+			continue // Only new data.
+		}
+
+		if change.ChunkIndex < 0 || change.ChunkIndex > d.ChunkCount {
+			return nil, fmt.Errorf("%w: chunk %d out of range", ErrCorruptInput, change.ChunkIndex)
+		}
+
+		length, err := partLength(d, change)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only the last chunk of the original can be short, and a Differ only
+		// matches a short chunk at the very end of the updated file.
+		segments = appendSegment(segments, segment{
+			offset: int64(change.ChunkIndex)*int64(d.ChunkSize) + int64(change.Offset),
+			length: int64(length),
+			open:   change.Length == 0 && change.ChunkIndex == d.ChunkCount-1 && i == len(d.Changes)-1,
+		})
+	}
+
+	return segments, nil
+}
+
+// appendSegment appends s to segments, merging it into the last segment if
+// both are literal or copy adjacent ranges.
+func appendSegment(segments []segment, s segment) []segment {
+	if len(segments) == 0 {
+		return append(segments, s)
+	}
+
+	last := &segments[len(segments)-1]
+	switch {
+	case s.literal != nil && last.literal != nil:
+		// Never append to the backing array of a delta's NewBytes.
+		last.literal = append(last.literal[:len(last.literal):len(last.literal)], s.literal...)
+	case s.literal == nil && last.literal == nil && !last.open && last.offset+last.length == s.offset:
+		last.length += s.length
+		last.open = s.open
+	default:
+		segments = append(segments, s)
+	}
+
+	return segments
+}
+
+// layout looks up ranges of a file described by its segments.
+type layout struct {
+	segments []segment
+	starts   []int64
+	size     int64 // Without the open segment, if there is one.
+	open     bool
+
+	// chunkSize of the original, which bounds how short the open segment can be.
+	chunkSize int64
+}
+
+func newLayout(segments []segment, chunkSize int) *layout {
+	l := &layout{
+		segments:  segments,
+		starts:    make([]int64, len(segments)),
+		chunkSize: int64(chunkSize),
+	}
+
+	for i, s := range segments {
+		l.starts[i] = l.size
+		if s.open {
+			l.open = true
+			break
+		}
+
+		l.size += l.segmentLength(s)
+	}
+
+	return l
+}
+
+func (l *layout) segmentLength(s segment) int64 {
+	if s.literal != nil {
+		return int64(len(s.literal))
+	}
+
+	return s.length
+}
+
+// fits reports whether delta can have been generated from the file, judging
+// by its chunk count.
+func (l *layout) fits(delta Delta) bool {
+	chunks := func(size int64) int64 {
+		return (size + int64(delta.ChunkSize) - 1) / int64(delta.ChunkSize)
+	}
+
+	count := int64(delta.ChunkCount)
+	if !l.open {
+		return count == chunks(l.size)
+	}
+
+	// Only the last chunk of the original within the open segment can be short.
+	last := l.segments[len(l.segments)-1]
+	shortest := last.length - l.chunkSize + 1
+	if shortest < 1 {
+		shortest = 1
+	}
+
+	return count >= chunks(l.size+shortest) && count <= chunks(l.size+last.length)
+}
+
+// slice returns the segments of the length bytes at from, or of everything
+// from there on if open is set.
+func (l *layout) slice(from, length int64, open bool) ([]segment, error) {
+	if from < 0 || (!l.open && (from >= l.size || (!open && from+length > l.size))) {
+		return nil, fmt.Errorf("%w: range at %d is beyond the end of the file", ErrCorruptInput, from)
+	}
+
+	// Find the segment from is in.
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.starts[i] > from
+	}) - 1
+
+	var parts []segment
+	for ; i < len(l.segments) && (open || length > 0); i++ {
+		s := l.segments[i]
+		skip := from - l.starts[i]
+
+		n := l.segmentLength(s) - skip
+		if !open && (s.open || n > length) {
+			n = length
+		}
+
+		part := segment{
+			offset: s.offset + skip,
+			length: n,
+			open:   open && s.open,
+		}
+		if s.literal != nil {
+			part = segment{literal: s.literal[skip : skip+n]}
+		}
+
+		parts = append(parts, part)
+		from += n
+		length -= n
+	}
+
+	if !open && length > 0 {
+		return nil, fmt.Errorf("%w: range at %d is beyond the end of the file", ErrCorruptInput, from)
+	}
+
+	return parts, nil
+}
+
+// composeDelta turns segments of an original file with the given chunk layout
+// back into a delta.
+func composeDelta(segments []segment, chunkSize, chunkCount int) (Delta, error) {
+	delta := Delta{
+		ChunkSize:  chunkSize,
+		ChunkCount: chunkCount,
+		Changes:    []DeltaChunk{},
+	}
+
+	used := make([]bool, chunkCount)
+	var newBytes []byte
+	position := 0
+	for _, s := range segments {
+		if s.literal != nil {
+			newBytes = append(newBytes, s.literal...)
+			continue
+		}
+
+		// Split the range at the chunk boundaries of the original.
+		offset, remaining := s.offset, s.length
+		for s.open || remaining > 0 {
+			index := int(offset / int64(chunkSize))
+			if index >= chunkCount {
+				return delta, fmt.Errorf("%w: chunk %d out of range", ErrCorruptInput, index)
+			}
+
+			part := int(offset % int64(chunkSize))
+			length := chunkSize - part
+			if !s.open && int64(length) > remaining {
+				length = int(remaining)
+			}
+
+			change := DeltaChunk{
+				ChunkIndex: index,
+				NewBytes:   newBytes,
+				Position:   position,
+				Offset:     part,
+				Length:     length,
+			}
+			if part+length == chunkSize {
+				change.Length = 0 // Up to the end of the chunk.
+			}
+
+			delta.Changes = append(delta.Changes, change)
+			used[index] = true
+
+			position += len(newBytes) + length
+			newBytes = nil
+			offset += int64(length)
+			remaining -= int64(length)
+
+			if s.open && index == chunkCount-1 {
+				break
+			}
+		}
+	}
+
+	if len(newBytes) > 0 { // Add data that is at the end of the file.
+		delta.Changes = append(delta.Changes, DeltaChunk{
+			ChunkIndex: chunkCount,
+			NewBytes:   newBytes,
+			Position:   position,
+		})
+	}
+
+	for index, ok := range used {
+		if !ok {
+			delta.MissingChunks = append(delta.MissingChunks, index)
+		}
+	}
+
+	return delta, nil
+}
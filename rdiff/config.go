@@ -1,11 +1,69 @@
 package rdiff
 
-import "github.com/sirupsen/logrus"
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
 
 type Config struct {
 	Logger    logrus.FieldLogger
 	ChunkSize int
 
+	// Progress is optional and called periodically with the amount of bytes
+	// processed.
+	Progress ProgressFunc
+
 	OriginalSource DataSource
 	UpdatedSource  DataSource
 }
+
+// Validate checks that the configuration can be used to compute a diff.
+func (c *Config) Validate() error {
+	if err := c.options().Validate(); err != nil {
+		return err
+	}
+
+	if c.OriginalSource == nil {
+		return fmt.Errorf("%w: original", ErrMissingSource)
+	}
+
+	if c.UpdatedSource == nil {
+		return fmt.Errorf("%w: updated", ErrMissingSource)
+	}
+
+	return nil
+}
+
+func (c *Config) options() Options {
+	return Options{
+		Logger:    c.Logger,
+		ChunkSize: c.ChunkSize,
+		Progress:  c.Progress,
+	}
+}
+
+// MaxChunkSize bounds the chunk size. Chunks are held in memory whole, so
+// larger chunk sizes only come from corrupt or malicious input.
+const MaxChunkSize = 16 * 1024 * 1024
+
+// Options configure a Signer or a Differ.
+// ChunkSize is only used by the Signer, a Differ always uses the chunk size
+// recorded in the Signature it was created with.
+type Options struct {
+	Logger    logrus.FieldLogger
+	ChunkSize int
+
+	// Progress is optional and called periodically with the amount of bytes
+	// processed.
+	Progress ProgressFunc
+}
+
+// Validate checks that the options can be used to generate a signature.
+func (o Options) Validate() error {
+	if o.ChunkSize <= 0 || o.ChunkSize > MaxChunkSize {
+		return fmt.Errorf("%w: %d", ErrInvalidChunkSize, o.ChunkSize)
+	}
+
+	return nil
+}
@@ -7,17 +7,32 @@ package rdiff
 // Position represents the file position this chunk starts. It differs from the
 // ChunkIndex as this is the actual file position and not relative to the
 // Signature.
+// Offset and Length select the part of the chunk that is copied, starting
+// Offset bytes into it. A Length of 0 copies up to the end of the chunk. A
+// Differ always copies whole chunks, parts only occur in composed deltas (see
+// Compose).
 type DeltaChunk struct {
-	ChunkIndex int
-	NewBytes   []byte
-	Position   int
+	ChunkIndex int    `json:"chunk_index"`
+	NewBytes   []byte `json:"new_bytes"`
+	Position   int    `json:"position"`
+	Offset     int    `json:"offset,omitempty"`
+	Length     int    `json:"length,omitempty"`
 }
 
 // Delta represents all changed chunks relative to the Signature.
-// If len(Changes) > len(Signature) that means new chunks were added at the end.
+// ChunkSize and ChunkCount describe the Signature the delta was generated
+// from. A change with ChunkIndex == ChunkCount holds data that was added at the
+// end of the file.
+// Changes are ordered by Position, writing the NewBytes and original chunk of
+// each change in order reconstructs the updated file (see Apply).
 // MissingChunks contains all chunks that are not detectable anymore from the
 // Signature of the original file.
+// Original and Updated are the Digests of both files, if they are known.
 type Delta struct {
-	Changes       []DeltaChunk
-	MissingChunks []int
+	ChunkSize     int          `json:"chunk_size"`
+	ChunkCount    int          `json:"chunk_count"`
+	Changes       []DeltaChunk `json:"changes"`
+	MissingChunks []int        `json:"missing_chunks"`
+	Original      *Digest      `json:"original,omitempty"`
+	Updated       *Digest      `json:"updated,omitempty"`
 }
@@ -0,0 +1,181 @@
+package rdiff
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/sol1du2/rdetective/rdiff/rhash"
+)
+
+// Differ generates the Delta of updated files relative to a Signature.
+// A Differ only reads its Signature, it is safe to use from several goroutines
+// at once.
+type Differ struct {
+	signature Signature
+	options   Options
+}
+
+func NewDiffer(signature Signature, options Options) *Differ {
+	return &Differ{
+		signature: signature,
+		options:   options,
+	}
+}
+
+// Diff reads r until EOF and returns its Delta relative to the Signature of
+// the Differ.
+func (d *Differ) Diff(r io.Reader) (Delta, error) {
+	return d.DiffContext(context.Background(), r)
+}
+
+// DiffContext is like Diff but stops as soon as ctx is done, returning the
+// context's error.
+func (d *Differ) DiffContext(ctx context.Context, r io.Reader) (Delta, error) {
+	return d.diff(ctx, r, readerSize(r))
+}
+
+// blockSize is the amount of data read from the updated file at once.
+const blockSize = 64 * 1024
+
+func (d *Differ) diff(ctx context.Context, r io.Reader, size int64) (Delta, error) {
+	chunkSize := d.signature.ChunkSize
+	sig := &d.signature
+	matches := newMatcher(sig)
+	progress := newProgressTracker(ctx, d.options.Progress, StageDelta, size)
+
+	delta := Delta{
+		ChunkSize:  chunkSize,
+		ChunkCount: len(sig.Chunks),
+		Changes:    []DeltaChunk{},
+	}
+
+	if chunkSize <= 0 || chunkSize > MaxChunkSize {
+		return delta, fmt.Errorf("%w: signature has chunk size %d", ErrInvalidChunkSize, chunkSize)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return delta, err
+	}
+
+	// The updated file is read in blocks into buf. The window that is matched
+	// against the signature is buf[start:start+chunkSize] and the new bytes in
+	// front of it that are not in newBytes yet are buf[literal:start]. They
+	// are only copied to newBytes when the window matches, or when the buffer
+	// is refilled.
+	buf := make([]byte, 0, chunkSize+blockSize)
+	start, literal := 0, 0
+	eof := false
+
+	digest := newDigester()
+
+	var sum uint32
+	hashed := false
+
+	var newBytes []byte
+	newBytesLen := 0
+	i := 0
+	for {
+		// Rolling needs the byte after the window.
+		if len(buf)-start <= chunkSize && !eof {
+			newBytes = append(newBytes, buf[literal:start]...)
+			buf = buf[:copy(buf, buf[start:])]
+			start, literal = 0, 0
+
+			n, err := io.ReadFull(r, buf[len(buf):cap(buf)])
+			_, _ = digest.Write(buf[len(buf) : len(buf)+n])
+			buf = buf[:len(buf)+n]
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return delta, &SourceError{Source: "updated", Op: "read", Err: err}
+			}
+
+			if err = progress.add(n); err != nil {
+				return delta, err
+			}
+
+			continue
+		}
+
+		if len(buf)-start < chunkSize {
+			break // Continue until chunk is full or we reached EOF.
+		}
+
+		if !hashed {
+			sum = rhash.Checksum(buf[start : start+chunkSize])
+			hashed = true
+		}
+
+		// Roll through the buffer until the window matches a chunk of the
+		// signature, or the buffer runs out.
+		index := -1
+		for {
+			if index = matches.match(sum, buf[start:start+chunkSize]); index >= 0 || start+chunkSize == len(buf) {
+				break
+			}
+
+			sum = rhash.RollSum(sum, chunkSize, buf[start], buf[start+chunkSize])
+			start++
+		}
+
+		if index < 0 {
+			if eof {
+				break
+			}
+
+			continue
+		}
+
+		newBytes = append(newBytes, buf[literal:start]...)
+		delta.Changes = append(delta.Changes, DeltaChunk{
+			ChunkIndex: index,
+			NewBytes:   newBytes,
+			Position:   i*chunkSize + newBytesLen,
+		})
+
+		newBytesLen += len(newBytes)
+
+		newBytes = []byte{}
+		start += chunkSize
+		literal = start
+		hashed = false
+
+		i++
+	}
+
+	newBytes = append(newBytes, buf[literal:start]...)
+	window := buf[start:]
+
+	index := -1
+	if len(window) > 0 && len(window) < chunkSize { // Try last chunk if it's smaller than size.
+		index = matches.match(rhash.Checksum(window), window)
+	}
+
+	if index >= 0 {
+		delta.Changes = append(delta.Changes, DeltaChunk{
+			ChunkIndex: index,
+			NewBytes:   newBytes,
+			Position:   i*chunkSize + newBytesLen,
+		})
+	} else if len(newBytes)+len(window) > 0 { // Add data that is detected at the end of the file.
+		delta.Changes = append(delta.Changes, DeltaChunk{
+			ChunkIndex: len(sig.Chunks), // New index
+			NewBytes:   append(newBytes, window...),
+			Position:   i*chunkSize + newBytesLen,
+		})
+	}
+
+	progress.done()
+
+	delta.Original = copyDigest(sig.Digest)
+	delta.Updated = digest.digest()
+
+	// Store missing chunks.
+	// Note(sol1du2): We could potentially just compare the delta with the
+	// signature for the missing chunks. But this makes the result a bit nicer
+	// to parse.
+	delta.MissingChunks = matches.unmatched()
+
+	return delta, nil
+}
@@ -0,0 +1,142 @@
+package rdiff
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Digest identifies the content of a whole file by its size and sha256.
+// Signatures record the Digest of the file they were generated from, and
+// deltas the Digests of both the original and the updated file, so that a
+// delta is only ever applied to the right original (see VerifyOriginal) and
+// the result is checked (see Apply).
+type Digest struct {
+	Size   int64
+	SHA256 [sha256.Size]byte
+}
+
+// NewDigest reads r until EOF and returns its Digest.
+func NewDigest(r io.Reader) (Digest, error) {
+	d := newDigester()
+	if _, err := io.Copy(d, r); err != nil {
+		return Digest{}, err
+	}
+
+	return *d.digest(), nil
+}
+
+func (d Digest) String() string {
+	return fmt.Sprintf("%d bytes with sha256 %x", d.Size, d.SHA256)
+}
+
+type jsonDigest struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// MarshalJSON encodes the sha256 of the digest as hex string.
+func (d Digest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonDigest{
+		Size:   d.Size,
+		SHA256: hex.EncodeToString(d.SHA256[:]),
+	})
+}
+
+func (d *Digest) UnmarshalJSON(data []byte) error {
+	var decoded jsonDigest
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptInput, err)
+	}
+
+	sum, err := hex.DecodeString(decoded.SHA256)
+	if err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("%w: invalid sha256 %q", ErrCorruptInput, decoded.SHA256)
+	}
+
+	if decoded.Size < 0 {
+		return fmt.Errorf("%w: invalid size %d", ErrCorruptInput, decoded.Size)
+	}
+
+	d.Size = decoded.Size
+	copy(d.SHA256[:], sum)
+
+	return nil
+}
+
+// VerifyOriginal reads original until EOF and checks that it is the file delta
+// was generated from. It fails with ErrVerificationFailed if it is not. Deltas
+// that do not record the Digest of their original, like deltas of older
+// versions, cannot be checked and always pass.
+func VerifyOriginal(ctx context.Context, original io.Reader, delta Delta) error {
+	if delta.Original == nil {
+		return nil
+	}
+
+	d := newDigester()
+	buf := make([]byte, blockSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := original.Read(buf)
+		_, _ = d.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return &SourceError{Source: "original", Op: "read", Err: err}
+		}
+	}
+
+	return checkDigest("original", d.digest(), delta.Original)
+}
+
+// checkDigest compares the digest of the named file with the expected one.
+func checkDigest(name string, digest, expected *Digest) error {
+	if expected == nil || *digest == *expected {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s has %s, expected %s", ErrVerificationFailed, name, digest, expected)
+}
+
+// digester computes the Digest of the data written to it.
+type digester struct {
+	hash hash.Hash
+	size int64
+}
+
+func newDigester() *digester {
+	return &digester{hash: sha256.New()}
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.hash.Write(p)
+}
+
+func (d *digester) digest() *Digest {
+	digest := &Digest{Size: d.size}
+	d.hash.Sum(digest.SHA256[:0])
+
+	return digest
+}
+
+// copyDigest returns a copy of d, so that deltas and signatures never share
+// their digests.
+func copyDigest(d *Digest) *Digest {
+	if d == nil {
+		return nil
+	}
+
+	digest := *d
+
+	return &digest
+}
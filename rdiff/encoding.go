@@ -0,0 +1,426 @@
+package rdiff
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Serialized signatures start with signatureMagic followed by the encoding
+// version. All integers are unsigned varints, except the hashes which are
+// stored as big endian uint32.
+// Deltas that copy parts of chunks are written with partsVersion, which adds
+// the offset and length to every change. Signatures and deltas with Digests
+// are written with digestsVersion, which adds the Digests after the header
+// and, for deltas, implies partsVersion. Signatures with strong hashes are
+// written with strongVersion, which implies digestsVersion and adds the
+// StrongSize bytes of the strong hash after the hash of every chunk. All other
+// signatures and deltas keep encodingVersion so that older readers can still
+// decode them.
+const (
+	signatureMagic  = "RDSG"
+	deltaMagic      = "RDDL"
+	encodingVersion = 1
+	partsVersion    = 2
+	digestsVersion  = 3
+	strongVersion   = 4
+)
+
+// encoder buffers writes and remembers the first error, so callers only need
+// to check for errors once at the end.
+type encoder struct {
+	w       *bufio.Writer
+	written int64
+	err     error
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func newEncoder(w io.Writer) *encoder {
+	return &encoder{w: bufio.NewWriter(w)}
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+
+	n, err := e.w.Write(p)
+	e.written += int64(n)
+	e.err = err
+}
+
+func (e *encoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.scratch[:], v)
+	e.write(e.scratch[:n])
+}
+
+func (e *encoder) uint32(v uint32) {
+	binary.BigEndian.PutUint32(e.scratch[:4], v)
+	e.write(e.scratch[:4])
+}
+
+// digests writes a bitmask of the digests that are set, followed by their size
+// and sha256.
+func (e *encoder) digests(digests ...*Digest) {
+	var mask uint64
+	for i, digest := range digests {
+		if digest != nil {
+			mask |= 1 << i
+		}
+	}
+
+	e.uvarint(mask)
+	for _, digest := range digests {
+		if digest != nil {
+			e.uvarint(uint64(digest.Size))
+			e.write(digest.SHA256[:])
+		}
+	}
+}
+
+func (e *encoder) flush() (int64, error) {
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+
+	return e.written, e.err
+}
+
+// decoder is the counterpart of encoder. Every failure is reported as
+// ErrCorruptInput.
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func newDecoder(r io.Reader) *decoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	return &decoder{r: br}
+}
+
+func (d *decoder) fail(err error) {
+	if d.err != nil {
+		return
+	}
+
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+
+	d.err = fmt.Errorf("%w: %v", ErrCorruptInput, err)
+}
+
+func (d *decoder) read(p []byte) {
+	if d.err != nil {
+		return
+	}
+
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		d.fail(err)
+	}
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+
+	return v
+}
+
+// int decodes a non-negative integer that must not exceed max.
+func (d *decoder) int(max uint64) int {
+	v := d.uvarint()
+	if v > max {
+		d.fail(fmt.Errorf("value %d out of range", v))
+		return 0
+	}
+
+	return int(v)
+}
+
+// bytes decodes a length prefixed byte slice. The slice grows with the data
+// actually read, so a corrupt length cannot cause a huge allocation.
+func (d *decoder) bytes() []byte {
+	n := d.int(maxInt)
+	if d.err != nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+		d.fail(err)
+		return nil
+	}
+
+	return buf.Bytes()
+}
+
+func (d *decoder) uint32() uint32 {
+	var b [4]byte
+	d.read(b[:])
+
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// digests decodes the digests written by encoder.digests into the given
+// pointers.
+func (d *decoder) digests(digests ...**Digest) {
+	mask := d.uvarint()
+	if mask >= 1<<len(digests) {
+		d.fail(fmt.Errorf("invalid digests %d", mask))
+		return
+	}
+
+	for i, digest := range digests {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+
+		decoded := &Digest{Size: int64(d.int(maxInt))}
+		d.read(decoded.SHA256[:])
+		if d.err != nil {
+			return
+		}
+
+		*digest = decoded
+	}
+}
+
+// header reads magic and the encoding version, which must be between
+// encodingVersion and max. It returns the version.
+func (d *decoder) header(magic string, max byte) byte {
+	b := make([]byte, len(magic)+1)
+	d.read(b)
+	if d.err != nil {
+		return 0
+	}
+
+	if string(b[:len(magic)]) != magic {
+		d.fail(fmt.Errorf("unexpected magic %q", b[:len(magic)]))
+		return 0
+	}
+
+	version := b[len(magic)]
+	if version < encodingVersion || version > max {
+		d.fail(fmt.Errorf("unsupported version %d", version))
+	}
+
+	return version
+}
+
+// chunkSize returns ErrInvalidChunkSize for a decoded chunk size that exceeds
+// MaxChunkSize, before anything is allocated for it.
+func (d *decoder) chunkSize(size int) error {
+	if d.err == nil && size > MaxChunkSize {
+		return fmt.Errorf("%w: %d exceeds %d", ErrInvalidChunkSize, size, MaxChunkSize)
+	}
+
+	return nil
+}
+
+// maxInt bounds decoded lengths and counts.
+const maxInt = uint64(^uint(0) >> 1)
+
+// WriteTo serializes the signature to w. Only the chunk hashes are written,
+// the chunk data (Window) stays local.
+func (s Signature) WriteTo(w io.Writer) (int64, error) {
+	e := newEncoder(w)
+
+	version := byte(encodingVersion)
+	if s.Digest != nil {
+		version = digestsVersion
+	}
+
+	if s.hasStrong() {
+		version = strongVersion
+	}
+
+	e.write([]byte(signatureMagic))
+	e.write([]byte{version})
+	e.uvarint(uint64(s.ChunkSize))
+	e.uvarint(uint64(len(s.Chunks)))
+	if version >= digestsVersion {
+		e.digests(s.Digest)
+	}
+	for _, chunk := range s.Chunks {
+		e.uint32(chunk.Adler32)
+		if version == strongVersion {
+			e.write(chunk.Strong)
+		}
+	}
+
+	return e.flush()
+}
+
+// hasStrong reports whether all chunks of s have a strong hash. Signatures are
+// only written with strong hashes if all chunks have one.
+func (s Signature) hasStrong() bool {
+	for _, chunk := range s.Chunks {
+		if len(chunk.Strong) != StrongSize {
+			return false
+		}
+	}
+
+	return len(s.Chunks) > 0
+}
+
+// ReadSignature deserializes a signature written by Signature.WriteTo. The
+// chunks of the returned signature have no Window, and no Strong hash if the
+// signature was written by an older version.
+func ReadSignature(r io.Reader) (Signature, error) {
+	d := newDecoder(r)
+
+	version := d.header(signatureMagic, strongVersion)
+	if version == partsVersion {
+		d.fail(fmt.Errorf("unsupported version %d", version))
+	}
+
+	signature := Signature{
+		ChunkSize: d.int(maxInt),
+		Chunks:    make([]SignatureChunk, 0),
+		indexMap:  make(map[uint32][]int),
+	}
+	if err := d.chunkSize(signature.ChunkSize); err != nil {
+		return Signature{}, err
+	}
+	count := d.int(maxInt)
+	if version >= digestsVersion {
+		d.digests(&signature.Digest)
+	}
+
+	for i := 0; i < count && d.err == nil; i++ {
+		chunk := SignatureChunk{Adler32: d.uint32()}
+		if version == strongVersion {
+			chunk.Strong = make([]byte, StrongSize)
+			d.read(chunk.Strong)
+		}
+
+		if d.err != nil {
+			break
+		}
+
+		signature.Chunks = append(signature.Chunks, chunk)
+		signature.indexMap[chunk.Adler32] = append(signature.indexMap[chunk.Adler32], i)
+	}
+
+	if d.err == nil && signature.ChunkSize <= 0 {
+		d.fail(fmt.Errorf("invalid chunk size %d", signature.ChunkSize))
+	}
+
+	if d.err != nil {
+		return Signature{}, d.err
+	}
+
+	return signature, nil
+}
+
+// WriteTo serializes the delta to w.
+func (d Delta) WriteTo(w io.Writer) (int64, error) {
+	e := newEncoder(w)
+
+	version := byte(encodingVersion)
+	for _, change := range d.Changes {
+		if change.Offset != 0 || change.Length != 0 {
+			version = partsVersion
+			break
+		}
+	}
+
+	if d.Original != nil || d.Updated != nil {
+		version = digestsVersion
+	}
+
+	e.write([]byte(deltaMagic))
+	e.write([]byte{version})
+	e.uvarint(uint64(d.ChunkSize))
+	e.uvarint(uint64(d.ChunkCount))
+	if version == digestsVersion {
+		e.digests(d.Original, d.Updated)
+	}
+
+	e.uvarint(uint64(len(d.Changes)))
+	for _, change := range d.Changes {
+		e.uvarint(uint64(change.ChunkIndex))
+		e.uvarint(uint64(change.Position))
+		e.uvarint(uint64(len(change.NewBytes)))
+		e.write(change.NewBytes)
+		if version >= partsVersion {
+			e.uvarint(uint64(change.Offset))
+			e.uvarint(uint64(change.Length))
+		}
+	}
+
+	e.uvarint(uint64(len(d.MissingChunks)))
+	for _, index := range d.MissingChunks {
+		e.uvarint(uint64(index))
+	}
+
+	return e.flush()
+}
+
+// ReadDelta deserializes a delta written by Delta.WriteTo.
+func ReadDelta(r io.Reader) (Delta, error) {
+	d := newDecoder(r)
+
+	version := d.header(deltaMagic, digestsVersion)
+	delta := Delta{
+		ChunkSize:  d.int(maxInt),
+		ChunkCount: d.int(maxInt),
+		Changes:    []DeltaChunk{},
+	}
+	if err := d.chunkSize(delta.ChunkSize); err != nil {
+		return Delta{}, err
+	}
+	if version == digestsVersion {
+		d.digests(&delta.Original, &delta.Updated)
+	}
+
+	count := d.int(maxInt)
+	for i := 0; i < count && d.err == nil; i++ {
+		change := DeltaChunk{
+			ChunkIndex: d.int(uint64(delta.ChunkCount)),
+			Position:   d.int(maxInt),
+			NewBytes:   d.bytes(),
+		}
+
+		if version >= partsVersion {
+			change.Offset = d.int(uint64(delta.ChunkSize))
+			change.Length = d.int(uint64(delta.ChunkSize))
+		}
+
+		if d.err == nil {
+			delta.Changes = append(delta.Changes, change)
+		}
+	}
+
+	count = d.int(maxInt)
+	for i := 0; i < count && d.err == nil; i++ {
+		index := d.int(uint64(delta.ChunkCount))
+		if d.err == nil {
+			delta.MissingChunks = append(delta.MissingChunks, index)
+		}
+	}
+
+	if d.err == nil && delta.ChunkSize <= 0 {
+		d.fail(fmt.Errorf("invalid chunk size %d", delta.ChunkSize))
+	}
+
+	if d.err != nil {
+		return Delta{}, d.err
+	}
+
+	return delta, nil
+}
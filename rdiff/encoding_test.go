@@ -0,0 +1,317 @@
+package rdiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestSignatureEncoding(t *testing.T) {
+	original := "hello world"
+	updated := "hello there world"
+
+	s, err := NewSigner(Options{ChunkSize: 3}).Sign(strings.NewReader(original))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	n, err := s.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("error encoding signature: %s", err.Error())
+	}
+
+	if n != int64(buf.Len()) {
+		t.Errorf("unexpected encoded length, got %d, expected %d", n, buf.Len())
+	}
+
+	decoded, err := ReadSignature(&buf)
+	if err != nil {
+		t.Fatalf("error decoding signature: %s", err.Error())
+	}
+
+	if decoded.ChunkSize != s.ChunkSize {
+		t.Errorf("unexpected chunk size, got %d, expected %d", decoded.ChunkSize, s.ChunkSize)
+	}
+
+	if decoded.Digest == nil || *decoded.Digest != *s.Digest {
+		t.Errorf("unexpected digest, got %v, expected %v", decoded.Digest, s.Digest)
+	}
+
+	// The data of the chunks is not serialized.
+	for i := range s.Chunks {
+		s.Chunks[i].Window = nil
+	}
+	compareSignatures(decoded, s, t)
+
+	expectedDelta, err := NewDiffer(s, Options{}).Diff(strings.NewReader(updated))
+	if err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	delta, err := NewDiffer(decoded, Options{}).Diff(strings.NewReader(updated))
+	if err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	compareDeltas(delta, expectedDelta, t)
+}
+
+func TestSignatureDecodingCorrupt(t *testing.T) {
+	s, err := NewSigner(Options{ChunkSize: 3}).Sign(strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	if _, err = s.WriteTo(&buf); err != nil {
+		t.Fatalf("error encoding signature: %s", err.Error())
+	}
+	encoded := buf.Bytes()
+
+	tests := map[string][]byte{
+		"empty":           {},
+		"bad magic":       append([]byte("XXXX"), encoded[4:]...),
+		"bad version":     append(append([]byte(signatureMagic), 99), encoded[5:]...),
+		"truncated":       encoded[:len(encoded)-1],
+		"zero chunk size": {'R', 'D', 'S', 'G', encodingVersion, 0, 0},
+	}
+
+	for name, data := range tests {
+		if _, err := ReadSignature(bytes.NewReader(data)); !errors.Is(err, ErrCorruptInput) {
+			t.Errorf("%s: unexpected error, got %v, expected %v", name, err, ErrCorruptInput)
+		}
+	}
+}
+
+func TestDeltaEncoding(t *testing.T) {
+	original := "hello world"
+	updated := "hello there world!"
+
+	s, err := NewSigner(Options{ChunkSize: 3}).Sign(strings.NewReader(original))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	delta, err := NewDiffer(s, Options{}).Diff(strings.NewReader(updated))
+	if err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	if _, err = delta.WriteTo(&buf); err != nil {
+		t.Fatalf("error encoding delta: %s", err.Error())
+	}
+
+	decoded, err := ReadDelta(&buf)
+	if err != nil {
+		t.Fatalf("error decoding delta: %s", err.Error())
+	}
+
+	if decoded.ChunkSize != delta.ChunkSize || decoded.ChunkCount != delta.ChunkCount {
+		t.Errorf("unexpected chunk layout, got %d/%d, expected %d/%d",
+			decoded.ChunkSize, decoded.ChunkCount, delta.ChunkSize, delta.ChunkCount)
+	}
+
+	compareDeltas(decoded, delta, t)
+
+	if decoded.Original == nil || *decoded.Original != *delta.Original ||
+		decoded.Updated == nil || *decoded.Updated != *delta.Updated {
+		t.Errorf("unexpected digests, got %v and %v, expected %v and %v", decoded.Original, decoded.Updated, delta.Original, delta.Updated)
+	}
+
+	var patched bytes.Buffer
+	if err = Apply(strings.NewReader(original), decoded, &patched); err != nil {
+		t.Fatalf("error applying delta: %s", err.Error())
+	}
+
+	if patched.String() != updated {
+		t.Errorf("unexpected patch result, got %q, expected %q", patched.String(), updated)
+	}
+}
+
+func TestDeltaDecodingCorrupt(t *testing.T) {
+	delta := Delta{
+		ChunkSize:  2,
+		ChunkCount: 3,
+		Changes: []DeltaChunk{
+			{ChunkIndex: 1, NewBytes: []byte("abc"), Position: 0},
+		},
+		MissingChunks: []int{0, 2},
+	}
+
+	var buf bytes.Buffer
+	if _, err := delta.WriteTo(&buf); err != nil {
+		t.Fatalf("error encoding delta: %s", err.Error())
+	}
+	encoded := buf.Bytes()
+
+	tests := map[string][]byte{
+		"empty":          {},
+		"bad magic":      append([]byte(signatureMagic), encoded[4:]...),
+		"truncated":      encoded[:len(encoded)-1],
+		"huge new bytes": {'R', 'D', 'D', 'L', encodingVersion, 2, 3, 1, 1, 0, 0xff, 0xff, 0xff, 0xff, 0x0f, 'a'},
+		"index range":    {'R', 'D', 'D', 'L', encodingVersion, 2, 3, 1, 4, 0, 0, 0},
+	}
+
+	for name, data := range tests {
+		if _, err := ReadDelta(bytes.NewReader(data)); !errors.Is(err, ErrCorruptInput) {
+			t.Errorf("%s: unexpected error, got %v, expected %v", name, err, ErrCorruptInput)
+		}
+	}
+}
+
+func TestSignatureJSON(t *testing.T) {
+	s, err := NewSigner(Options{ChunkSize: 2}).Sign(strings.NewReader("hellllllo"))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("error encoding signature: %s", err.Error())
+	}
+
+	var decoded Signature
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("error decoding signature: %s", err.Error())
+	}
+
+	for i := range s.Chunks {
+		s.Chunks[i].Window = nil
+	}
+	compareSignatures(decoded, s, t)
+
+	if err = json.Unmarshal([]byte(`{"chunk_size":0,"chunks":[]}`), &decoded); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrCorruptInput)
+	}
+}
+
+func TestDecodedSignatureCollisions(t *testing.T) {
+	// Small chunks of random data have many adler32 collisions, a decoded
+	// signature must only match chunks with equal strong hashes.
+	r := rand.New(rand.NewSource(1))
+	original := make([]byte, 64*1024)
+	r.Read(original)
+	updated := make([]byte, 1024*1024)
+	r.Read(updated)
+	copy(updated[512*1024:], original[:32*1024])
+
+	s, err := NewSigner(Options{ChunkSize: 16}).Sign(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	if _, err = s.WriteTo(&buf); err != nil {
+		t.Fatalf("error encoding signature: %s", err.Error())
+	}
+
+	decoded, err := ReadSignature(&buf)
+	if err != nil {
+		t.Fatalf("error decoding signature: %s", err.Error())
+	}
+
+	delta, err := NewDiffer(decoded, Options{}).Diff(bytes.NewReader(updated))
+	if err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	var patched bytes.Buffer
+	if err = Apply(bytes.NewReader(original), delta, &patched); err != nil {
+		t.Fatalf("error applying delta: %s", err.Error())
+	}
+
+	if !bytes.Equal(patched.Bytes(), updated) {
+		t.Errorf("unexpected result of patch")
+	}
+}
+
+func TestMaxChunkSize(t *testing.T) {
+	if err := (Options{ChunkSize: MaxChunkSize + 1}).Validate(); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidChunkSize)
+	}
+
+	// A chunk size of 0xcdcdcdcd, which must not reach any allocation.
+	if _, err := ReadSignature(strings.NewReader("RDSG\x01\xcd\xcd\xcd\xcd0\x00")); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidChunkSize)
+	}
+
+	if _, err := ReadDelta(strings.NewReader("RDDL\x01\xcd\xcd\xcd\xcd0\x00\x00\x00")); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidChunkSize)
+	}
+
+	err := json.Unmarshal([]byte(`{"chunk_size":1099511627776,"chunks":[]}`), &Signature{})
+	if !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidChunkSize)
+	}
+}
+
+func TestDigestEncoding(t *testing.T) {
+	delta := Delta{
+		ChunkSize:  2,
+		ChunkCount: 3,
+		Changes:    []DeltaChunk{{ChunkIndex: 1, NewBytes: []byte("abc")}},
+		Updated:    digest("abcde"),
+	}
+
+	var buf bytes.Buffer
+	if _, err := delta.WriteTo(&buf); err != nil {
+		t.Fatalf("error encoding delta: %s", err.Error())
+	}
+
+	if version := buf.Bytes()[len(deltaMagic)]; version != digestsVersion {
+		t.Errorf("unexpected version, got %d, expected %d", version, digestsVersion)
+	}
+
+	decoded, err := ReadDelta(&buf)
+	if err != nil {
+		t.Fatalf("error decoding delta: %s", err.Error())
+	}
+
+	if decoded.Original != nil || decoded.Updated == nil || *decoded.Updated != *delta.Updated {
+		t.Errorf("unexpected digests, got %v and %v, expected %v and %v", decoded.Original, decoded.Updated, nil, delta.Updated)
+	}
+
+	// Deltas without digests stay readable by older versions.
+	delta.Updated = nil
+	buf.Reset()
+	if _, err = delta.WriteTo(&buf); err != nil {
+		t.Fatalf("error encoding delta: %s", err.Error())
+	}
+
+	if version := buf.Bytes()[len(deltaMagic)]; version != encodingVersion {
+		t.Errorf("unexpected version, got %d, expected %d", version, encodingVersion)
+	}
+
+	data, err := json.Marshal(generateDelta(t, "hello", "hello world", 2))
+	if err != nil {
+		t.Fatalf("error encoding delta: %s", err.Error())
+	}
+
+	if !bytes.Contains(data, []byte(`"updated":{"size":11,"sha256":"b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"}`)) {
+		t.Errorf("unexpected JSON encoding %s", data)
+	}
+
+	var decodedJSON Delta
+	if err = json.Unmarshal(data, &decodedJSON); err != nil {
+		t.Fatalf("error decoding delta: %s", err.Error())
+	}
+
+	if *decodedJSON.Original != *digest("hello") || *decodedJSON.Updated != *digest("hello world") {
+		t.Errorf("unexpected digests, got %v and %v", decodedJSON.Original, decodedJSON.Updated)
+	}
+
+	corrupt := []string{
+		`{"chunk_size":2,"updated":{"size":1,"sha256":"00"}}`,
+		`{"chunk_size":2,"updated":{"size":-1,"sha256":"b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"}}`,
+	}
+	for _, data := range corrupt {
+		if err = json.Unmarshal([]byte(data), &decodedJSON); !errors.Is(err, ErrCorruptInput) {
+			t.Errorf("%s: unexpected error, got %v, expected %v", data, err, ErrCorruptInput)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package rdiff
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidChunkSize is returned when a chunk size is not positive or
+	// exceeds MaxChunkSize.
+	ErrInvalidChunkSize = errors.New("invalid chunk size")
+	// ErrMissingSource is returned when a required data source is not set.
+	ErrMissingSource = errors.New("missing data source")
+	// ErrSourceOpen is returned when a data source cannot be opened.
+	ErrSourceOpen = errors.New("failed to open data source")
+	// ErrCorruptInput is returned when the data of a source cannot be read or
+	// decoded.
+	ErrCorruptInput = errors.New("corrupt input")
+	// ErrVerificationFailed is returned when a file does not match the Digest
+	// a delta records for it.
+	ErrVerificationFailed = errors.New("verification failed")
+)
+
+// SourceError records a failure to open or read one of the data sources.
+// It matches ErrSourceOpen or ErrCorruptInput with errors.Is, depending on the
+// operation that failed.
+type SourceError struct {
+	Source string
+	Op     string
+	Err    error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("%s %s source: %v", e.Op, e.Source, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SourceError) Is(target error) bool {
+	switch target {
+	case ErrSourceOpen:
+		return e.Op == "open"
+	case ErrCorruptInput:
+		return e.Op == "read"
+	}
+
+	return false
+}
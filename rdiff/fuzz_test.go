@@ -0,0 +1,206 @@
+package rdiff
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// maxFuzzChunkSize bounds the chunk sizes of fuzzed inputs.
+const maxFuzzChunkSize = 1024
+
+// checkRoundTrip checks that the delta of updated against the signature of
+// original reconstructs updated, also after encoding and decoding it, and that
+// signatures do not depend on how the file is read.
+func checkRoundTrip(t *testing.T, original, updated []byte, chunkSize int) {
+	signer := NewSigner(Options{ChunkSize: chunkSize})
+	signature, err := signer.Sign(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	again, err := signer.Sign(iotest.OneByteReader(bytes.NewReader(original)))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	compareSignatures(again, signature, t)
+
+	delta, err := NewDiffer(signature, Options{}).Diff(bytes.NewReader(updated))
+	if err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	var patched bytes.Buffer
+	if err = Apply(bytes.NewReader(original), delta, &patched); err != nil {
+		t.Fatalf("error applying delta: %s", err.Error())
+	}
+
+	if !bytes.Equal(patched.Bytes(), updated) {
+		t.Fatalf("chunk size %d: unexpected patch result, got %d bytes, expected %d bytes", chunkSize, patched.Len(), len(updated))
+	}
+
+	var encoded bytes.Buffer
+	if _, err = delta.WriteTo(&encoded); err != nil {
+		t.Fatalf("error encoding delta: %s", err.Error())
+	}
+
+	decoded, err := ReadDelta(&encoded)
+	if err != nil {
+		t.Fatalf("error decoding delta: %s", err.Error())
+	}
+
+	compareDeltas(decoded, delta, t)
+}
+
+func TestRoundTripProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for _, chunkSize := range []int{1, 2, 3, 4, 7, 8, 16, 31, 64, 100, 256, 1024} {
+		for i := 0; i < 50; i++ {
+			original := make([]byte, r.Intn(8*chunkSize+64))
+			r.Read(original)
+
+			// Repeated data makes chunks with equal hashes.
+			if r.Intn(4) == 0 && len(original) > 0 {
+				original = bytes.Repeat(original[:1+r.Intn(len(original))], 1+r.Intn(4))
+			}
+
+			updated := original
+			for k := r.Intn(4); k >= 0; k-- {
+				updated = mutate(r, updated)
+			}
+
+			checkRoundTrip(t, original, updated, chunkSize)
+		}
+	}
+}
+
+func TestSignatureDeterministic(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	data := make([]byte, 4096)
+	r.Read(data)
+
+	for _, chunkSize := range []int{1, 3, 64, 1000, 5000} {
+		signer := NewSigner(Options{ChunkSize: chunkSize})
+		first, err := signer.Sign(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("error generating signature: %s", err.Error())
+		}
+
+		second, err := signer.Sign(iotest.HalfReader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatalf("error generating signature: %s", err.Error())
+		}
+
+		compareSignatures(second, first, t)
+
+		var a, b bytes.Buffer
+		if _, err = first.WriteTo(&a); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = second.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(a.Bytes(), b.Bytes()) {
+			t.Errorf("chunk size %d: unexpected encoded signature", chunkSize)
+		}
+	}
+}
+
+func FuzzDiffApply(f *testing.F) {
+	for _, test := range patchTests {
+		f.Add([]byte(test.original), []byte(test.updated), uint16(2))
+	}
+
+	f.Fuzz(func(t *testing.T, original, updated []byte, chunkSize uint16) {
+		checkRoundTrip(t, original, updated, 1+int(chunkSize)%maxFuzzChunkSize)
+	})
+}
+
+func FuzzReadDelta(f *testing.F) {
+	for _, test := range patchTests {
+		signature, err := NewSigner(Options{ChunkSize: 2}).Sign(strings.NewReader(test.original))
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		delta, err := NewDiffer(signature, Options{}).Diff(strings.NewReader(test.updated))
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if _, err = delta.WriteTo(&buf); err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(buf.Bytes())
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		delta, err := ReadDelta(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		// Whatever decodes can be encoded again and patching never panics.
+		var buf bytes.Buffer
+		if _, err = delta.WriteTo(&buf); err != nil {
+			t.Fatalf("error encoding delta: %s", err.Error())
+		}
+
+		decoded, err := ReadDelta(&buf)
+		if err != nil {
+			t.Fatalf("error decoding encoded delta: %s", err.Error())
+		}
+
+		compareDeltas(decoded, delta, t)
+
+		_ = Apply(bytes.NewReader(data), delta, &bytes.Buffer{})
+	})
+}
+
+func FuzzReadSignature(f *testing.F) {
+	for _, test := range patchTests {
+		signature, err := NewSigner(Options{ChunkSize: 2}).Sign(strings.NewReader(test.original))
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if _, err = signature.WriteTo(&buf); err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(buf.Bytes())
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		signature, err := ReadSignature(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		var buf bytes.Buffer
+		if _, err = signature.WriteTo(&buf); err != nil {
+			t.Fatalf("error encoding signature: %s", err.Error())
+		}
+
+		decoded, err := ReadSignature(&buf)
+		if err != nil {
+			t.Fatalf("error decoding encoded signature: %s", err.Error())
+		}
+
+		compareSignatures(decoded, signature, t)
+
+		// Diffing against any signature works.
+		if _, err = NewDiffer(signature, Options{}).Diff(bytes.NewReader(data)); err != nil {
+			t.Fatalf("error generating delta: %s", err.Error())
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package rdiff
+
+import (
+	"context"
+	"io"
+	"math"
+)
+
+// Invert returns the delta that turns the updated file back into the original,
+// given the original file and the delta from the original to the updated file.
+// The updated file is reconstructed on the fly and never held in memory, only
+// the hashes of its chunks are.
+func Invert(original io.ReaderAt, delta Delta) (Delta, error) {
+	return InvertContext(context.Background(), original, delta)
+}
+
+// InvertContext is like Invert but stops as soon as ctx is done, returning the
+// context's error.
+func InvertContext(ctx context.Context, original io.ReaderAt, delta Delta) (Delta, error) {
+	options := Options{ChunkSize: delta.ChunkSize}
+	if err := options.Validate(); err != nil {
+		return Delta{}, err
+	}
+
+	// Sign the updated file while it is being patched.
+	updated, patched := io.Pipe()
+	go func() {
+		patched.CloseWithError(Apply(original, delta, patched))
+	}()
+
+	signature, err := NewSigner(options).SignHashes(ctx, updated)
+	// Unblock Apply in case signing stopped early.
+	_ = updated.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		return Delta{}, unwrapSourceError(err)
+	}
+
+	return NewDiffer(signature, options).DiffContext(ctx, io.NewSectionReader(original, 0, math.MaxInt64))
+}
+
+// unwrapSourceError returns the error of Apply that was passed through the
+// pipe to the Signer, instead of reporting it as a read error of the updated
+// file.
+func unwrapSourceError(err error) error {
+	if sourceErr, ok := err.(*SourceError); ok && sourceErr.Op == "read" {
+		return sourceErr.Err
+	}
+
+	return err
+}
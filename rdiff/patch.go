@@ -0,0 +1,110 @@
+package rdiff
+
+import (
+	"fmt"
+	"io"
+)
+
+// Apply reconstructs the updated file by applying delta to the original file
+// it was generated from, writing the result to w.
+// If the delta records the Digest of the updated file, the result is checked
+// against it once it is written, and Apply fails with ErrVerificationFailed if
+// it does not match. w must not be used when Apply fails. Use VerifyOriginal
+// to check the original before.
+func Apply(original io.ReaderAt, delta Delta, w io.Writer) error {
+	if delta.ChunkSize <= 0 || delta.ChunkSize > MaxChunkSize {
+		return fmt.Errorf("%w: delta has chunk size %d", ErrInvalidChunkSize, delta.ChunkSize)
+	}
+
+	digest := newDigester()
+	if delta.Updated != nil {
+		w = io.MultiWriter(w, digest)
+	}
+
+	chunk := make([]byte, delta.ChunkSize)
+	for _, change := range delta.Changes {
+		if _, err := w.Write(change.NewBytes); err != nil {
+			return err
+		}
+
+		if change.ChunkIndex == delta.ChunkCount {
+			continue // Only new data.
+		}
+
+		if change.ChunkIndex < 0 || change.ChunkIndex > delta.ChunkCount {
+			return fmt.Errorf("%w: chunk %d out of range", ErrCorruptInput, change.ChunkIndex)
+		}
+
+		length, err := partLength(delta, change)
+		if err != nil {
+			return err
+		}
+
+		part := chunk[:length]
+
+		n, err := original.ReadAt(part, int64(change.ChunkIndex)*int64(delta.ChunkSize)+int64(change.Offset))
+		if n < len(part) && !(err == io.EOF && n > 0 && change.Length == 0) { // The last chunk may be short.
+			if err == nil || err == io.EOF {
+				err = fmt.Errorf("%w: chunk %d is beyond the end of the original", ErrCorruptInput, change.ChunkIndex)
+			}
+
+			return &SourceError{Source: "original", Op: "read", Err: err}
+		}
+
+		if _, err = w.Write(part[:n]); err != nil {
+			return err
+		}
+	}
+
+	if delta.Updated != nil {
+		return checkDigest("patched file", digest.digest(), delta.Updated)
+	}
+
+	return nil
+}
+
+// partLength returns the number of bytes change copies from its chunk, or
+// less if it is the last chunk of the original and copies up to its end.
+func partLength(delta Delta, change DeltaChunk) (int, error) {
+	if change.Offset < 0 || change.Length < 0 || change.Offset >= delta.ChunkSize ||
+		change.Length > delta.ChunkSize-change.Offset {
+		return 0, fmt.Errorf("%w: invalid part of chunk %d", ErrCorruptInput, change.ChunkIndex)
+	}
+
+	if change.Length > 0 {
+		return change.Length, nil
+	}
+
+	return delta.ChunkSize - change.Offset, nil
+}
+
+// CheckBounds makes sure every part of a chunk copied by delta lies within an
+// original of the given size, so that Apply cannot run out of original
+// halfway. It does not check the Original digest, see VerifyOriginal.
+func (d Delta) CheckBounds(size int64) error {
+	if d.ChunkSize <= 0 || d.ChunkSize > MaxChunkSize {
+		return fmt.Errorf("%w: delta has chunk size %d", ErrInvalidChunkSize, d.ChunkSize)
+	}
+
+	for _, change := range d.Changes {
+		if change.ChunkIndex == d.ChunkCount {
+			continue // Only new data.
+		}
+
+		if change.ChunkIndex < 0 || change.ChunkIndex > d.ChunkCount {
+			return fmt.Errorf("%w: chunk %d out of range", ErrCorruptInput, change.ChunkIndex)
+		}
+
+		length, err := partLength(d, change)
+		if err != nil {
+			return err
+		}
+
+		start := int64(change.ChunkIndex)*int64(d.ChunkSize) + int64(change.Offset)
+		if start >= size || (change.Length > 0 && start+int64(length) > size) {
+			return fmt.Errorf("%w: chunk %d is beyond the end of the original", ErrCorruptInput, change.ChunkIndex)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package rdiff
+
+import "context"
+
+// progressInterval is the amount of bytes processed between progress reports
+// and cancellation checks.
+const progressInterval = 64 * 1024
+
+// Stage identifies the part of the diff that is reporting progress.
+type Stage string
+
+const (
+	StageSignature Stage = "signature"
+	StageDelta     Stage = "delta"
+)
+
+// Progress represents how many bytes of a data source were processed so far.
+// Total is -1 if the size of the data source is not known.
+type Progress struct {
+	Stage     Stage
+	Processed int64
+	Total     int64
+}
+
+// ProgressFunc is called periodically while generating a signature or delta.
+type ProgressFunc func(Progress)
+
+// SizedDataSource is implemented by data sources that know their size upfront,
+// which allows progress to be reported relative to it.
+type SizedDataSource interface {
+	DataSource
+	Size() (int64, error)
+}
+
+func sourceSize(source DataSource) int64 {
+	sized, ok := source.(SizedDataSource)
+	if !ok {
+		return -1
+	}
+
+	size, err := sized.Size()
+	if err != nil {
+		return -1
+	}
+
+	return size
+}
+
+// progressTracker counts processed bytes, reports them every progressInterval
+// bytes and checks for cancellation of the context at the same time.
+type progressTracker struct {
+	ctx    context.Context
+	report ProgressFunc
+
+	progress Progress
+	next     int64
+}
+
+func newProgressTracker(ctx context.Context, report ProgressFunc, stage Stage, total int64) *progressTracker {
+	return &progressTracker{
+		ctx:    ctx,
+		report: report,
+		progress: Progress{
+			Stage: stage,
+			Total: total,
+		},
+		next: progressInterval,
+	}
+}
+
+func (p *progressTracker) add(n int) error {
+	p.progress.Processed += int64(n)
+	if p.progress.Processed < p.next {
+		return nil
+	}
+
+	p.next = p.progress.Processed + progressInterval
+	p.notify()
+
+	return p.ctx.Err()
+}
+
+func (p *progressTracker) done() {
+	p.notify()
+}
+
+func (p *progressTracker) notify() {
+	if p.report != nil {
+		p.report(p.progress)
+	}
+}
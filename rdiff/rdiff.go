@@ -2,25 +2,41 @@ package rdiff
 
 import (
 	"bufio"
+	"context"
 	"io"
-
-	"github.com/sol1du2/rdetective/rdiff/rhash"
 )
 
+// DataSource provides the data to be diffed. Every reader returned by GetReader
+// is owned by the caller, which must close it once done.
 type DataSource interface {
-	GetReader() (io.Reader, error)
+	GetReader() (io.ReadCloser, error)
 }
 
+// RollingDiff computes the Delta between two data sources. It is a thin
+// wrapper around a Signer and a Differ.
 type RollingDiff struct {
 	config *Config
 
+	originalReader io.ReadCloser
+	updatedReader  io.ReadCloser
+
 	originalBuffer *bufio.Reader
 	updatedBuffer  *bufio.Reader
 
+	originalSize int64
+	updatedSize  int64
+
 	signature Signature
+	signed    bool
 }
 
+// New creates a RollingDiff and opens both of its data sources. The returned
+// RollingDiff must be closed with Close to release them.
 func New(config *Config) (*RollingDiff, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	rd := RollingDiff{
 		config: config,
 	}
@@ -32,136 +48,90 @@ func New(config *Config) (*RollingDiff, error) {
 	return &rd, nil
 }
 
+// InitDataReaders opens both data sources, releasing any readers that were
+// previously opened. On failure nothing is left open.
 func (rd *RollingDiff) InitDataReaders() (err error) {
-	originalBuffer, err := rd.config.OriginalSource.GetReader()
-	if err != nil {
+	if err = rd.Close(); err != nil {
 		return err
 	}
 
-	updatedBuffer, err := rd.config.UpdatedSource.GetReader()
+	originalReader, err := rd.config.OriginalSource.GetReader()
 	if err != nil {
-		return err
+		return &SourceError{Source: "original", Op: "open", Err: err}
 	}
 
-	rd.originalBuffer = bufio.NewReader(originalBuffer)
-	rd.updatedBuffer = bufio.NewReader(updatedBuffer)
-
-	return nil
-}
-
-func (rd *RollingDiff) GenerateSignature() (Signature, error) {
-	chunkSize := rd.config.ChunkSize
-	reader := rd.originalBuffer
-
-	chunkData := make([]byte, chunkSize)
-
-	rd.signature = Signature{
-		Chunks:   make([]SignatureChunk, 0),
-		indexMap: make(map[uint32][]int),
+	updatedReader, err := rd.config.UpdatedSource.GetReader()
+	if err != nil {
+		_ = originalReader.Close()
+		return &SourceError{Source: "updated", Op: "open", Err: err}
 	}
 
-	for {
-		read, err := reader.Read(chunkData)
+	rd.originalReader = originalReader
+	rd.updatedReader = updatedReader
 
-		if read == 0 || err == io.EOF {
-			break
-		}
+	rd.originalBuffer = bufio.NewReader(originalReader)
+	rd.updatedBuffer = bufio.NewReader(updatedReader)
 
-		if err != nil {
-			return rd.signature, err
-		}
-
-		if read < chunkSize {
-			chunkData = chunkData[:read]
-		}
-
-		rd.signature.AddChunk(chunkData)
-	}
+	rd.originalSize = sourceSize(rd.config.OriginalSource)
+	rd.updatedSize = sourceSize(rd.config.UpdatedSource)
 
-	return rd.signature, nil
+	return nil
 }
 
-func (rd *RollingDiff) GenerateDelta() (Delta, error) {
-	chunkSize := rd.config.ChunkSize
-	reader := rd.updatedBuffer
-	sig := rd.signature
+// Close releases the readers of both data sources. It is safe to call Close
+// more than once.
+func (rd *RollingDiff) Close() error {
+	var err error
 
-	delta := Delta{
-		Changes: []DeltaChunk{},
+	if rd.originalReader != nil {
+		err = rd.originalReader.Close()
+		rd.originalReader = nil
 	}
 
-	adler32 := rhash.New()
-
-	var newBytes []byte
-	newBytesLen := 0
-	i := 0
-	for {
-		b, err := reader.ReadByte()
-		if err == io.EOF {
-			break
+	if rd.updatedReader != nil {
+		if closeErr := rd.updatedReader.Close(); err == nil {
+			err = closeErr
 		}
+		rd.updatedReader = nil
+	}
 
-		if err != nil {
-			return delta, err
-		}
-
-		adler32.Update(b)
-
-		if adler32.Size < chunkSize {
-			continue // Continue until chunk is full or we reached EOF.
-		}
+	rd.originalBuffer = nil
+	rd.updatedBuffer = nil
 
-		if adler32.Size > chunkSize {
-			removed, rollErr := adler32.Roll()
-			if rollErr != nil {
-				break
-			}
+	return err
+}
 
-			newBytes = append(newBytes, removed)
-		}
+func (rd *RollingDiff) GenerateSignature() (Signature, error) {
+	return rd.GenerateSignatureContext(context.Background())
+}
 
-		// Check match with signature.
-		index := sig.MatchChunk(adler32.Sum())
-		if index >= 0 {
-			delta.Changes = append(delta.Changes, DeltaChunk{
-				ChunkIndex: index,
-				NewBytes:   newBytes,
-				Position:   i*chunkSize + newBytesLen,
-			})
+// GenerateSignatureContext is like GenerateSignature but stops as soon as ctx
+// is done, returning the context's error.
+func (rd *RollingDiff) GenerateSignatureContext(ctx context.Context) (Signature, error) {
+	signature, err := NewSigner(rd.config.options()).sign(ctx, rd.originalBuffer, rd.originalSize, true)
+	if err != nil {
+		return signature, err
+	}
 
-			newBytesLen += len(newBytes)
+	rd.signature = signature
+	rd.signed = true
 
-			newBytes = []byte{}
-			adler32.Reset()
+	return signature, nil
+}
 
-			i++
-		}
-	}
+func (rd *RollingDiff) GenerateDelta() (Delta, error) {
+	return rd.GenerateDeltaContext(context.Background())
+}
 
-	if adler32.Size < chunkSize { // Try last chunk if it's smaller than size.
-		index := sig.MatchChunk(adler32.Sum())
-		if index >= 0 {
-			delta.Changes = append(delta.Changes, DeltaChunk{
-				ChunkIndex: index,
-				NewBytes:   newBytes,
-				Position:   i*chunkSize + newBytesLen,
-			})
+// GenerateDeltaContext is like GenerateDelta but stops as soon as ctx is done,
+// returning the context's error. The signature of the original source is
+// generated first if that did not happen yet.
+func (rd *RollingDiff) GenerateDeltaContext(ctx context.Context) (Delta, error) {
+	if !rd.signed {
+		if _, err := rd.GenerateSignatureContext(ctx); err != nil {
+			return Delta{}, err
 		}
-	} else if len(newBytes) > 0 { // Add data that is detected at the end of the file.
-		delta.Changes = append(delta.Changes, DeltaChunk{
-			ChunkIndex: len(sig.Chunks), // New index
-			NewBytes:   append(newBytes, adler32.Window...),
-			Position:   i*chunkSize + newBytesLen,
-		})
-	}
-
-	// Store missing chunks.
-	// Note(sol1du2): We could potentially just compare the delta with the
-	// signature for the missing chunks. But this makes the result a bit nicer
-	// to parse.
-	for _, indexes := range sig.indexMap {
-		delta.MissingChunks = append(delta.MissingChunks, indexes...)
 	}
 
-	return delta, nil
+	return NewDiffer(rd.signature, rd.config.options()).diff(ctx, rd.updatedBuffer, rd.updatedSize)
 }
@@ -2,10 +2,18 @@ package rdiff
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 
 	"github.com/sirupsen/logrus"
 )
@@ -14,16 +22,61 @@ type StringSource struct {
 	Data string
 }
 
-func (s StringSource) GetReader() (io.Reader, error) {
-	return strings.NewReader(s.Data), nil
+func (s StringSource) GetReader() (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(s.Data)), nil
+}
+
+func (s StringSource) Size() (int64, error) {
+	return int64(len(s.Data)), nil
+}
+
+type FileSource struct {
+	FileName string
+}
+
+func (f FileSource) GetReader() (io.ReadCloser, error) {
+	return os.Open(f.FileName)
+}
+
+// TrackingSource counts how many readers it handed out and how many of them
+// were closed again.
+type TrackingSource struct {
+	Data string
+	Err  error
+
+	opened int
+	closed int
+}
+
+type trackingReader struct {
+	io.Reader
+	source *TrackingSource
+}
+
+func (r *trackingReader) Close() error {
+	r.source.closed++
+	return nil
+}
+
+func (s *TrackingSource) GetReader() (io.ReadCloser, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	s.opened++
+	return &trackingReader{Reader: strings.NewReader(s.Data), source: s}, nil
 }
 
 func getRDiff(original, updated string) (*RollingDiff, error) {
+	return getRDiffFromSources(StringSource{Data: original}, StringSource{Data: updated})
+}
+
+func getRDiffFromSources(original, updated DataSource) (*RollingDiff, error) {
 	lvl, _ := logrus.ParseLevel("debug")
 	rh, err := New(&Config{
 		ChunkSize:      2,
-		OriginalSource: StringSource{Data: original},
-		UpdatedSource:  StringSource{Data: updated},
+		OriginalSource: original,
+		UpdatedSource:  updated,
 		Logger: &logrus.Logger{
 			Out: os.Stderr,
 			Formatter: &logrus.TextFormatter{
@@ -41,6 +94,10 @@ func getRDiff(original, updated string) (*RollingDiff, error) {
 }
 
 func compareSignatures(sig1, sig2 Signature, t *testing.T) {
+	if sig1.ChunkSize != sig2.ChunkSize {
+		t.Errorf("unexpected chunk size, got %d, expected %d", sig1.ChunkSize, sig2.ChunkSize)
+	}
+
 	if len(sig1.Chunks) != len(sig2.Chunks) {
 		t.Errorf("unexpected length of chunks, got %d, expected %d", len(sig1.Chunks), len(sig2.Chunks))
 	}
@@ -62,6 +119,10 @@ func compareSignatures(sig1, sig2 Signature, t *testing.T) {
 				i, string(chunk1.Window), string(chunk2.Window))
 		}
 
+		if !bytes.Equal(chunk1.Strong, chunk2.Strong) {
+			t.Errorf("strong hash of chunk %d different than expected, got %x, expected %x", i, chunk1.Strong, chunk2.Strong)
+		}
+
 		indexed1, ok := sig1.indexMap[chunk1.Adler32]
 		if !ok {
 			t.Errorf("indexed chunk not found, expected %d", chunk1.Adler32)
@@ -108,6 +169,10 @@ func compareDeltas(delta1, delta2 Delta, t *testing.T) {
 		if change1.Position != change2.Position {
 			t.Errorf("unexpected position, got %d, expected %d", change1.Position, change2.Position)
 		}
+
+		if change1.Offset != change2.Offset || change1.Length != change2.Length {
+			t.Errorf("unexpected part, got %d+%d, expected %d+%d", change1.Offset, change1.Length, change2.Offset, change2.Length)
+		}
 	}
 
 	for i := range delta1.MissingChunks {
@@ -121,17 +186,21 @@ func TestSignature(t *testing.T) {
 	original := "hello"
 	updated := "not part of test"
 	expectedS := Signature{
+		ChunkSize: 2,
 		Chunks: []SignatureChunk{
 			{
 				Adler32: 20381902,
+				Strong:  strongHash([]byte("he")),
 				Window:  []byte("he"),
 			},
 			{
 				Adler32: 21364953,
+				Strong:  strongHash([]byte("ll")),
 				Window:  []byte("ll"),
 			},
 			{
 				Adler32: 7340144,
+				Strong:  strongHash([]byte("o")),
 				Window:  []byte("o"),
 			},
 		},
@@ -365,3 +434,1179 @@ func TestAddChunksEnd(t *testing.T) {
 
 	compareDeltas(delta, expectedDelta, t)
 }
+
+func TestCloseReleasesSources(t *testing.T) {
+	original := &TrackingSource{Data: "hello"}
+	updated := &TrackingSource{Data: "heeello"}
+
+	rh, err := getRDiffFromSources(original, updated)
+	if err != nil {
+		t.Fatalf("error creating rdiff %s", err.Error())
+	}
+
+	if _, err = rh.GenerateSignature(); err != nil {
+		t.Errorf("error generating signature: %s", err.Error())
+	}
+
+	if _, err = rh.GenerateDelta(); err != nil {
+		t.Errorf("error generating delta: %s", err.Error())
+	}
+
+	if err = rh.Close(); err != nil {
+		t.Errorf("error closing rdiff: %s", err.Error())
+	}
+
+	if err = rh.Close(); err != nil {
+		t.Errorf("error closing rdiff twice: %s", err.Error())
+	}
+
+	for name, s := range map[string]*TrackingSource{"original": original, "updated": updated} {
+		if s.opened != 1 || s.closed != 1 {
+			t.Errorf("unexpected %s source lifecycle, opened %d, closed %d", name, s.opened, s.closed)
+		}
+	}
+}
+
+func TestNewReleasesSourcesOnError(t *testing.T) {
+	openErr := errors.New("open failed")
+	original := &TrackingSource{Data: "hello"}
+	updated := &TrackingSource{Err: openErr}
+
+	if _, err := getRDiffFromSources(original, updated); !errors.Is(err, openErr) {
+		t.Errorf("unexpected error, got %v, expected %v", err, openErr)
+	}
+
+	if original.opened != original.closed {
+		t.Errorf("original source leaked, opened %d, closed %d", original.opened, original.closed)
+	}
+}
+
+func countOpenFiles(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("cannot count open file descriptors: %s", err.Error())
+	}
+
+	return len(entries)
+}
+
+func TestFileDescriptorLeak(t *testing.T) {
+	dir := t.TempDir()
+	originalPath := filepath.Join(dir, "original")
+	updatedPath := filepath.Join(dir, "updated")
+
+	if err := os.WriteFile(originalPath, []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(updatedPath, []byte("heeello world"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	before := countOpenFiles(t)
+
+	for i := 0; i < 200; i++ {
+		rh, err := getRDiffFromSources(FileSource{FileName: originalPath}, FileSource{FileName: updatedPath})
+		if err != nil {
+			t.Fatalf("error creating rdiff %s", err.Error())
+		}
+
+		if _, err = rh.GenerateSignature(); err != nil {
+			t.Fatalf("error generating signature: %s", err.Error())
+		}
+
+		if _, err = rh.GenerateDelta(); err != nil {
+			t.Fatalf("error generating delta: %s", err.Error())
+		}
+
+		if err = rh.Close(); err != nil {
+			t.Fatalf("error closing rdiff: %s", err.Error())
+		}
+	}
+
+	// A missing updated file must not leak the already opened original.
+	for i := 0; i < 200; i++ {
+		_, err := getRDiffFromSources(FileSource{FileName: originalPath}, FileSource{FileName: filepath.Join(dir, "missing")})
+		if err == nil {
+			t.Fatal("expected error opening missing file")
+		}
+	}
+
+	if after := countOpenFiles(t); after != before {
+		t.Errorf("leaked file descriptors, got %d open, expected %d", after, before)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	original := strings.Repeat("hello world ", 20000)
+	updated := strings.Repeat("hello there ", 20000)
+
+	rh, err := getRDiff(original, updated)
+	if err != nil {
+		t.Fatalf("error creating rdiff %s", err.Error())
+	}
+	defer rh.Close()
+
+	var reports []Progress
+	rh.config.Progress = func(p Progress) {
+		reports = append(reports, p)
+	}
+
+	if _, err = rh.GenerateSignature(); err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	if _, err = rh.GenerateDelta(); err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	last := map[Stage]Progress{}
+	for _, p := range reports {
+		if prev, ok := last[p.Stage]; ok && p.Processed < prev.Processed {
+			t.Errorf("progress of %s went backwards, got %d after %d", p.Stage, p.Processed, prev.Processed)
+		}
+		last[p.Stage] = p
+	}
+
+	expected := map[Stage]int64{
+		StageSignature: int64(len(original)),
+		StageDelta:     int64(len(updated)),
+	}
+	for stage, size := range expected {
+		p, ok := last[stage]
+		if !ok {
+			t.Errorf("no progress reported for %s", stage)
+			continue
+		}
+
+		if p.Processed != size || p.Total != size {
+			t.Errorf("unexpected final progress of %s, got %d/%d, expected %d/%d", stage, p.Processed, p.Total, size, size)
+		}
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	rh, err := getRDiff("hello", "heeello")
+	if err != nil {
+		t.Fatalf("error creating rdiff %s", err.Error())
+	}
+	defer rh.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err = rh.GenerateSignatureContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected signature error, got %v, expected %v", err, context.Canceled)
+	}
+
+	if _, err = rh.GenerateDeltaContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected delta error, got %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestContextCanceledWhileRunning(t *testing.T) {
+	data := strings.Repeat("a", 10*progressInterval)
+
+	rh, err := getRDiff(data, data)
+	if err != nil {
+		t.Fatalf("error creating rdiff %s", err.Error())
+	}
+	defer rh.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var processed int64
+	rh.config.Progress = func(p Progress) {
+		processed = p.Processed
+		cancel()
+	}
+
+	if _, err = rh.GenerateSignatureContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected signature error, got %v, expected %v", err, context.Canceled)
+	}
+
+	if processed >= int64(len(data)) {
+		t.Errorf("signature was not interrupted, processed %d bytes", processed)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	source := StringSource{Data: "hello"}
+	tests := []struct {
+		name     string
+		config   Config
+		expected error
+	}{
+		{"valid", Config{ChunkSize: 2, OriginalSource: source, UpdatedSource: source}, nil},
+		{"zero chunk size", Config{ChunkSize: 0, OriginalSource: source, UpdatedSource: source}, ErrInvalidChunkSize},
+		{"negative chunk size", Config{ChunkSize: -1, OriginalSource: source, UpdatedSource: source}, ErrInvalidChunkSize},
+		{"missing original", Config{ChunkSize: 2, UpdatedSource: source}, ErrMissingSource},
+		{"missing updated", Config{ChunkSize: 2, OriginalSource: source}, ErrMissingSource},
+	}
+
+	for _, test := range tests {
+		err := test.config.Validate()
+		if !errors.Is(err, test.expected) || (err == nil) != (test.expected == nil) {
+			t.Errorf("%s: unexpected error, got %v, expected %v", test.name, err, test.expected)
+		}
+
+		if _, err = New(&test.config); !errors.Is(err, test.expected) {
+			t.Errorf("%s: unexpected error from New, got %v, expected %v", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestSourceOpenError(t *testing.T) {
+	_, err := getRDiffFromSources(StringSource{Data: "hello"}, FileSource{FileName: filepath.Join(t.TempDir(), "missing")})
+	if !errors.Is(err, ErrSourceOpen) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrSourceOpen)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("underlying error not preserved, got %v", err)
+	}
+
+	var sourceErr *SourceError
+	if !errors.As(err, &sourceErr) || sourceErr.Source != "updated" {
+		t.Errorf("unexpected source error %v", err)
+	}
+}
+
+type failingSource struct {
+	err error
+}
+
+func (s failingSource) GetReader() (io.ReadCloser, error) {
+	return io.NopCloser(io.MultiReader(strings.NewReader("hel"), &failingReader{err: s.err})), nil
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestCorruptInput(t *testing.T) {
+	readErr := errors.New("bad sector")
+
+	rh, err := getRDiffFromSources(failingSource{err: readErr}, StringSource{Data: "hello"})
+	if err != nil {
+		t.Fatalf("error creating rdiff %s", err.Error())
+	}
+	defer rh.Close()
+
+	if _, err = rh.GenerateSignature(); !errors.Is(err, ErrCorruptInput) || !errors.Is(err, readErr) {
+		t.Errorf("unexpected signature error, got %v, expected %v", err, ErrCorruptInput)
+	}
+
+	rh, err = getRDiffFromSources(StringSource{Data: "hello"}, failingSource{err: readErr})
+	if err != nil {
+		t.Fatalf("error creating rdiff %s", err.Error())
+	}
+	defer rh.Close()
+
+	if _, err = rh.GenerateDelta(); !errors.Is(err, ErrCorruptInput) || !errors.Is(err, readErr) {
+		t.Errorf("unexpected delta error, got %v, expected %v", err, ErrCorruptInput)
+	}
+}
+
+func TestSignerDiffer(t *testing.T) {
+	original := "hello"
+	updated := "heeello world"
+
+	rh, err := getRDiff(original, updated)
+	if err != nil {
+		t.Fatalf("error creating rdiff %s", err.Error())
+	}
+	defer rh.Close()
+
+	expectedS, err := rh.GenerateSignature()
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	expectedDelta, err := rh.GenerateDelta()
+	if err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	s, err := NewSigner(Options{ChunkSize: 2}).Sign(strings.NewReader(original))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	compareSignatures(s, expectedS, t)
+
+	differ := NewDiffer(s, Options{})
+	for i := 0; i < 2; i++ { // The differ must be reusable.
+		delta, err := differ.Diff(strings.NewReader(updated))
+		if err != nil {
+			t.Fatalf("error generating delta: %s", err.Error())
+		}
+
+		compareDeltas(delta, expectedDelta, t)
+	}
+}
+
+func TestSignerShortReads(t *testing.T) {
+	original := "hello world"
+
+	expectedS, err := NewSigner(Options{ChunkSize: 3}).Sign(strings.NewReader(original))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	s, err := NewSigner(Options{ChunkSize: 3}).Sign(iotest.OneByteReader(strings.NewReader(original)))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	compareSignatures(s, expectedS, t)
+}
+
+func TestSignHashes(t *testing.T) {
+	original := "hello world, hello"
+	updated := "hello there world, hello"
+	options := Options{ChunkSize: 3}
+
+	withWindows, err := NewSigner(options).Sign(strings.NewReader(original))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	s, err := NewSigner(options).SignHashes(context.Background(), strings.NewReader(original))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	if len(s.Chunks) != len(withWindows.Chunks) {
+		t.Fatalf("unexpected number of chunks, got %d, expected %d", len(s.Chunks), len(withWindows.Chunks))
+	}
+
+	for i, chunk := range s.Chunks {
+		if chunk.Window != nil {
+			t.Errorf("unexpected window of chunk %d, got %q, expected none", i, chunk.Window)
+		}
+
+		if chunk.Adler32 != withWindows.Chunks[i].Adler32 || !bytes.Equal(chunk.Strong, withWindows.Chunks[i].Strong) {
+			t.Errorf("unexpected hashes of chunk %d", i)
+		}
+	}
+
+	if s.Weak() {
+		t.Errorf("unexpected weak signature")
+	}
+
+	expectedDelta, err := NewDiffer(withWindows, options).Diff(strings.NewReader(updated))
+	if err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	delta, err := NewDiffer(s, options).Diff(strings.NewReader(updated))
+	if err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	compareDeltas(delta, expectedDelta, t)
+}
+
+func TestSignerInvalidOptions(t *testing.T) {
+	if _, err := NewSigner(Options{}).Sign(strings.NewReader("hello")); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidChunkSize)
+	}
+
+	if _, err := NewDiffer(Signature{}, Options{}).Diff(strings.NewReader("hello")); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidChunkSize)
+	}
+}
+
+func TestDeltaWithoutSignature(t *testing.T) {
+	expectedDelta := Delta{
+		Changes: []DeltaChunk{
+			{
+				ChunkIndex: 1,
+				NewBytes:   []byte{},
+				Position:   0,
+			},
+			{
+				ChunkIndex: 0,
+				NewBytes:   []byte{'o'},
+				Position:   2,
+			},
+		},
+		MissingChunks: []int{2},
+	}
+
+	rh, err := getRDiff("hello", "llohe")
+	if err != nil {
+		t.Fatalf("error creating rdiff %s", err.Error())
+	}
+	defer rh.Close()
+
+	delta, err := rh.GenerateDelta()
+	if err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	compareDeltas(delta, expectedDelta, t)
+}
+
+func TestDiffDoesNotModifySignature(t *testing.T) {
+	original := "hellllllo"
+
+	s, err := NewSigner(Options{ChunkSize: 2}).Sign(strings.NewReader(original))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	expectedS, err := NewSigner(Options{ChunkSize: 2}).Sign(strings.NewReader(original))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	for _, updated := range []string{"hellllo", "hellllllo", "ollleh", ""} {
+		if _, err = NewDiffer(s, Options{}).Diff(strings.NewReader(updated)); err != nil {
+			t.Fatalf("error generating delta: %s", err.Error())
+		}
+	}
+
+	compareSignatures(s, expectedS, t)
+}
+
+func TestConcurrentDiff(t *testing.T) {
+	original := strings.Repeat("the quick brown fox jumps over the lazy dog ", 50)
+	updated := []string{
+		original,
+		strings.Replace(original, "fox", "cat", -1),
+		strings.Replace(original, "lazy ", "", 3),
+		"prefix " + original + " suffix",
+		original[len(original)/2:] + original[:len(original)/2],
+		"",
+	}
+
+	s, err := NewSigner(Options{ChunkSize: 8}).Sign(strings.NewReader(original))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	differ := NewDiffer(s, Options{})
+
+	expected := make([]Delta, len(updated))
+	for i, u := range updated {
+		if expected[i], err = differ.Diff(strings.NewReader(u)); err != nil {
+			t.Fatalf("error generating delta: %s", err.Error())
+		}
+	}
+
+	const rounds = 20
+	deltas := make([]Delta, rounds*len(updated))
+	errs := make([]error, rounds*len(updated))
+
+	var wg sync.WaitGroup
+	for i := range deltas {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			deltas[i], errs[i] = differ.Diff(strings.NewReader(updated[i%len(updated)]))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range deltas {
+		if errs[i] != nil {
+			t.Fatalf("error generating delta: %s", errs[i].Error())
+		}
+
+		compareDeltas(deltas[i], expected[i%len(updated)], t)
+	}
+}
+
+var patchTests = []struct {
+	original string
+	updated  string
+}{
+	{"hello", "hello"},
+	{"hello", "heeello"},
+	{"hello", "llohe"},
+	{"hellllllo", "hellllo"},
+	{"hello", "heo"},
+	{"hello", "heeello world"},
+	{"hello", "hellx"},
+	{"he", "hexy"},
+	{"hello", ""},
+	{"", "hello"},
+	{"the quick brown fox", "the quick brown cat jumps"},
+	{"abcdefghijklmnopqrstuvwxyz", "zyxwvutsrqponmlkjihgfedcba"},
+}
+
+// apply returns the result of applying delta to original.
+func apply(t *testing.T, original string, delta Delta) string {
+	var patched bytes.Buffer
+	if err := Apply(strings.NewReader(original), delta, &patched); err != nil {
+		t.Fatalf("error applying delta: %s", err.Error())
+	}
+
+	return patched.String()
+}
+
+func TestApply(t *testing.T) {
+	for _, chunkSize := range []int{1, 2, 3, 5, 64} {
+		for _, test := range patchTests {
+			s, err := NewSigner(Options{ChunkSize: chunkSize}).Sign(strings.NewReader(test.original))
+			if err != nil {
+				t.Fatalf("error generating signature: %s", err.Error())
+			}
+
+			delta, err := NewDiffer(s, Options{}).Diff(strings.NewReader(test.updated))
+			if err != nil {
+				t.Fatalf("error generating delta: %s", err.Error())
+			}
+
+			if patched := apply(t, test.original, delta); patched != test.updated {
+				t.Errorf("chunk size %d, %q -> %q: unexpected patch result %q", chunkSize, test.original, test.updated, patched)
+			}
+		}
+	}
+}
+
+func TestApplyCorruptDelta(t *testing.T) {
+	delta := Delta{
+		ChunkSize:  2,
+		ChunkCount: 3,
+		Changes: []DeltaChunk{
+			{ChunkIndex: 5},
+		},
+	}
+
+	if err := Apply(strings.NewReader("hello"), delta, io.Discard); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrCorruptInput)
+	}
+
+	// Applying to a shorter original than the delta was generated for.
+	delta.Changes[0].ChunkIndex = 2
+	if err := Apply(strings.NewReader("he"), delta, io.Discard); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrCorruptInput)
+	}
+
+	if err := Apply(strings.NewReader("hello"), Delta{}, io.Discard); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidChunkSize)
+	}
+}
+
+func TestCheckBounds(t *testing.T) {
+	s, err := NewSigner(Options{ChunkSize: 2}).Sign(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	delta, err := NewDiffer(s, Options{}).Diff(strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	if err = delta.CheckBounds(5); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	for _, size := range []int64{0, 2} {
+		if err = delta.CheckBounds(size); !errors.Is(err, ErrCorruptInput) {
+			t.Errorf("size %d: unexpected error, got %v, expected %v", size, err, ErrCorruptInput)
+		}
+	}
+
+	parts := Delta{ChunkSize: 4, ChunkCount: 2, Changes: []DeltaChunk{{ChunkIndex: 1, Offset: 1, Length: 2}}}
+	if err = parts.CheckBounds(7); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err = parts.CheckBounds(6); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrCorruptInput)
+	}
+
+	parts.Changes[0].Offset = 4
+	if err = parts.CheckBounds(8); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrCorruptInput)
+	}
+
+	if err = (Delta{ChunkSize: MaxChunkSize + 1}).CheckBounds(8); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidChunkSize)
+	}
+}
+
+func TestInvert(t *testing.T) {
+	for _, chunkSize := range []int{1, 2, 3, 5, 64} {
+		for _, test := range patchTests {
+			s, err := NewSigner(Options{ChunkSize: chunkSize}).Sign(strings.NewReader(test.original))
+			if err != nil {
+				t.Fatalf("error generating signature: %s", err.Error())
+			}
+
+			delta, err := NewDiffer(s, Options{}).Diff(strings.NewReader(test.updated))
+			if err != nil {
+				t.Fatalf("error generating delta: %s", err.Error())
+			}
+
+			inverse, err := Invert(strings.NewReader(test.original), delta)
+			if err != nil {
+				t.Fatalf("error inverting delta: %s", err.Error())
+			}
+
+			updated := apply(t, test.original, delta)
+			if restored := apply(t, updated, inverse); restored != test.original {
+				t.Errorf("chunk size %d, %q -> %q: unexpected rollback result %q", chunkSize, test.original, test.updated, restored)
+			}
+		}
+	}
+}
+
+func TestInvertMismatchedOriginal(t *testing.T) {
+	s, err := NewSigner(Options{ChunkSize: 2}).Sign(strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	delta, err := NewDiffer(s, Options{}).Diff(strings.NewReader("world hello"))
+	if err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	if _, err = Invert(strings.NewReader("he"), delta); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrCorruptInput)
+	}
+}
+
+// generateDelta returns the delta from original to updated.
+func generateDelta(t *testing.T, original, updated string, chunkSize int) Delta {
+	s, err := NewSigner(Options{ChunkSize: chunkSize}).Sign(strings.NewReader(original))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	delta, err := NewDiffer(s, Options{}).Diff(strings.NewReader(updated))
+	if err != nil {
+		t.Fatalf("error generating delta: %s", err.Error())
+	}
+
+	return delta
+}
+
+func TestCompose(t *testing.T) {
+	for _, chunkSize := range []int{1, 2, 3, 5, 64} {
+		for _, test := range patchTests {
+			forward := generateDelta(t, test.original, test.updated, chunkSize)
+			backward := generateDelta(t, test.updated, test.original, chunkSize+1)
+
+			composed, err := Compose(forward, backward)
+			if err != nil {
+				t.Fatalf("error composing deltas: %s", err.Error())
+			}
+
+			if patched := apply(t, test.original, composed); patched != test.original {
+				t.Errorf("chunk size %d, %q -> %q -> %q: unexpected patch result %q",
+					chunkSize, test.original, test.updated, test.original, patched)
+			}
+		}
+	}
+}
+
+// mutate returns data with a random insertion, deletion, duplication, move or
+// truncation.
+func mutate(r *rand.Rand, data []byte) []byte {
+	at := r.Intn(len(data) + 1)
+	switch r.Intn(5) {
+	case 0:
+		inserted := make([]byte, r.Intn(32))
+		r.Read(inserted)
+
+		return append(append(append([]byte{}, data[:at]...), inserted...), data[at:]...)
+	case 1:
+		end := at + r.Intn(len(data)-at+1)
+
+		return append(append([]byte{}, data[:at]...), data[end:]...)
+	case 2:
+		from := r.Intn(len(data) + 1)
+		end := from + r.Intn(len(data)-from+1)
+
+		return append(append(append([]byte{}, data[:at]...), data[from:end]...), data[at:]...)
+	case 3:
+		// Move the range at up to end behind to.
+		end := at + r.Intn(len(data)-at+1)
+		rest := append(append([]byte{}, data[:at]...), data[end:]...)
+		to := r.Intn(len(rest) + 1)
+
+		return append(append(append([]byte{}, rest[:to]...), data[at:end]...), rest[to:]...)
+	default:
+		return append([]byte{}, data[:at]...)
+	}
+}
+
+func TestComposeChain(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		version := make([]byte, r.Intn(512))
+		r.Read(version)
+		versions := []string{string(version)}
+
+		for j := 1 + r.Intn(5); j > 0; j-- {
+			for k := r.Intn(4); k >= 0; k-- {
+				version = mutate(r, version)
+			}
+
+			versions = append(versions, string(version))
+		}
+
+		var composed Delta
+		for j := 1; j < len(versions); j++ {
+			delta := generateDelta(t, versions[j-1], versions[j], 1+r.Intn(16))
+			if j == 1 {
+				composed = delta
+				continue
+			}
+
+			var err error
+			if composed, err = Compose(composed, delta); err != nil {
+				t.Fatalf("chain %d: error composing delta %d: %s", i, j, err.Error())
+			}
+		}
+
+		original, updated := versions[0], versions[len(versions)-1]
+		if patched := apply(t, original, composed); patched != updated {
+			t.Fatalf("chain %d: unexpected patch result of %d deltas", i, len(versions)-1)
+		}
+
+		var buf bytes.Buffer
+		if _, err := composed.WriteTo(&buf); err != nil {
+			t.Fatalf("chain %d: error encoding delta: %s", i, err.Error())
+		}
+
+		decoded, err := ReadDelta(&buf)
+		if err != nil {
+			t.Fatalf("chain %d: error decoding delta: %s", i, err.Error())
+		}
+
+		compareDeltas(decoded, composed, t)
+	}
+}
+
+func TestComposeMismatched(t *testing.T) {
+	first := generateDelta(t, "hello", "hello world", 2)
+	second := generateDelta(t, "hello", "world", 2)
+
+	if _, err := Compose(first, second); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrCorruptInput)
+	}
+
+	second.ChunkSize = 0
+	if _, err := Compose(first, second); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidChunkSize)
+	}
+}
+
+func TestApplyParts(t *testing.T) {
+	delta := Delta{
+		ChunkSize:  4,
+		ChunkCount: 3,
+		Changes: []DeltaChunk{
+			{ChunkIndex: 1, Offset: 1, Length: 2},
+			{ChunkIndex: 0, NewBytes: []byte("-"), Offset: 2},
+			{ChunkIndex: 2, Offset: 1},
+		},
+	}
+
+	if patched := apply(t, "abcdefghij", delta); patched != "fg-cdj" {
+		t.Errorf("unexpected patch result, got %q, expected %q", patched, "fg-cdj")
+	}
+
+	delta.Changes[0].Length = 4
+	if err := Apply(strings.NewReader("abcdefghij"), delta, io.Discard); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrCorruptInput)
+	}
+
+	// The last chunk only has two bytes.
+	delta.Changes[0].Length = 0
+	delta.Changes[2].Offset = 2
+	if err := Apply(strings.NewReader("abcdefghij"), delta, io.Discard); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrCorruptInput)
+	}
+}
+
+func sign(t *testing.T, data string, chunkSize int) Signature {
+	s, err := NewSigner(Options{ChunkSize: chunkSize}).Sign(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("error generating signature: %s", err.Error())
+	}
+
+	return s
+}
+
+func TestUpdateSignature(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		chunkSize := 1 + r.Intn(16)
+		original := make([]byte, r.Intn(512))
+		r.Read(original)
+
+		updated := original
+		for k := r.Intn(4); k >= 0; k-- {
+			updated = mutate(r, updated)
+		}
+
+		signature := sign(t, string(original), chunkSize)
+		delta, err := NewDiffer(signature, Options{}).Diff(bytes.NewReader(updated))
+		if err != nil {
+			t.Fatalf("%d: error generating delta: %s", i, err.Error())
+		}
+
+		got, err := UpdateSignature(signature, delta)
+		if err != nil {
+			t.Fatalf("%d: error updating signature: %s", i, err.Error())
+		}
+
+		compareSignatures(got, sign(t, apply(t, string(original), delta), chunkSize), t)
+	}
+}
+
+func TestUpdateSignatureParts(t *testing.T) {
+	delta := Delta{
+		ChunkSize:  4,
+		ChunkCount: 3,
+		Changes: []DeltaChunk{
+			{ChunkIndex: 1, Offset: 1, Length: 2},
+			{ChunkIndex: 0, NewBytes: []byte("-"), Offset: 2},
+			{ChunkIndex: 0, NewBytes: []byte("xyz")},
+			{ChunkIndex: 2, Offset: 1},
+		},
+	}
+
+	signature := sign(t, "abcdefghij", 4)
+	got, err := UpdateSignature(signature, delta)
+	if err != nil {
+		t.Fatalf("error updating signature: %s", err.Error())
+	}
+
+	compareSignatures(got, sign(t, apply(t, "abcdefghij", delta), 4), t)
+}
+
+func TestUpdateSignatureWithoutWindow(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := sign(t, "abcdefghij", 4).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := ReadSignature(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Whole chunks at chunk boundaries only need their hashes, including the
+	// short last chunk at the end.
+	aligned := Delta{
+		ChunkSize:  4,
+		ChunkCount: 3,
+		Changes: []DeltaChunk{
+			{ChunkIndex: 1},
+			{ChunkIndex: 3, NewBytes: []byte("wxyz")},
+			{ChunkIndex: 0},
+			{ChunkIndex: 2},
+		},
+	}
+
+	got, err := UpdateSignature(signature, aligned)
+	if err != nil {
+		t.Fatalf("error updating signature: %s", err.Error())
+	}
+
+	// Only the literal chunk is hashed again and has data.
+	expected := sign(t, "efghwxyzabcdij", 4)
+	for _, i := range []int{0, 2, 3} {
+		expected.Chunks[i].Window = nil
+	}
+	compareSignatures(got, expected, t)
+
+	unaligned := aligned
+	unaligned.Changes = []DeltaChunk{{ChunkIndex: 0, NewBytes: []byte("x")}}
+	if _, err = UpdateSignature(signature, unaligned); !errors.Is(err, ErrMissingSource) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrMissingSource)
+	}
+
+	if _, err = UpdateSignature(sign(t, "abc", 4), aligned); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrCorruptInput)
+	}
+}
+
+// digest returns the Digest of data.
+func digest(data string) *Digest {
+	return &Digest{Size: int64(len(data)), SHA256: sha256.Sum256([]byte(data))}
+}
+
+func TestDigests(t *testing.T) {
+	original, updated := "hello world", "hello there world"
+	delta := generateDelta(t, original, updated, 3)
+
+	if delta.Original == nil || *delta.Original != *digest(original) {
+		t.Errorf("unexpected original digest, got %v, expected %v", delta.Original, digest(original))
+	}
+
+	if delta.Updated == nil || *delta.Updated != *digest(updated) {
+		t.Errorf("unexpected updated digest, got %v, expected %v", delta.Updated, digest(updated))
+	}
+
+	if err := VerifyOriginal(context.Background(), strings.NewReader(original), delta); err != nil {
+		t.Errorf("unexpected error verifying the original: %v", err)
+	}
+
+	for _, wrong := range []string{"", "hello", "hello World", original + "!"} {
+		if err := VerifyOriginal(context.Background(), strings.NewReader(wrong), delta); !errors.Is(err, ErrVerificationFailed) {
+			t.Errorf("%q: unexpected error, got %v, expected %v", wrong, err, ErrVerificationFailed)
+		}
+	}
+
+	// Without the digests nothing can be checked.
+	unverified := delta
+	unverified.Original, unverified.Updated = nil, nil
+	if err := VerifyOriginal(context.Background(), strings.NewReader("hello"), unverified); err != nil {
+		t.Errorf("unexpected error verifying without digest: %v", err)
+	}
+
+	inverse, err := Invert(strings.NewReader(original), delta)
+	if err != nil {
+		t.Fatalf("error inverting delta: %s", err.Error())
+	}
+
+	if *inverse.Original != *delta.Updated || *inverse.Updated != *delta.Original {
+		t.Errorf("unexpected inverse digests, got %v and %v", inverse.Original, inverse.Updated)
+	}
+
+	composed, err := Compose(delta, generateDelta(t, updated, "world", 3))
+	if err != nil {
+		t.Fatalf("error composing deltas: %s", err.Error())
+	}
+
+	if *composed.Original != *digest(original) || *composed.Updated != *digest("world") {
+		t.Errorf("unexpected composed digests, got %v and %v", composed.Original, composed.Updated)
+	}
+
+	// Deltas of other files with the same chunk layout do not compose.
+	if _, err = Compose(delta, generateDelta(t, "hello THERE world", "world", 3)); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrCorruptInput)
+	}
+
+	s := sign(t, original, 3)
+	updatedSignature, err := UpdateSignature(s, delta)
+	if err != nil {
+		t.Fatalf("error updating signature: %s", err.Error())
+	}
+
+	if *updatedSignature.Digest != *digest(updated) {
+		t.Errorf("unexpected updated signature digest, got %v, expected %v", updatedSignature.Digest, digest(updated))
+	}
+
+	if _, err = UpdateSignature(sign(t, "hello World", 3), delta); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrCorruptInput)
+	}
+}
+
+func TestApplyVerifiesResult(t *testing.T) {
+	original := "hello world"
+	delta := generateDelta(t, original, "hello there world", 3)
+
+	// A tampered literal, or an original with the same size but other content
+	// that is not checked before, result in a different file.
+	tampered := delta
+	tampered.Changes = append([]DeltaChunk(nil), delta.Changes...)
+	for i, change := range tampered.Changes {
+		if len(change.NewBytes) > 0 {
+			tampered.Changes[i].NewBytes = bytes.ToUpper(change.NewBytes)
+			break
+		}
+	}
+
+	tests := map[string]struct {
+		original string
+		delta    Delta
+	}{
+		"tampered delta":  {original, tampered},
+		"mismatched file": {"HELLO WORLD", delta},
+		"mismatched tail": {"hello worlD", delta},
+	}
+
+	for name, test := range tests {
+		if err := Apply(strings.NewReader(test.original), test.delta, io.Discard); !errors.Is(err, ErrVerificationFailed) {
+			t.Errorf("%s: unexpected error, got %v, expected %v", name, err, ErrVerificationFailed)
+		}
+	}
+}
+
+func signTree(t *testing.T, data []byte, chunkSize int) *TreeSignature {
+	tree, err := NewSigner(Options{ChunkSize: chunkSize}).SignTree(context.Background(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error generating tree signature: %s", err.Error())
+	}
+
+	return tree
+}
+
+func TestTreeSignature(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		chunkSize := 1 + r.Intn(16)
+		a := make([]byte, r.Intn(1024))
+		r.Read(a)
+
+		// Change some bytes in place and maybe change the size.
+		b := append([]byte{}, a...)
+		for k := r.Intn(4); k > 0 && len(b) > 0; k-- {
+			b[r.Intn(len(b))]++
+		}
+
+		switch r.Intn(3) {
+		case 0:
+			b = b[:r.Intn(len(b)+1)]
+		case 1:
+			extra := make([]byte, r.Intn(64))
+			r.Read(extra)
+			b = append(b, extra...)
+		}
+
+		treeA, treeB := signTree(t, a, chunkSize), signTree(t, b, chunkSize)
+		if treeB.Size != int64(len(b)) || treeB.Leaves() != (len(b)+chunkSize-1)/chunkSize {
+			t.Fatalf("%d: unexpected tree of %d leaves for %d bytes", i, treeB.Leaves(), treeB.Size)
+		}
+
+		// Leaves of b that differ from a, compared one by one.
+		var expected []int
+		for leaf := 0; leaf*chunkSize < len(b); leaf++ {
+			end := (leaf + 1) * chunkSize
+			if end > len(b) {
+				end = len(b)
+			}
+
+			if end > len(a) || !bytes.Equal(a[leaf*chunkSize:end], b[leaf*chunkSize:end]) ||
+				(end == len(b) && end < len(a) && end-leaf*chunkSize < chunkSize) {
+				expected = append(expected, leaf)
+			}
+		}
+
+		got := DiffTrees(treeB, treeA)
+		if len(got) != len(expected) {
+			t.Fatalf("%d: unexpected differing leaves, got %v, expected %v", i, got, expected)
+		}
+
+		for j := range got {
+			if got[j] != expected[j] {
+				t.Fatalf("%d: unexpected differing leaves, got %v, expected %v", i, got, expected)
+			}
+		}
+
+		rootA, _ := treeA.Node(treeA.Height(), 0)
+		rootB, _ := treeB.Node(treeB.Height(), 0)
+		if bytes.Equal(a, b) != (rootA == rootB) {
+			t.Errorf("%d: unexpected root, equal files must have equal roots and only those", i)
+		}
+	}
+}
+
+func TestTreeSignatureLocalizes(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	tree := signTree(t, data, 64)
+
+	data[500000]++
+	changed := signTree(t, data, 64)
+
+	// Walk down like DiffTrees and count the compared nodes.
+	compared := 0
+	indexes := []int{0}
+	for level := tree.Height(); level >= 0 && len(indexes) > 0; level-- {
+		compared += len(indexes)
+
+		hashes := make([]TreeHash, len(indexes))
+		present := make([]bool, len(indexes))
+		for j, i := range indexes {
+			hashes[j], present[j] = tree.Node(level, i)
+		}
+
+		indexes = changed.DiffLevel(level, indexes, hashes, present)
+	}
+
+	if len(indexes) != 1 || indexes[0] != 500000/64 {
+		t.Errorf("unexpected differing leaves, got %v, expected %v", indexes, []int{500000 / 64})
+	}
+
+	if maximum := 2*tree.Height() + 1; compared > maximum {
+		t.Errorf("unexpected number of compared nodes, got %d, expected at most %d", compared, maximum)
+	}
+}
+
+// benchmarkFiles returns an original file of size bytes and an updated file
+// with a few changes spread over it.
+func benchmarkFiles(size int) ([]byte, []byte) {
+	r := rand.New(rand.NewSource(1))
+	original := make([]byte, size)
+	r.Read(original)
+
+	updated := append([]byte{}, original...)
+	for i := 0; i < 16; i++ {
+		at := r.Intn(len(updated))
+		inserted := make([]byte, 1+r.Intn(100))
+		r.Read(inserted)
+		updated = append(updated[:at], append(inserted, updated[at:]...)...)
+	}
+
+	return original, updated
+}
+
+func BenchmarkDiff(b *testing.B) {
+	original, updated := benchmarkFiles(16 << 20)
+
+	for _, chunkSize := range []int{16, 256, 1024, 4096} {
+		b.Run(fmt.Sprintf("chunk=%d", chunkSize), func(b *testing.B) {
+			signature, err := NewSigner(Options{ChunkSize: chunkSize}).Sign(bytes.NewReader(original))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			differ := NewDiffer(signature, Options{})
+			b.SetBytes(int64(len(updated)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err = differ.Diff(bytes.NewReader(updated)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDiffUnmatched diffs a file that has nothing in common with the
+// original, so every byte is rolled.
+func BenchmarkDiffUnmatched(b *testing.B) {
+	original, _ := benchmarkFiles(1 << 20)
+	_, updated := benchmarkFiles(16 << 20)
+
+	for _, chunkSize := range []int{16, 1024} {
+		b.Run(fmt.Sprintf("chunk=%d", chunkSize), func(b *testing.B) {
+			signature, err := NewSigner(Options{ChunkSize: chunkSize}).Sign(bytes.NewReader(original))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			differ := NewDiffer(signature, Options{})
+			b.SetBytes(int64(len(updated)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err = differ.Diff(bytes.NewReader(updated)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,60 +1,237 @@
+// Package rhash implements a rolling adler32 hash.
 package rhash
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash"
 )
 
 const (
 	moduloPrime = 65521
+
+	// nmax is the largest n such that 255n(n+1)/2 + (n+1)(moduloPrime-1) fits
+	// into a uint32, so that n bytes can be added before reducing, like in
+	// hash/adler32.
+	nmax = 5552
 )
 
 // RollingHash represents a rolling hash computation using the adler32
-// algorithm.
+// algorithm, over a window of up to a fixed number of bytes. Its hash is the
+// adler32 checksum of the bytes in the window, as computed by hash/adler32.
+// The window is kept in a ring buffer that is allocated once, so updating and
+// rolling never allocate, no matter how far the hash rolls. Only Write grows
+// it, for data that does not fit.
 type RollingHash struct {
-	a, b   uint32
-	Size   int
-	Window []byte
+	a, b uint32
+	size int
+
+	// ring holds the window, starting at head and wrapping around. It has
+	// one spare byte, so that RollIn can add a byte before removing one.
+	ring []byte
+	head int
+}
+
+var _ hash.Hash32 = (*RollingHash)(nil)
+
+// New returns a rolling hash with a window of up to capacity bytes.
+func New(capacity int) *RollingHash {
+	return &RollingHash{a: 1, b: 0, size: 0, ring: make([]byte, capacity+1)}
+}
+
+// Cap returns the size of a full window, see RollIn.
+func (r *RollingHash) Cap() int {
+	return len(r.ring) - 1
 }
 
-func New() *RollingHash {
-	return &RollingHash{a: 1, b: 0, Size: 0}
+// Len returns the size of the window.
+func (r *RollingHash) Len() int {
+	return r.size
 }
 
-// Update updates the rolling hash with the next byte.
+// Update updates the rolling hash with the next byte. The window must not be
+// full, use RollIn to move a full window.
 func (r *RollingHash) Update(b byte) {
+	if r.size == r.Cap() {
+		panic("rhash: update of a full window")
+	}
+
+	r.update(b)
+}
+
+func (r *RollingHash) update(b byte) {
 	r.a = (r.a + uint32(b)) % moduloPrime
 	r.b = (r.b + r.a) % moduloPrime
 
-	r.Window = append(r.Window, b)
-	r.Size++
+	r.ring[r.index(r.size)] = b
+	r.size++
+}
+
+// Write appends p to the window, like writing to hash/adler32 appends to the
+// checksummed data. If p does not fit, the window grows beyond its capacity,
+// use Roll or RollIn to move it instead. It never returns an error.
+func (r *RollingHash) Write(p []byte) (int, error) {
+	if r.size+len(p) > r.Cap() {
+		r.grow(r.size + len(p))
+	}
+
+	r.add(p)
+
+	// Copy p into the ring, in two parts if it wraps around.
+	end := r.index(r.size)
+	copied := copy(r.ring[end:], p)
+	copy(r.ring, p[copied:])
+	r.size += len(p)
+
+	return len(p), nil
+}
+
+// grow replaces the ring with one for a window of at least n bytes, at least
+// doubling its capacity so that many small writes do not copy it every time.
+func (r *RollingHash) grow(n int) {
+	if double := 2 * r.Cap(); double > n {
+		n = double
+	}
+
+	ring := make([]byte, n+1)
+	r.AppendWindow(ring[:0])
+	r.ring, r.head = ring, 0
+}
+
+// add adds p to a and b, reducing them only every nmax bytes.
+func (r *RollingHash) add(p []byte) {
+	a, b := r.a, r.b
+	for len(p) > 0 {
+		block := p
+		if len(block) > nmax {
+			block = block[:nmax]
+		}
+
+		for _, c := range block {
+			a += uint32(c)
+			b += a
+		}
+
+		a %= moduloPrime
+		b %= moduloPrime
+
+		p = p[len(block):]
+	}
+
+	r.a, r.b = a, b
 }
 
 // Roll removes the first byte from the rolling hash.
 func (r *RollingHash) Roll() (byte, error) {
-	if len(r.Window) == 0 {
+	if r.size == 0 {
 		return 0, fmt.Errorf("nothing to roll out") // Nothing to roll out
 	}
 
-	old := r.Window[0]
+	old := r.ring[r.head]
 
+	// Every byte of the window adds 1 to a and its own value to every a that
+	// b sums up from then on: removing it from a window of n bytes takes it
+	// from a once and from b n times, plus the initial 1 of the first a.
 	r.a = (r.a + moduloPrime - uint32(old)) % moduloPrime
-	r.b = (r.b + (uint32(len(r.Window))*uint32(old))/moduloPrime - (uint32(len(r.Window)) * uint32(old)) - 1) % moduloPrime
+	r.b = (r.b + moduloPrime - (uint32(r.size%moduloPrime)*uint32(old)+1)%moduloPrime) % moduloPrime
 
-	r.Window = r.Window[1:]
-	r.Size--
+	r.head = r.index(1)
+	r.size--
 
 	return old, nil
 }
 
-// Returns the current hash value.
-func (r *RollingHash) Sum() uint32 {
+// RollIn appends b to a full window and removes its first byte, which is
+// returned.
+func (r *RollingHash) RollIn(b byte) byte {
+	if r.size != r.Cap() {
+		panic("rhash: roll in of a window that is not full")
+	}
+
+	r.update(b)
+	old, _ := r.Roll()
+
+	return old
+}
+
+// Window returns the bytes of the current window without copying them. The
+// window starts with head and continues with tail, which is empty unless the
+// window wraps around the end of the ring buffer. Both are only valid until the
+// next change of the rolling hash.
+func (r *RollingHash) Window() (head, tail []byte) {
+	end := r.head + r.size
+	if end <= len(r.ring) {
+		return r.ring[r.head:end], nil
+	}
+
+	return r.ring[r.head:], r.ring[:end-len(r.ring)]
+}
+
+// AppendWindow appends the bytes of the current window to dst.
+func (r *RollingHash) AppendWindow(dst []byte) []byte {
+	head, tail := r.Window()
+
+	return append(append(dst, head...), tail...)
+}
+
+// index returns the position of the i-th byte of the window in the ring.
+func (r *RollingHash) index(i int) int {
+	i += r.head
+	if i >= len(r.ring) {
+		i -= len(r.ring)
+	}
+
+	return i
+}
+
+// Sum32 returns the current hash value.
+func (r *RollingHash) Sum32() uint32 {
 	return (r.b << 16) | r.a
 }
 
+// Sum appends the current hash value to b in big-endian byte order, like
+// hash/adler32.
+func (r *RollingHash) Sum(b []byte) []byte {
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], r.Sum32())
+
+	return append(b, sum[:]...)
+}
+
+// Size returns the number of bytes Sum appends.
+func (r *RollingHash) Size() int {
+	return 4
+}
+
+// BlockSize returns the block size of the hash, like hash/adler32.
+func (r *RollingHash) BlockSize() int {
+	return 4
+}
+
 // Resets the rolling hash calculations.
 func (r *RollingHash) Reset() {
 	r.a = 1
 	r.b = 0
-	r.Size = 0
-	r.Window = []byte{}
+	r.size = 0
+	r.head = 0
+}
+
+// Checksum returns the adler32 checksum of data, like hash/adler32.Checksum.
+func Checksum(data []byte) uint32 {
+	r := RollingHash{a: 1}
+	r.add(data)
+
+	return r.Sum32()
+}
+
+// RollSum returns the checksum of a window of n bytes after out is rolled out
+// of its front and in is added to its end, given the checksum of the window
+// before. It is the same as RollIn, for windows that are kept elsewhere.
+func RollSum(sum uint32, n int, out, in byte) uint32 {
+	a, b := sum&0xffff, sum>>16
+
+	a = (a + moduloPrime - uint32(out) + uint32(in)) % moduloPrime
+	b = (b + moduloPrime - (uint32(n%moduloPrime)*uint32(out)+1)%moduloPrime + a) % moduloPrime
+
+	return b<<16 | a
 }
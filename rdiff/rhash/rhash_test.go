@@ -1,6 +1,11 @@
 package rhash
 
 import (
+	"bytes"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"math/rand"
 	"testing"
 )
 
@@ -9,13 +14,13 @@ func TestUpdate(t *testing.T) {
 	wiki := []byte("Wikipedia")
 	expectedHash := uint32(300286872)
 
-	rh := New()
+	rh := New(len(wiki))
 
 	for _, b := range wiki {
 		rh.Update(b)
 	}
 
-	hash := rh.Sum()
+	hash := rh.Sum32()
 	if expectedHash != hash {
 		t.Errorf("calculated hash different than expected, got %d, expected %d", hash, expectedHash)
 	}
@@ -24,20 +29,20 @@ func TestUpdate(t *testing.T) {
 func TestReset(t *testing.T) {
 	helloThere := []byte("Hello Thy World")
 
-	rh := New()
+	rh := New(len(helloThere))
 
 	for _, b := range helloThere {
 		rh.Update(b)
 	}
 
-	if rh.Sum() == 1 {
+	if rh.Sum32() == 1 {
 		t.Errorf("hash not calculated")
 	}
 
 	rh.Reset()
 
-	sum := rh.Sum()
-	if rh.Sum() != 1 {
+	sum := rh.Sum32()
+	if rh.Sum32() != 1 {
 		t.Errorf("hash was not reset, got sum %d", sum)
 	}
 }
@@ -46,8 +51,8 @@ func TestRoll(t *testing.T) {
 	helloWorld := []byte("Hello World")
 	world := []byte("World")
 
-	rhHelloWorld := New()
-	rhWorld := New()
+	rhHelloWorld := New(len(helloWorld))
+	rhWorld := New(len(world))
 
 	for _, b := range helloWorld {
 		rhHelloWorld.Update(b)
@@ -68,10 +73,219 @@ func TestRoll(t *testing.T) {
 		}
 	}
 
-	rolledHash := rhHelloWorld.Sum()
-	worldHash := rhWorld.Sum()
+	rolledHash := rhHelloWorld.Sum32()
+	worldHash := rhWorld.Sum32()
 
 	if rolledHash != worldHash {
 		t.Errorf("rolled hash different than non rolled hash, rolledHash=%d, nonRolledHash=%d", rolledHash, worldHash)
 	}
 }
+
+func TestWindow(t *testing.T) {
+	data := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	rh := New(7)
+	for i, b := range data {
+		if rh.Len() < rh.Cap() {
+			rh.Update(b)
+		} else if old := rh.RollIn(b); old != data[i-7] {
+			t.Fatalf("unexpected rolled out byte, got %d, expected %d", old, data[i-7])
+		}
+
+		start := i + 1 - rh.Len()
+		if window := rh.AppendWindow(nil); !bytes.Equal(window, data[start:i+1]) {
+			t.Fatalf("unexpected window at %d, got %v, expected %v", i, window, data[start:i+1])
+		}
+	}
+
+	// Rolling out bytes of a wrapped window keeps it intact.
+	for rh.Len() > 0 {
+		if _, err := rh.Roll(); err != nil {
+			t.Fatal(err)
+		}
+
+		if window := rh.AppendWindow(nil); !bytes.Equal(window, data[len(data)-rh.Len():]) {
+			t.Fatalf("unexpected window, got %v, expected %v", window, data[len(data)-rh.Len():])
+		}
+	}
+
+	if _, err := rh.Roll(); err == nil {
+		t.Errorf("unexpected roll of an empty window")
+	}
+}
+
+func TestAdler32Rolled(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for _, size := range []int{1, 2, 15, 16, 17, 100, 1024, 5552, 10000} {
+		data := make([]byte, 3*size+1000)
+		r.Read(data)
+
+		// Long runs of high bytes push a and b towards their maximum.
+		if size%2 == 0 {
+			for i := range data[:len(data)/2] {
+				data[i] = 0xff
+			}
+		}
+
+		rh := New(size)
+		for i, b := range data {
+			if rh.Len() < rh.Cap() {
+				rh.Update(b)
+			} else {
+				rh.RollIn(b)
+			}
+
+			start := i + 1 - rh.Len()
+			if expected := adler32.Checksum(data[start : i+1]); rh.Sum32() != expected {
+				t.Fatalf("window %d at %d: unexpected hash, got %d, expected %d", size, i, rh.Sum32(), expected)
+			}
+		}
+
+		// Rolling out down to an empty window.
+		for rh.Len() > 0 {
+			if _, err := rh.Roll(); err != nil {
+				t.Fatal(err)
+			}
+
+			if expected := adler32.Checksum(data[len(data)-rh.Len():]); rh.Sum32() != expected {
+				t.Fatalf("window %d: unexpected hash of the last %d bytes, got %d, expected %d", size, rh.Len(), rh.Sum32(), expected)
+			}
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	data := make([]byte, 50000)
+	r.Read(data)
+
+	for _, size := range []int{1, 7, 64, 6000, 60000} {
+		// Writes append like to hash/adler32, also beyond the capacity.
+		rh := New(size)
+		var reference hash.Hash32 = adler32.New()
+		start, written := 0, 0
+		for written < len(data) {
+			n := r.Intn(2 * size)
+			if n > len(data)-written {
+				n = len(data) - written
+			}
+
+			if m, err := rh.Write(data[written : written+n]); m != n || err != nil {
+				t.Fatalf("unexpected write result %d, %v", m, err)
+			}
+			reference.Write(data[written : written+n])
+			written += n
+
+			if rh.Sum32() != reference.Sum32() || !bytes.Equal(rh.Sum(nil), reference.Sum(nil)) {
+				t.Fatalf("window %d after %d bytes: unexpected hash, got %d, expected %d", size, written, rh.Sum32(), reference.Sum32())
+			}
+
+			if !bytes.Equal(rh.AppendWindow(nil), data[start:written]) {
+				t.Fatalf("window %d after %d bytes: unexpected window", size, written)
+			}
+		}
+
+		if rh.Size() != reference.Size() || rh.BlockSize() != reference.BlockSize() {
+			t.Errorf("unexpected sizes, got %d and %d, expected %d and %d", rh.Size(), rh.BlockSize(), reference.Size(), reference.BlockSize())
+		}
+
+		// Rolling moves a window that was written in bulk.
+		rh.Reset()
+		for written = 0; written < len(data); {
+			n := r.Intn(2 * size)
+			if n > len(data)-written {
+				n = len(data) - written
+			}
+
+			_, _ = rh.Write(data[written : written+n])
+			written += n
+
+			for rh.Len() > size {
+				if _, err := rh.Roll(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			start = written - rh.Len()
+			if expected := adler32.Checksum(data[start:written]); rh.Sum32() != expected {
+				t.Fatalf("window %d after %d bytes: unexpected rolled hash, got %d, expected %d", size, written, rh.Sum32(), expected)
+			}
+
+			if !bytes.Equal(rh.AppendWindow(nil), data[start:written]) {
+				t.Fatalf("window %d after %d bytes: unexpected rolled window", size, written)
+			}
+		}
+	}
+}
+
+func TestRollSum(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for _, size := range []int{1, 16, 1024, 5552, 10000} {
+		data := make([]byte, 2*size+1000)
+		r.Read(data)
+		for i := range data[:len(data)/3] {
+			data[i] = 0xff
+		}
+
+		sum := Checksum(data[:size])
+		for start := 0; ; start++ {
+			if expected := adler32.Checksum(data[start : start+size]); sum != expected {
+				t.Fatalf("window %d at %d: unexpected hash, got %d, expected %d", size, start, sum, expected)
+			}
+
+			if start+size == len(data) {
+				break
+			}
+
+			sum = RollSum(sum, size, data[start], data[start+size])
+		}
+	}
+}
+
+func TestRollInDoesNotAllocate(t *testing.T) {
+	rh := New(64)
+	for i := 0; i < 64; i++ {
+		rh.Update(byte(i))
+	}
+
+	b := byte(0)
+	allocs := testing.AllocsPerRun(1000, func() {
+		b++
+		rh.RollIn(b)
+		rh.Window()
+		rh.Sum32()
+	})
+
+	if allocs != 0 {
+		t.Errorf("unexpected allocations per roll, got %f, expected 0", allocs)
+	}
+}
+
+func BenchmarkRollIn(b *testing.B) {
+	data := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for _, size := range []int{16, 1024, 64 << 10} {
+		b.Run(fmt.Sprintf("window=%d", size), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+
+			rh := New(size)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				rh.Reset()
+				for _, c := range data[:size] {
+					rh.Update(c)
+				}
+
+				for _, c := range data[size:] {
+					rh.RollIn(c)
+				}
+			}
+		})
+	}
+}
@@ -1,31 +1,65 @@
 package rdiff
 
-import "github.com/sol1du2/rdetective/rdiff/rhash"
-
-// SignatureChunk represents a part of a file, along with its hashed value.
-// Note(Sol1du2): For real use we should also use a strong hashing like sha1 to
-// properly take care of collisions. And the weaker alder32 just for efficient
-// lookups. However, for exercise purposes and to simplify the code we are only
-// using adler32 here. Adding a stronger hash to the algorithm should be fairly
-// trivial.
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sol1du2/rdetective/rdiff/rhash"
+)
+
+// StrongSize is the size of the strong hash of a chunk, the first bytes of its
+// sha256.
+const StrongSize = 16
+
+// SignatureChunk represents a part of a file, along with its hashed values.
+// Adler32 is weak but cheap to roll, it is used to find candidate chunks.
+// Strong confirms that a candidate really has the same data, it is nil for
+// signatures of older versions. Window is the data of the chunk, it is only
+// known to the side that generated the signature and is never serialized.
 type SignatureChunk struct {
-	Adler32 uint32
-	Window  []byte
+	Adler32 uint32 `json:"adler32"`
+	Strong  []byte `json:"strong,omitempty"`
+	Window  []byte `json:"-"`
 }
 
 // Signature represents a file consisting of several chunks.
+// ChunkSize is the size of every chunk, except possibly the last one.
 // indexMap represents the index (position) of each chunk with the hash value as
 // the key. This is to help find matching chunks.
+// Digest is the Digest of the whole file, if it is known. Differs pass it on
+// to their deltas.
+// A Signature must not be modified with AddChunk while Differs are using it.
 type Signature struct {
-	Chunks   []SignatureChunk
-	indexMap map[uint32][]int
+	ChunkSize int              `json:"chunk_size"`
+	Chunks    []SignatureChunk `json:"chunks"`
+	Digest    *Digest          `json:"digest,omitempty"`
+	indexMap  map[uint32][]int
 }
 
+// AddChunk adds chunk to the signature, keeping a copy of its data as Window.
 func (s *Signature) AddChunk(chunk []byte) {
 	a, w := generateHash(chunk)
-	sc := SignatureChunk{
+	s.add(SignatureChunk{
 		Adler32: a,
+		Strong:  strongHash(chunk),
 		Window:  w,
+	})
+}
+
+// addHashes is like AddChunk but only keeps the hashes of chunk, like the
+// chunks of a decoded signature.
+func (s *Signature) addHashes(chunk []byte) {
+	s.add(SignatureChunk{
+		Adler32: rhash.Checksum(chunk),
+		Strong:  strongHash(chunk),
+	})
+}
+
+func (s *Signature) add(sc SignatureChunk) {
+	if s.indexMap == nil {
+		s.indexMap = make(map[uint32][]int)
 	}
 
 	s.Chunks = append(s.Chunks, sc)
@@ -39,30 +73,150 @@ func (s *Signature) AddChunk(chunk []byte) {
 	}
 }
 
-func (s *Signature) MatchChunk(hash uint32) int {
-	if indexes, ok := s.indexMap[hash]; ok {
-		index := indexes[0]
+// UnmarshalJSON decodes a signature encoded with encoding/json and rebuilds
+// its index. Like the binary encoding, the JSON encoding has no Window.
+func (s *Signature) UnmarshalJSON(data []byte) error {
+	// The alias has no methods, which avoids recursing into UnmarshalJSON.
+	type signature Signature
+
+	var decoded signature
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptInput, err)
+	}
+
+	if decoded.ChunkSize <= 0 {
+		return fmt.Errorf("%w: invalid chunk size %d", ErrCorruptInput, decoded.ChunkSize)
+	}
+
+	if decoded.ChunkSize > MaxChunkSize {
+		return fmt.Errorf("%w: %d exceeds %d", ErrInvalidChunkSize, decoded.ChunkSize, MaxChunkSize)
+	}
+
+	for i, chunk := range decoded.Chunks {
+		if chunk.Strong != nil && len(chunk.Strong) != StrongSize {
+			return fmt.Errorf("%w: chunk %d has a strong hash of %d bytes", ErrCorruptInput, i, len(chunk.Strong))
+		}
+	}
+
+	*s = Signature(decoded)
+	s.indexMap = make(map[uint32][]int, len(s.Chunks))
+	for i, chunk := range s.Chunks {
+		s.indexMap[chunk.Adler32] = append(s.indexMap[chunk.Adler32], i)
+	}
+
+	return nil
+}
+
+// Lookup returns the indexes of all chunks with the given hash, in order. The
+// returned slice must not be modified.
+// Lookups never modify the signature, so a complete Signature can be shared by
+// any number of concurrent Differs.
+func (s *Signature) Lookup(hash uint32) []int {
+	return s.indexMap[hash]
+}
+
+// Weak reports whether chunks of the signature can only be matched by their
+// Adler32, because they have neither their data nor a Strong hash. This is the
+// case for signatures written by older versions.
+func (s *Signature) Weak() bool {
+	for _, chunk := range s.Chunks {
+		if chunk.Window == nil && chunk.Strong == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matcher keeps track of the chunks of a Signature that were already matched
+// during a single diff, so that the Signature itself stays untouched.
+// Chunks with equal hashes are matched in order of their index.
+type matcher struct {
+	signature *Signature
+	matched   []bool
+	// next is the position of the first chunk in the lookup of a hash that
+	// might not be matched yet.
+	next map[uint32]int
+}
+
+func newMatcher(signature *Signature) *matcher {
+	return &matcher{
+		signature: signature,
+		matched:   make([]bool, len(signature.Chunks)),
+		next:      make(map[uint32]int),
+	}
+}
+
+// match returns the index of the next unmatched chunk with the given hash and
+// data, or -1 if there is none. Adler32 is weak, different data easily has
+// equal hashes, so chunks that have their data (Window) only match equal data
+// and chunks of decoded signatures only match data with the same Strong hash.
+// Only chunks of signatures of older versions, which have neither, match any
+// data with their Adler32.
+func (m *matcher) match(hash uint32, data []byte) int {
+	indexes := m.signature.Lookup(hash)
+	if len(indexes) == 0 {
+		return -1 // Most rolled windows match nothing.
+	}
+
+	next := m.next[hash]
+	for next < len(indexes) && m.matched[indexes[next]] {
+		next++
+	}
+	m.next[hash] = next
+
+	// The strong hash of data is only computed once a chunk needs it.
+	var strong []byte
+	for _, index := range indexes[next:] {
+		if m.matched[index] {
+			continue
+		}
+
+		chunk := &m.signature.Chunks[index]
+		if chunk.Window != nil {
+			if !bytes.Equal(chunk.Window, data) {
+				continue
+			}
+		} else if chunk.Strong != nil {
+			if strong == nil {
+				strong = strongHash(data)
+			}
 
-		// Remove Matched Chunk
-		if len(indexes) == 1 {
-			delete(s.indexMap, hash)
-		} else {
-			indexes = indexes[1:]
-			s.indexMap[hash] = indexes
+			if !bytes.Equal(chunk.Strong, strong) {
+				continue
+			}
 		}
 
+		m.matched[index] = true
+
 		return index
 	}
 
 	return -1
 }
 
+// unmatched returns the indexes of all chunks that were never matched, in
+// ascending order.
+func (m *matcher) unmatched() []int {
+	var indexes []int
+	for index, matched := range m.matched {
+		if !matched {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return indexes
+}
+
 func generateHash(data []byte) (hash uint32, window []byte) {
-	r := rhash.New()
+	r := rhash.New(len(data))
+	_, _ = r.Write(data)
 
-	for _, b := range data {
-		r.Update(b)
-	}
+	return r.Sum32(), r.AppendWindow(make([]byte, 0, len(data)))
+}
+
+func strongHash(data []byte) []byte {
+	sum := sha256.Sum256(data)
 
-	return r.Sum(), r.Window
+	return sum[:StrongSize]
 }
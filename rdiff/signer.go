@@ -0,0 +1,133 @@
+package rdiff
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+)
+
+// Signer generates the Signature of a file.
+type Signer struct {
+	options Options
+}
+
+func NewSigner(options Options) *Signer {
+	return &Signer{
+		options: options,
+	}
+}
+
+// Sign reads r until EOF and returns its Signature.
+func (s *Signer) Sign(r io.Reader) (Signature, error) {
+	return s.SignContext(context.Background(), r)
+}
+
+// SignContext is like Sign but stops as soon as ctx is done, returning the
+// context's error.
+func (s *Signer) SignContext(ctx context.Context, r io.Reader) (Signature, error) {
+	return s.sign(ctx, bufio.NewReader(r), readerSize(r), true)
+}
+
+// SignHashes is like SignContext, but the chunks of the returned signature
+// only have their hashes and no Window, like those of a decoded signature. It
+// only keeps a few bytes per chunk in memory, no matter how large r is, and is
+// meant for signatures that are sent elsewhere or only diffed against.
+// UpdateSignature needs a signature with Windows.
+func (s *Signer) SignHashes(ctx context.Context, r io.Reader) (Signature, error) {
+	return s.sign(ctx, bufio.NewReader(r), readerSize(r), false)
+}
+
+// sign returns the signature of reader, with the data of every chunk if
+// windows is set.
+func (s *Signer) sign(ctx context.Context, reader io.Reader, size int64, windows bool) (Signature, error) {
+	signature := Signature{
+		ChunkSize: s.options.ChunkSize,
+		Chunks:    make([]SignatureChunk, 0),
+		indexMap:  make(map[uint32][]int),
+	}
+
+	digest := newDigester()
+	err := s.eachChunk(ctx, reader, size, func(chunk []byte) error {
+		if windows {
+			signature.AddChunk(chunk)
+		} else {
+			signature.addHashes(chunk)
+		}
+		_, _ = digest.Write(chunk)
+		return nil
+	})
+	if err != nil {
+		return signature, err
+	}
+
+	signature.Digest = digest.digest()
+
+	return signature, nil
+}
+
+// EachChunk reads r until EOF and calls fn with every chunk a Signature of r
+// consists of, in order. The chunk is only valid during the call. Errors
+// returned by fn stop reading and are returned as is.
+func (s *Signer) EachChunk(ctx context.Context, r io.Reader, fn func(chunk []byte) error) error {
+	return s.eachChunk(ctx, bufio.NewReader(r), readerSize(r), fn)
+}
+
+func (s *Signer) eachChunk(ctx context.Context, reader io.Reader, size int64, fn func([]byte) error) error {
+	if err := s.options.Validate(); err != nil {
+		return err
+	}
+
+	chunkSize := s.options.ChunkSize
+	progress := newProgressTracker(ctx, s.options.Progress, StageSignature, size)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	chunkData := make([]byte, chunkSize)
+	for {
+		// Always fill a whole chunk, a short read from the underlying reader
+		// must not split a chunk.
+		read, err := io.ReadFull(reader, chunkData)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return &SourceError{Source: "original", Op: "read", Err: err}
+		}
+
+		if err = fn(chunkData[:read]); err != nil {
+			return err
+		}
+
+		if err = progress.add(read); err != nil {
+			return err
+		}
+
+		if read < chunkSize {
+			break
+		}
+	}
+
+	progress.done()
+
+	return nil
+}
+
+// readerSize returns the size of the data behind r, if it can be determined
+// cheaply, or -1 otherwise.
+func readerSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case interface{ Size() int64 }:
+		return v.Size()
+	case interface{ Stat() (os.FileInfo, error) }:
+		info, err := v.Stat()
+		if err == nil {
+			return info.Size()
+		}
+	}
+
+	return -1
+}
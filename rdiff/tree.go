@@ -0,0 +1,198 @@
+package rdiff
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"io"
+)
+
+// TreeHash is the hash of a node of a TreeSignature.
+type TreeHash [sha256.Size]byte
+
+// TreeSignature is a hierarchical signature of a file. The leaves hash the
+// chunks of the file like a Signature, but with sha256, and every interior node
+// hashes its two children. Files that are equal in a range of chunks have
+// equal nodes for that range, so comparing two trees from the root only needs
+// to descend into the subtrees that differ (see DiffTrees).
+//
+// Node i on level l covers the leaves i<<l up to (i+1)<<l. A node without a
+// right sibling is moved up unchanged, so the root covers all leaves and
+// nodes above it are equal to it.
+type TreeSignature struct {
+	ChunkSize int
+	// Size is the size of the file.
+	Size int64
+
+	// levels holds the nodes of each level, starting with the leaves.
+	levels [][]TreeHash
+}
+
+// SignTree reads r until EOF and returns its TreeSignature.
+func (s *Signer) SignTree(ctx context.Context, r io.Reader) (*TreeSignature, error) {
+	var size int64
+	var leaves []TreeHash
+	err := s.eachChunk(ctx, bufio.NewReader(r), readerSize(r), func(chunk []byte) error {
+		size += int64(len(chunk))
+		leaves = append(leaves, LeafHash(chunk))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTreeSignature(s.options.ChunkSize, size, leaves), nil
+}
+
+// NewTreeSignature builds the tree of a file of the given size from the hashes
+// of its chunks (see LeafHash).
+func NewTreeSignature(chunkSize int, size int64, leaves []TreeHash) *TreeSignature {
+	t := &TreeSignature{
+		ChunkSize: chunkSize,
+		Size:      size,
+		levels:    [][]TreeHash{leaves},
+	}
+
+	for level := leaves; len(level) > 1; {
+		parents := make([]TreeHash, (len(level)+1)/2)
+		for i := range parents {
+			if 2*i+1 < len(level) {
+				parents[i] = nodeHash(level[2*i], level[2*i+1])
+			} else {
+				parents[i] = level[2*i]
+			}
+		}
+
+		t.levels = append(t.levels, parents)
+		level = parents
+	}
+
+	return t
+}
+
+// LeafHash returns the hash of a chunk as used for the leaves of a
+// TreeSignature.
+func LeafHash(chunk []byte) TreeHash {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(chunk)
+
+	var hash TreeHash
+	h.Sum(hash[:0])
+
+	return hash
+}
+
+// nodeHash returns the hash of an interior node. Leaves and interior nodes are
+// hashed with different prefixes, so that no chunk can be mistaken for a node.
+func nodeHash(left, right TreeHash) TreeHash {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left[:])
+	h.Write(right[:])
+
+	var hash TreeHash
+	h.Sum(hash[:0])
+
+	return hash
+}
+
+// Leaves returns the number of chunks of the file.
+func (t *TreeSignature) Leaves() int {
+	return len(t.levels[0])
+}
+
+// Height returns the level of the root.
+func (t *TreeSignature) Height() int {
+	return TreeHeight(t.Leaves())
+}
+
+// TreeHeight returns the level of the root of a tree with the given number of
+// leaves.
+func TreeHeight(leaves int) int {
+	height := 0
+	for n := leaves; n > 1; n = (n + 1) / 2 {
+		height++
+	}
+
+	return height
+}
+
+// Node returns the node at index on level, and false if there is none. Levels
+// above the root only have the root.
+func (t *TreeSignature) Node(level, index int) (TreeHash, bool) {
+	if level < 0 || index < 0 || t.Leaves() == 0 {
+		return TreeHash{}, false
+	}
+
+	if level > t.Height() {
+		if index > 0 {
+			return TreeHash{}, false
+		}
+
+		level = t.Height()
+	}
+
+	if index >= len(t.levels[level]) {
+		return TreeHash{}, false
+	}
+
+	return t.levels[level][index], true
+}
+
+// DiffTrees returns the indexes of the leaves of a that differ from the leaf
+// at the same index of b, or that b does not have, in ascending order. Only
+// subtrees with different hashes are visited, so a single differing chunk is
+// found in O(log n) comparisons.
+func DiffTrees(a, b *TreeSignature) []int {
+	level := a.Height()
+	if b.Height() > level {
+		level = b.Height()
+	}
+
+	indexes := []int{0}
+	for ; level >= 0 && len(indexes) > 0; level-- {
+		hashes := make([]TreeHash, 0, len(indexes))
+		present := make([]bool, 0, len(indexes))
+		for _, i := range indexes {
+			hash, ok := b.Node(level, i)
+			hashes = append(hashes, hash)
+			present = append(present, ok)
+		}
+
+		indexes = a.DiffLevel(level, indexes, hashes, present)
+	}
+
+	return indexes
+}
+
+// DiffLevel compares the nodes at indexes on level with the given hashes of
+// another tree, where present tells whether the other tree has the node. It
+// returns the indexes to compare on the level below, the children of the nodes
+// that differ, or on the leaf level the differing leaves themselves. Nodes the
+// tree does not have are skipped.
+// DiffLevel is a single step of DiffTrees, for trees that are not local.
+func (t *TreeSignature) DiffLevel(level int, indexes []int, hashes []TreeHash, present []bool) []int {
+	var next []int
+	for j, i := range indexes {
+		hash, ok := t.Node(level, i)
+		if !ok || (present[j] && hashes[j] == hash) {
+			continue
+		}
+
+		if level == 0 {
+			next = append(next, i)
+			continue
+		}
+
+		// Only children that exist in this tree can differ from the other.
+		for _, child := range []int{2 * i, 2*i + 1} {
+			if _, ok = t.Node(level-1, child); ok {
+				next = append(next, child)
+			}
+		}
+	}
+
+	return next
+}
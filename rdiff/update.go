@@ -0,0 +1,154 @@
+package rdiff
+
+import (
+	"fmt"
+)
+
+// UpdateSignature returns the Signature of the file that results from applying
+// delta to the file of signature, without reading either file. Chunks of the
+// new file that are a whole chunk of the original keep its hash, only chunks
+// with new bytes or parts of original chunks are hashed again.
+// Hashing parts of original chunks needs their data, so it fails with
+// ErrMissingSource for signatures without Window, such as decoded ones, unless
+// the delta only copies whole chunks to chunk boundaries.
+// The new signature has the Digest of the updated file recorded in delta.
+func UpdateSignature(signature Signature, delta Delta) (Signature, error) {
+	if delta.ChunkSize != signature.ChunkSize || delta.ChunkCount != len(signature.Chunks) ||
+		(signature.Digest != nil && delta.Original != nil && *signature.Digest != *delta.Original) {
+		return Signature{}, fmt.Errorf("%w: delta was not generated from the signature", ErrCorruptInput)
+	}
+
+	segments, err := delta.segments()
+	if err != nil {
+		return Signature{}, err
+	}
+
+	u := &signatureUpdate{
+		old: signature,
+		updated: Signature{
+			ChunkSize: signature.ChunkSize,
+			Chunks:    make([]SignatureChunk, 0, len(signature.Chunks)),
+			indexMap:  make(map[uint32][]int),
+		},
+		pending: make([]byte, 0, signature.ChunkSize),
+	}
+
+	for _, s := range segments {
+		if s.literal != nil {
+			u.write(s.literal)
+			continue
+		}
+
+		if err = u.copy(s); err != nil {
+			return Signature{}, err
+		}
+	}
+
+	if len(u.pending) > 0 {
+		u.updated.AddChunk(u.pending)
+	}
+
+	u.updated.Digest = copyDigest(delta.Updated)
+
+	return u.updated, nil
+}
+
+// signatureUpdate splits the updated file into chunks as it is described by
+// the segments of a delta.
+type signatureUpdate struct {
+	old     Signature
+	updated Signature
+
+	// pending is the data of the next chunk of the updated file so far.
+	pending []byte
+}
+
+// write adds data to the updated file.
+func (u *signatureUpdate) write(data []byte) {
+	for len(data) > 0 {
+		n := u.updated.ChunkSize - len(u.pending)
+		if n > len(data) {
+			n = len(data)
+		}
+
+		u.pending = append(u.pending, data[:n]...)
+		data = data[n:]
+
+		if len(u.pending) == u.updated.ChunkSize {
+			u.updated.AddChunk(u.pending)
+			u.pending = u.pending[:0]
+		}
+	}
+}
+
+// copy adds the range of the original described by s to the updated file.
+func (u *signatureUpdate) copy(s segment) error {
+	chunkSize := int64(u.old.ChunkSize)
+	last := int64(len(u.old.Chunks)) - 1
+
+	offset, remaining := s.offset, s.length
+	for s.open || remaining > 0 {
+		index := offset / chunkSize
+		skip := offset % chunkSize
+		if index > last {
+			if s.open {
+				return nil
+			}
+
+			return fmt.Errorf("%w: range at %d is beyond the end of the original", ErrCorruptInput, offset)
+		}
+
+		chunk := u.old.Chunks[index]
+
+		// A whole chunk of the original at a chunk boundary of the updated
+		// file keeps its hash. The length of the last chunk of the original is
+		// only known from its Window, or if it is copied up to the end.
+		whole := len(u.pending) == 0 && skip == 0 &&
+			((index < last && remaining >= chunkSize) ||
+				(index == last && (s.open || (chunk.Window != nil && remaining == int64(len(chunk.Window))))))
+		if whole {
+			u.addChunk(chunk)
+
+			offset += chunkSize
+			remaining -= chunkSize
+			if index == last {
+				return nil
+			}
+
+			continue
+		}
+
+		if chunk.Window == nil {
+			return fmt.Errorf("%w: data of chunk %d is needed to update the signature", ErrMissingSource, index)
+		}
+
+		if index < last && int64(len(chunk.Window)) != chunkSize {
+			return fmt.Errorf("%w: chunk %d has %d bytes of data", ErrCorruptInput, index, len(chunk.Window))
+		}
+
+		data := chunk.Window[skip:]
+		if !s.open && int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+
+		if len(data) == 0 {
+			return fmt.Errorf("%w: range at %d is beyond the end of the original", ErrCorruptInput, offset)
+		}
+
+		u.write(data)
+
+		offset += int64(len(data))
+		remaining -= int64(len(data))
+		if index == last && s.open {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// addChunk adds a chunk with a known hash to the updated signature.
+func (u *signatureUpdate) addChunk(chunk SignatureChunk) {
+	u.updated.Chunks = append(u.updated.Chunks, chunk)
+	u.updated.indexMap[chunk.Adler32] = append(u.updated.indexMap[chunk.Adler32], len(u.updated.Chunks)-1)
+}
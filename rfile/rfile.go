@@ -0,0 +1,162 @@
+// Package rfile writes files atomically and keeps the settings of the stores
+// of rstore and rdedup.
+//
+// A store is a directory with its settings in a JSON file, which is written
+// last when the store is initialized, so that only complete stores can be
+// opened. A store must not be modified by several processes at once.
+package rfile
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+var (
+	// ErrInvalidArgument is returned for invalid settings and names of the
+	// contents of a store.
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrNotFound is returned for stores and their contents that do not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when initializing a store in a directory that
+	// already has one.
+	ErrExists = errors.New("store already exists")
+)
+
+// Settings are the settings of a store.
+type Settings interface {
+	Validate() error
+}
+
+// InitStore validates settings and writes them to the file settingsName in
+// dir, which must not have a store yet. create is called to set up the rest of
+// the store before.
+func InitStore(dir, settingsName string, settings Settings, create func() error) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, settingsName)); err == nil {
+		return fmt.Errorf("%w: %s", ErrExists, dir)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	if err := create(); err != nil {
+		return err
+	}
+
+	return WriteJSON(filepath.Join(dir, settingsName), settings)
+}
+
+// OpenStore reads the settings of the store in dir from the file settingsName
+// into settings and validates them.
+func OpenStore(dir, settingsName string, settings Settings) error {
+	if err := ReadJSON(filepath.Join(dir, settingsName), settings); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: no store in %s", ErrNotFound, dir)
+		}
+
+		return err
+	}
+
+	if err := settings.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", rdiff.ErrCorruptInput, err)
+	}
+
+	return nil
+}
+
+// ReadJSON decodes the file name into v.
+func ReadJSON(name string, v interface{}) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s: %v", rdiff.ErrCorruptInput, name, err)
+	}
+
+	return nil
+}
+
+// WriteJSON atomically replaces the file name with v encoded as JSON.
+func WriteJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(name, 0o644, func(f *os.File) error {
+		_, err := f.Write(append(data, '\n'))
+		return err
+	})
+}
+
+// WriteFile atomically replaces the file name with the data written by write.
+// write is called with a temporary file next to name, which replaces name once
+// write succeeded and the data is synced. Otherwise it is removed.
+// The file keeps the permissions of the file it replaces, new files get perm
+// minus the umask, like with os.WriteFile.
+func WriteFile(name string, perm os.FileMode, write func(f *os.File) error) (err error) {
+	tmp, err := createTemp(name, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return err
+	}
+
+	if info, statErr := os.Stat(name); statErr == nil {
+		if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// createTemp creates a new hidden file next to name with perm minus the
+// umask. Unlike os.CreateTemp, which always uses 0600, this leaves applying
+// the umask to the system.
+func createTemp(name string, perm os.FileMode) (*os.File, error) {
+	random := make([]byte, 6)
+	for i := 0; i < 100; i++ {
+		if _, err := io.ReadFull(rand.Reader, random); err != nil {
+			return nil, err
+		}
+
+		tmp := filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+".rdetective-"+hex.EncodeToString(random))
+		f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if !errors.Is(err, os.ErrExist) {
+			return f, err
+		}
+	}
+
+	return nil, fmt.Errorf("failed to create a temporary file for %s", name)
+}
@@ -0,0 +1,192 @@
+package rfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+type settings struct {
+	Size int `json:"size"`
+}
+
+func (s settings) Validate() error {
+	if s.Size <= 0 {
+		return fmt.Errorf("%w: size %d", ErrInvalidArgument, s.Size)
+	}
+
+	return nil
+}
+
+func write(data string) func(f *os.File) error {
+	return func(f *os.File) error {
+		_, err := f.WriteString(data)
+		return err
+	}
+}
+
+func checkFile(t *testing.T, name, expected string) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != expected {
+		t.Errorf("unexpected content, got %q, expected %q", data, expected)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file")
+
+	if err := WriteFile(name, 0o600, write("hello")); err != nil {
+		t.Fatalf("error writing file: %s", err.Error())
+	}
+
+	checkFile(t, name, "hello")
+
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("unexpected mode of new file, got %v, expected %v", info.Mode().Perm(), os.FileMode(0o600))
+	}
+
+	// Replaced files keep their mode.
+	if err = os.Chmod(name, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = WriteFile(name, 0o600, write("hello world")); err != nil {
+		t.Fatalf("error writing file: %s", err.Error())
+	}
+
+	checkFile(t, name, "hello world")
+
+	if info, err = os.Stat(name); err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("unexpected mode of replaced file, got %v, expected %v", info.Mode().Perm(), os.FileMode(0o640))
+	}
+
+	// Failed writes leave the file alone and clean up.
+	failure := errors.New("failure")
+	err = WriteFile(name, 0o600, func(f *os.File) error {
+		_, _ = f.WriteString("partial")
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("unexpected error, got %v, expected %v", err, failure)
+	}
+
+	checkFile(t, name, "hello world")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Errorf("unexpected number of files, got %d, expected 1", len(entries))
+	}
+}
+
+func TestWriteFileUmask(t *testing.T) {
+	dir := t.TempDir()
+
+	// A file created the usual way has the mode the umask allows.
+	expected := filepath.Join(dir, "expected")
+	if err := os.WriteFile(expected, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(dir, "file")
+	if err := WriteFile(name, 0o644, write("hello")); err != nil {
+		t.Fatalf("error writing file: %s", err.Error())
+	}
+
+	expectedInfo, err := os.Stat(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != expectedInfo.Mode().Perm() {
+		t.Errorf("unexpected mode, got %v, expected %v", info.Mode().Perm(), expectedInfo.Mode().Perm())
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "store")
+
+	if err := InitStore(dir, "settings.json", settings{}, nil); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidArgument)
+	}
+
+	var opened settings
+	if err := OpenStore(dir, "settings.json", &opened); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrNotFound)
+	}
+
+	// A store that failed to set up cannot be opened.
+	failure := errors.New("failure")
+	err := InitStore(dir, "settings.json", settings{Size: 4}, func() error { return failure })
+	if !errors.Is(err, failure) {
+		t.Errorf("unexpected error, got %v, expected %v", err, failure)
+	}
+
+	if err = OpenStore(dir, "settings.json", &opened); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrNotFound)
+	}
+
+	created := false
+	err = InitStore(dir, "settings.json", settings{Size: 4}, func() error {
+		created = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error initializing store: %s", err.Error())
+	}
+
+	if !created {
+		t.Errorf("store was not set up")
+	}
+
+	if err = InitStore(dir, "settings.json", settings{Size: 4}, func() error { return nil }); !errors.Is(err, ErrExists) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrExists)
+	}
+
+	if err = OpenStore(dir, "settings.json", &opened); err != nil {
+		t.Fatalf("error opening store: %s", err.Error())
+	}
+
+	if opened.Size != 4 {
+		t.Errorf("unexpected settings, got %+v, expected size 4", opened)
+	}
+
+	for data, expected := range map[string]error{
+		`{"size": 0}`: rdiff.ErrCorruptInput,
+		`{"size":`:    rdiff.ErrCorruptInput,
+	} {
+		if err = os.WriteFile(filepath.Join(dir, "settings.json"), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = OpenStore(dir, "settings.json", &opened); !errors.Is(err, expected) {
+			t.Errorf("unexpected error for %s, got %v, expected %v", data, err, expected)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+// Package rhttp exposes signature, delta and patch generation over HTTP.
+//
+//	POST /signature?chunk_size=N   body: original file
+//	POST /delta                    multipart: "signature", then "updated"
+//	POST /patch                    multipart: "delta", then "original"
+//
+// Signatures and deltas are returned as JSON if the Accept header asks for
+// application/json and in the binary rdiff encoding otherwise. Signature and
+// delta parts are decoded according to their Content-Type in the same way.
+// Request and response bodies are streamed, only /patch buffers the original
+// file in a temporary file as patching needs random access to it. Signature
+// and delta parts are decoded in memory and limited to Config.MaxPartSize.
+// The signatures generated by /signature are bound by it as well, which limits
+// the size of the original file to about MaxPartSize / 20 * chunk_size.
+package rhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+const (
+	contentTypeJSON   = "application/json"
+	contentTypeBinary = "application/octet-stream"
+)
+
+// DefaultMaxPartSize is used if Config.MaxPartSize is not set. It fits the
+// binary signature of a 3 GiB file with 1 KiB chunks, or a delta with up to
+// 64 MiB of new data.
+const DefaultMaxPartSize = 64 << 20
+
+// ErrBadRequest is returned for requests that do not match the API.
+var ErrBadRequest = errors.New("bad request")
+
+type Config struct {
+	Logger logrus.FieldLogger
+
+	// ChunkSize is used for signatures if the request does not set one.
+	ChunkSize int
+
+	// MaxPartSize bounds the size of signature and delta parts, which are
+	// decoded in memory. DefaultMaxPartSize is used if it is not set.
+	MaxPartSize int64
+}
+
+// Handler serves the API.
+type Handler struct {
+	config *Config
+	mux    *http.ServeMux
+}
+
+func NewHandler(config *Config) *Handler {
+	h := &Handler{
+		config: config,
+		mux:    http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("/signature", h.post(h.handleSignature))
+	h.mux.HandleFunc("/delta", h.post(h.handleDelta))
+	h.mux.HandleFunc("/patch", h.post(h.handlePatch))
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// post only accepts POST requests and reports errors returned by handler.
+func (h *Handler) post(handler func(http.ResponseWriter, *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := handler(w, r); err != nil {
+			status := statusCode(err)
+			h.config.Logger.WithError(err).WithField("path", r.URL.Path).Debugln("request failed")
+			http.Error(w, err.Error(), status)
+		}
+	}
+}
+
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrBadRequest),
+		errors.Is(err, rdiff.ErrCorruptInput),
+		errors.Is(err, rdiff.ErrInvalidChunkSize):
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+func (h *Handler) handleSignature(w http.ResponseWriter, r *http.Request) error {
+	chunkSize := h.config.ChunkSize
+	if value := r.URL.Query().Get("chunk_size"); value != "" {
+		var err error
+		if chunkSize, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%w: invalid chunk_size %q", ErrBadRequest, value)
+		}
+	}
+
+	options := rdiff.Options{
+		Logger:    h.config.Logger,
+		ChunkSize: chunkSize,
+	}
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	// The signature is built in memory, limit the body to what results in a
+	// signature of at most Config.MaxPartSize, like the parts of other
+	// requests.
+	chunks := h.maxPartSize() / (4 + rdiff.StrongSize)
+	size := int64(math.MaxInt64)
+	if chunks < size/int64(chunkSize) {
+		size = chunks * int64(chunkSize)
+	}
+
+	signature, err := rdiff.NewSigner(options).SignHashes(r.Context(), http.MaxBytesReader(w, r.Body, size))
+	if err != nil {
+		var sourceErr *rdiff.SourceError
+		if errors.As(err, &sourceErr) && sourceErr.Op == "read" {
+			return fmt.Errorf("%w: %v", ErrBadRequest, sourceErr.Err)
+		}
+
+		return err
+	}
+
+	return respond(w, r, signature)
+}
+
+func (h *Handler) handleDelta(w http.ResponseWriter, r *http.Request) error {
+	parts, err := r.MultipartReader()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+
+	part, err := nextPart(parts, "signature")
+	if err != nil {
+		return err
+	}
+
+	var signature rdiff.Signature
+	body := h.limit(w, part)
+	if isJSON(part.Header.Get("Content-Type")) {
+		err = json.NewDecoder(body).Decode(&signature)
+	} else {
+		signature, err = rdiff.ReadSignature(body)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: signature: %v", ErrBadRequest, err)
+	}
+
+	// Chunks that are only matched by their weak hash likely result in a delta
+	// that does not apply.
+	if signature.Weak() {
+		return fmt.Errorf("%w: signature has no strong hashes, it was written by an older version", ErrBadRequest)
+	}
+
+	if part, err = nextPart(parts, "updated"); err != nil {
+		return err
+	}
+
+	delta, err := rdiff.NewDiffer(signature, rdiff.Options{Logger: h.config.Logger}).DiffContext(r.Context(), part)
+	if err != nil {
+		return err
+	}
+
+	return respond(w, r, delta)
+}
+
+func (h *Handler) handlePatch(w http.ResponseWriter, r *http.Request) error {
+	parts, err := r.MultipartReader()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+
+	part, err := nextPart(parts, "delta")
+	if err != nil {
+		return err
+	}
+
+	var delta rdiff.Delta
+	body := h.limit(w, part)
+	if isJSON(part.Header.Get("Content-Type")) {
+		err = json.NewDecoder(body).Decode(&delta)
+	} else {
+		delta, err = rdiff.ReadDelta(body)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: delta: %v", ErrBadRequest, err)
+	}
+
+	if part, err = nextPart(parts, "original"); err != nil {
+		return err
+	}
+
+	original, err := os.CreateTemp("", "rdetective-original-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(original.Name())
+	defer original.Close()
+
+	size, err := io.Copy(original, part)
+	if err != nil {
+		return fmt.Errorf("%w: original: %v", ErrBadRequest, err)
+	}
+
+	// Make sure patching cannot fail halfway through the response.
+	if err = delta.CheckBounds(size); err != nil {
+		return fmt.Errorf("%w: delta does not match the original: %v", ErrBadRequest, err)
+	}
+
+	if err = rdiff.VerifyOriginal(r.Context(), io.NewSectionReader(original, 0, size), delta); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+
+	w.Header().Set("Content-Type", contentTypeBinary)
+	if err = rdiff.Apply(original, delta, w); err != nil {
+		// The response is already under way, the only thing left is to make
+		// sure the client does not mistake it for a complete one.
+		h.config.Logger.WithError(err).Warnln("patch failed")
+		panic(http.ErrAbortHandler)
+	}
+
+	return nil
+}
+
+// limit bounds how much of part is read to Config.MaxPartSize.
+func (h *Handler) limit(w http.ResponseWriter, part *multipart.Part) io.Reader {
+	return http.MaxBytesReader(w, part, h.maxPartSize())
+}
+
+func (h *Handler) maxPartSize() int64 {
+	if h.config.MaxPartSize <= 0 {
+		return DefaultMaxPartSize
+	}
+
+	return h.config.MaxPartSize
+}
+
+// nextPart returns the next part of the multipart body, which must be named
+// name.
+func nextPart(parts *multipart.Reader, name string) (*multipart.Part, error) {
+	part, err := parts.NextPart()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: missing part %q", ErrBadRequest, name)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+
+	if part.FormName() != name {
+		return nil, fmt.Errorf("%w: expected part %q, got %q", ErrBadRequest, name, part.FormName())
+	}
+
+	return part, nil
+}
+
+// respond writes value in the format requested by the Accept header.
+func respond(w http.ResponseWriter, r *http.Request, value io.WriterTo) error {
+	if isJSON(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		return json.NewEncoder(w).Encode(value)
+	}
+
+	w.Header().Set("Content-Type", contentTypeBinary)
+	_, err := value.WriteTo(w)
+
+	return err
+}
+
+// isJSON reports whether a Content-Type or Accept header asks for JSON.
+func isJSON(header string) bool {
+	return strings.Contains(header, contentTypeJSON)
+}
@@ -0,0 +1,289 @@
+package rhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+func getServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(NewHandler(&Config{
+		Logger: &logrus.Logger{
+			Out:       io.Discard,
+			Formatter: &logrus.TextFormatter{},
+			Level:     logrus.DebugLevel,
+		},
+		ChunkSize: 4,
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+type part struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// post sends parts as multipart body, or the data of a single unnamed part as
+// plain body.
+func post(t *testing.T, url, accept string, parts ...part) (*http.Response, []byte) {
+	var body bytes.Buffer
+	contentType := "application/octet-stream"
+
+	if len(parts) == 1 && parts[0].name == "" {
+		body.Write(parts[0].data)
+	} else {
+		writer := multipart.NewWriter(&body)
+		for _, p := range parts {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", `form-data; name="`+p.name+`"`)
+			header.Set("Content-Type", p.contentType)
+
+			w, err := writer.CreatePart(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _ = w.Write(p.data)
+		}
+		_ = writer.Close()
+		contentType = writer.FormDataContentType()
+	}
+
+	request, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("Accept", accept)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("error posting to %s: %s", url, err.Error())
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response, data
+}
+
+func TestRoundTrip(t *testing.T) {
+	server := getServer(t)
+	original := []byte("the quick brown fox jumps over the lazy dog")
+	updated := []byte("the quick brown cat jumps over the very lazy dog!")
+
+	for _, format := range []string{contentTypeBinary, contentTypeJSON} {
+		response, signature := post(t, server.URL+"/signature?chunk_size=3", format, part{data: original})
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected signature status %d: %s", format, response.StatusCode, signature)
+		}
+
+		if response.Header.Get("Content-Type") != format {
+			t.Errorf("%s: unexpected content type %s", format, response.Header.Get("Content-Type"))
+		}
+
+		response, delta := post(t, server.URL+"/delta", format,
+			part{name: "signature", contentType: format, data: signature},
+			part{name: "updated", contentType: contentTypeBinary, data: updated})
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected delta status %d: %s", format, response.StatusCode, delta)
+		}
+
+		if format == contentTypeJSON {
+			var decoded rdiff.Delta
+			if err := json.Unmarshal(delta, &decoded); err != nil {
+				t.Fatalf("%s: error decoding delta: %s", format, err.Error())
+			}
+
+			if decoded.ChunkSize != 3 {
+				t.Errorf("%s: unexpected chunk size %d", format, decoded.ChunkSize)
+			}
+		}
+
+		response, patched := post(t, server.URL+"/patch", "",
+			part{name: "delta", contentType: format, data: delta},
+			part{name: "original", contentType: contentTypeBinary, data: original})
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected patch status %d: %s", format, response.StatusCode, patched)
+		}
+
+		if !bytes.Equal(patched, updated) {
+			t.Errorf("%s: unexpected patch result %q", format, patched)
+		}
+	}
+}
+
+func TestDefaultChunkSize(t *testing.T) {
+	server := getServer(t)
+
+	_, data := post(t, server.URL+"/signature", contentTypeJSON, part{data: []byte("hello world")})
+
+	var signature rdiff.Signature
+	if err := json.Unmarshal(data, &signature); err != nil {
+		t.Fatalf("error decoding signature: %s", err.Error())
+	}
+
+	if signature.ChunkSize != 4 || len(signature.Chunks) != 3 {
+		t.Errorf("unexpected signature, chunk size %d with %d chunks", signature.ChunkSize, len(signature.Chunks))
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	server := getServer(t)
+
+	var signature bytes.Buffer
+	s, _ := rdiff.NewSigner(rdiff.Options{ChunkSize: 2}).Sign(strings.NewReader("hello"))
+	_, _ = s.WriteTo(&signature)
+
+	delta := rdiff.Delta{ChunkSize: 2, ChunkCount: 3, Changes: []rdiff.DeltaChunk{{ChunkIndex: 2}}}
+	var encodedDelta bytes.Buffer
+	_, _ = delta.WriteTo(&encodedDelta)
+
+	// Signatures of older versions only have weak hashes.
+	weak := rdiff.Signature{ChunkSize: s.ChunkSize}
+	for _, chunk := range s.Chunks {
+		weak.Chunks = append(weak.Chunks, rdiff.SignatureChunk{Adler32: chunk.Adler32})
+	}
+	var weakSignature bytes.Buffer
+	_, _ = weak.WriteTo(&weakSignature)
+
+	verified, _ := rdiff.NewDiffer(s, rdiff.Options{}).Diff(strings.NewReader("hello world"))
+	var encodedVerified bytes.Buffer
+	_, _ = verified.WriteTo(&encodedVerified)
+
+	tests := []struct {
+		name  string
+		path  string
+		parts []part
+	}{
+		{"invalid chunk size", "/signature?chunk_size=0", []part{{data: []byte("hello")}}},
+		{"malformed chunk size", "/signature?chunk_size=x", []part{{data: []byte("hello")}}},
+		{"huge chunk size", "/signature?chunk_size=1073741824", []part{{data: []byte("hello")}}},
+		{"delta without multipart", "/delta", []part{{data: []byte("hello")}}},
+		{"delta missing updated", "/delta", []part{{name: "signature", data: signature.Bytes()}}},
+		{"delta wrong order", "/delta", []part{
+			{name: "updated", data: []byte("hello")},
+			{name: "signature", data: signature.Bytes()},
+		}},
+		{"delta corrupt signature", "/delta", []part{
+			{name: "signature", data: []byte("garbage")},
+			{name: "updated", data: []byte("hello")},
+		}},
+		{"delta huge chunk size", "/delta", []part{
+			{name: "signature", data: []byte("RDSG\x01\xcd\xcd\xcd\xcd0\x00")},
+			{name: "updated", data: []byte("hello")},
+		}},
+		{"delta huge json chunk size", "/delta", []part{
+			{name: "signature", contentType: contentTypeJSON, data: []byte(`{"chunk_size":1073741824,"chunks":[]}`)},
+			{name: "updated", data: []byte("hello")},
+		}},
+		{"delta weak signature", "/delta", []part{
+			{name: "signature", data: weakSignature.Bytes()},
+			{name: "updated", data: []byte("hello")},
+		}},
+		{"patch corrupt delta", "/patch", []part{
+			{name: "delta", contentType: contentTypeJSON, data: []byte("{")},
+			{name: "original", data: []byte("hello")},
+		}},
+		{"patch short original", "/patch", []part{
+			{name: "delta", data: encodedDelta.Bytes()},
+			{name: "original", data: []byte("he")},
+		}},
+		{"patch wrong original", "/patch", []part{
+			{name: "delta", data: encodedVerified.Bytes()},
+			{name: "original", data: []byte("jello")},
+		}},
+	}
+
+	for _, test := range tests {
+		response, body := post(t, server.URL+test.path, "", test.parts...)
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status %d: %s", test.name, response.StatusCode, body)
+		}
+	}
+
+	response, err := http.Get(server.URL + "/signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status for GET %d", response.StatusCode)
+	}
+}
+
+func TestMaxPartSize(t *testing.T) {
+	server := httptest.NewServer(NewHandler(&Config{
+		Logger:      &logrus.Logger{Out: io.Discard, Formatter: &logrus.TextFormatter{}},
+		ChunkSize:   4,
+		MaxPartSize: 128,
+	}))
+	t.Cleanup(server.Close)
+
+	signature := func(data string) []byte {
+		s, err := rdiff.NewSigner(rdiff.Options{ChunkSize: 4}).Sign(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("error generating signature: %s", err.Error())
+		}
+
+		var encoded bytes.Buffer
+		if _, err = s.WriteTo(&encoded); err != nil {
+			t.Fatal(err)
+		}
+
+		return encoded.Bytes()
+	}
+
+	small := signature("hello")
+	response, body := post(t, server.URL+"/delta", "", part{name: "signature", data: small}, part{name: "updated", data: []byte("hello world")})
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", response.StatusCode, body)
+	}
+
+	large := signature(strings.Repeat("hello world ", 8))
+	if len(large) <= 128 {
+		t.Fatalf("signature of %d bytes does not exceed the limit", len(large))
+	}
+
+	response, body = post(t, server.URL+"/delta", "", part{name: "signature", data: large}, part{name: "updated", data: []byte("hello world")})
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status %d: %s", response.StatusCode, body)
+	}
+
+	delta := rdiff.Delta{ChunkSize: 4, Changes: []rdiff.DeltaChunk{{NewBytes: bytes.Repeat([]byte("x"), 200)}}}
+	var encodedDelta bytes.Buffer
+	_, _ = delta.WriteTo(&encodedDelta)
+
+	response, body = post(t, server.URL+"/patch", "", part{name: "delta", data: encodedDelta.Bytes()}, part{name: "original", data: nil})
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status %d: %s", response.StatusCode, body)
+	}
+
+	// 128 bytes fit the signature of 6 chunks of 4 bytes.
+	response, body = post(t, server.URL+"/signature", "", part{data: []byte("hello world")})
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status %d: %s", response.StatusCode, body)
+	}
+
+	response, body = post(t, server.URL+"/signature", "", part{data: bytes.Repeat([]byte("x"), 25)})
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status %d: %s", response.StatusCode, body)
+	}
+}
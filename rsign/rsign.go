@@ -0,0 +1,229 @@
+// Package rsign signs serialized signatures and deltas with ed25519, so that
+// their receivers can make sure they come from the holder of a private key.
+//
+// Data is either wrapped in an envelope that carries its signature (see Sign
+// and Open), or signed with a detached signature that is stored next to it
+// (see SignDetached and VerifyDetached). Both sign a header that tells them
+// apart, so that an embedded signature cannot be passed off as a detached one
+// or the other way around.
+package rsign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// An envelope is envelopeMagic, the version, the length of the data as
+// unsigned varint, the data and the signature of everything before it.
+// A detached signature is detachedMagic, the version and the signature of
+// detachedMagic, the version and the data.
+const (
+	envelopeMagic = "RDSE"
+	detachedMagic = "RDSD"
+	version       = 1
+
+	privateKeyType = "PRIVATE KEY"
+	publicKeyType  = "PUBLIC KEY"
+)
+
+var (
+	// ErrUnsigned is returned when data that must be signed is not.
+	ErrUnsigned = errors.New("unsigned data")
+	// ErrInvalidSignature is returned when a signature does not match the
+	// data or the key, or cannot be decoded.
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrInvalidKey is returned for keys that cannot be decoded or are no
+	// ed25519 keys.
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// GenerateKey returns a new random key pair.
+func GenerateKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// Sign returns data wrapped in an envelope that is signed with key.
+func Sign(key ed25519.PrivateKey, data []byte) []byte {
+	envelope := make([]byte, 0, len(envelopeMagic)+1+binary.MaxVarintLen64+len(data)+ed25519.SignatureSize)
+	envelope = append(envelope, envelopeMagic...)
+	envelope = append(envelope, version)
+
+	var length [binary.MaxVarintLen64]byte
+	envelope = append(envelope, length[:binary.PutUvarint(length[:], uint64(len(data)))]...)
+	envelope = append(envelope, data...)
+
+	return append(envelope, ed25519.Sign(key, envelope)...)
+}
+
+// IsSigned reports whether data is an envelope. It does not verify it.
+func IsSigned(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(envelopeMagic))
+}
+
+// Open verifies the envelope in data with key and returns the data in it. It
+// fails with ErrUnsigned if data is no envelope, and with ErrInvalidSignature
+// if the envelope was not signed by key or was modified after.
+func Open(key ed25519.PublicKey, data []byte) ([]byte, error) {
+	signed, content, signature, err := split(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: public key has %d bytes", ErrInvalidKey, len(key))
+	}
+
+	if !ed25519.Verify(key, signed, signature) {
+		return nil, fmt.Errorf("%w: envelope was not signed by the key", ErrInvalidSignature)
+	}
+
+	return content, nil
+}
+
+// Unwrap returns the data in the envelope in data without verifying it, or
+// data itself if it is no envelope.
+func Unwrap(data []byte) ([]byte, error) {
+	if !IsSigned(data) {
+		return data, nil
+	}
+
+	_, content, _, err := split(data)
+
+	return content, err
+}
+
+// split returns the signed part of an envelope, the data in it and its
+// signature.
+func split(data []byte) (signed, content, signature []byte, err error) {
+	if !IsSigned(data) {
+		return nil, nil, nil, ErrUnsigned
+	}
+
+	header := len(envelopeMagic) + 1
+	if len(data) < header || data[header-1] != version {
+		return nil, nil, nil, fmt.Errorf("%w: unsupported envelope", ErrInvalidSignature)
+	}
+
+	length, n := binary.Uvarint(data[header:])
+	if n <= 0 || length > uint64(len(data)-header-n) || uint64(len(data)-header-n)-length != ed25519.SignatureSize {
+		return nil, nil, nil, fmt.Errorf("%w: truncated envelope", ErrInvalidSignature)
+	}
+
+	end := header + n + int(length)
+
+	return data[:end], data[header+n : end], data[end:], nil
+}
+
+// SignDetached returns the detached signature of data with key.
+func SignDetached(key ed25519.PrivateKey, data []byte) []byte {
+	signature := append([]byte(detachedMagic), version)
+
+	return append(signature, ed25519.Sign(key, detachedMessage(data))...)
+}
+
+// VerifyDetached verifies the detached signature of data with key. It fails
+// with ErrInvalidSignature if data was not signed by key or was modified after.
+func VerifyDetached(key ed25519.PublicKey, data, signature []byte) error {
+	header := len(detachedMagic) + 1
+	if len(signature) != header+ed25519.SignatureSize || string(signature[:header-1]) != detachedMagic ||
+		signature[header-1] != version {
+		return fmt.Errorf("%w: malformed detached signature", ErrInvalidSignature)
+	}
+
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: public key has %d bytes", ErrInvalidKey, len(key))
+	}
+
+	if !ed25519.Verify(key, detachedMessage(data), signature[header:]) {
+		return fmt.Errorf("%w: data was not signed by the key", ErrInvalidSignature)
+	}
+
+	return nil
+}
+
+func detachedMessage(data []byte) []byte {
+	message := make([]byte, 0, len(detachedMagic)+1+len(data))
+	message = append(message, detachedMagic...)
+	message = append(message, version)
+
+	return append(message, data...)
+}
+
+// MarshalPrivateKey encodes key as PKCS #8 in a PEM block.
+func MarshalPrivateKey(key ed25519.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: privateKeyType, Bytes: der}), nil
+}
+
+// ParsePrivateKey decodes a key encoded by MarshalPrivateKey.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	der, err := decodePEM(data, privateKeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: not an ed25519 private key", ErrInvalidKey)
+	}
+
+	return private, nil
+}
+
+// MarshalPublicKey encodes key as PKIX in a PEM block.
+func MarshalPublicKey(key ed25519.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: publicKeyType, Bytes: der}), nil
+}
+
+// ParsePublicKey decodes a key encoded by MarshalPublicKey.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	der, err := decodePEM(data, publicKeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: not an ed25519 public key", ErrInvalidKey)
+	}
+
+	return public, nil
+}
+
+func decodePEM(data []byte, blockType string) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block found", ErrInvalidKey)
+	}
+
+	if block.Type != blockType {
+		return nil, fmt.Errorf("%w: unexpected PEM block %q, expected %q", ErrInvalidKey, block.Type, blockType)
+	}
+
+	return block.Bytes, nil
+}
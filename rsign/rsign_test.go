@@ -0,0 +1,194 @@
+package rsign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+func generateKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	public, private, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return public, private
+}
+
+// encodedDelta returns a serialized delta to sign.
+func encodedDelta(t *testing.T) []byte {
+	s, err := rdiff.NewSigner(rdiff.Options{ChunkSize: 4}).Sign(strings.NewReader("hello world, hello there"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delta, err := rdiff.NewDiffer(s, rdiff.Options{}).Diff(strings.NewReader("hello there, hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err = delta.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestSignOpen(t *testing.T) {
+	public, private := generateKey(t)
+	data := encodedDelta(t)
+
+	envelope := Sign(private, data)
+	if !IsSigned(envelope) || IsSigned(data) {
+		t.Errorf("unexpected IsSigned result")
+	}
+
+	opened, err := Open(public, envelope)
+	if err != nil {
+		t.Fatalf("unexpected error opening envelope: %v", err)
+	}
+
+	if !bytes.Equal(opened, data) {
+		t.Errorf("unexpected data, got %x, expected %x", opened, data)
+	}
+
+	if _, err = rdiff.ReadDelta(bytes.NewReader(opened)); err != nil {
+		t.Errorf("unexpected error reading delta: %v", err)
+	}
+
+	for _, unwrapped := range [][]byte{envelope, data} {
+		if content, err := Unwrap(unwrapped); err != nil || !bytes.Equal(content, data) {
+			t.Errorf("unexpected unwrap result %x, %v", content, err)
+		}
+	}
+
+	if empty, err := Open(public, Sign(private, nil)); err != nil || len(empty) != 0 {
+		t.Errorf("unexpected result opening empty envelope %x, %v", empty, err)
+	}
+}
+
+func TestOpenRefuses(t *testing.T) {
+	public, private := generateKey(t)
+	other, _ := generateKey(t)
+	data := encodedDelta(t)
+	envelope := Sign(private, data)
+
+	if _, err := Open(public, data); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("unsigned: unexpected error, got %v, expected %v", err, ErrUnsigned)
+	}
+
+	if _, err := Open(other, envelope); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("other key: unexpected error, got %v, expected %v", err, ErrInvalidSignature)
+	}
+
+	if _, err := Open(public[:10], envelope); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("short key: unexpected error, got %v, expected %v", err, ErrInvalidKey)
+	}
+
+	// Flipping any bit of the envelope must be detected. Changes of the magic
+	// make it look unsigned.
+	for i := range envelope {
+		for _, bit := range []byte{0x01, 0x80} {
+			tampered := append([]byte(nil), envelope...)
+			tampered[i] ^= bit
+
+			_, err := Open(public, tampered)
+			if !errors.Is(err, ErrInvalidSignature) && !(i < len(envelopeMagic) && errors.Is(err, ErrUnsigned)) {
+				t.Fatalf("byte %d: unexpected error, got %v, expected %v", i, err, ErrInvalidSignature)
+			}
+		}
+	}
+
+	for _, n := range []int{len(envelopeMagic), len(envelopeMagic) + 1, len(envelope) - 1} {
+		if _, err := Open(public, envelope[:n]); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("truncated to %d: unexpected error, got %v, expected %v", n, err, ErrInvalidSignature)
+		}
+	}
+
+	if _, err := Open(public, append(envelope, 0)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("appended: unexpected error, got %v, expected %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestDetached(t *testing.T) {
+	public, private := generateKey(t)
+	other, _ := generateKey(t)
+	data := encodedDelta(t)
+
+	signature := SignDetached(private, data)
+	if err := VerifyDetached(public, data, signature); err != nil {
+		t.Fatalf("unexpected error verifying: %v", err)
+	}
+
+	if err := VerifyDetached(other, data, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("other key: unexpected error, got %v, expected %v", err, ErrInvalidSignature)
+	}
+
+	for i := range data {
+		tampered := append([]byte(nil), data...)
+		tampered[i] ^= 0x01
+		if err := VerifyDetached(public, tampered, signature); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("data byte %d: unexpected error, got %v, expected %v", i, err, ErrInvalidSignature)
+		}
+	}
+
+	for i := range signature {
+		tampered := append([]byte(nil), signature...)
+		tampered[i] ^= 0x01
+		if err := VerifyDetached(public, data, tampered); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("signature byte %d: unexpected error, got %v, expected %v", i, err, ErrInvalidSignature)
+		}
+	}
+
+	// The signature of an envelope is no detached signature of its data.
+	envelope := Sign(private, data)
+	forged := append([]byte(detachedMagic+"\x01"), envelope[len(envelope)-ed25519.SignatureSize:]...)
+	if err := VerifyDetached(public, envelope[:len(envelope)-ed25519.SignatureSize], forged); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("forged: unexpected error, got %v, expected %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestKeyEncoding(t *testing.T) {
+	public, private := generateKey(t)
+
+	encodedPrivate, err := MarshalPrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodedPublic, err := MarshalPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decodedPrivate, err := ParsePrivateKey(encodedPrivate)
+	if err != nil {
+		t.Fatalf("unexpected error parsing private key: %v", err)
+	}
+
+	decodedPublic, err := ParsePublicKey(encodedPublic)
+	if err != nil {
+		t.Fatalf("unexpected error parsing public key: %v", err)
+	}
+
+	if !decodedPrivate.Equal(private) || !decodedPublic.Equal(public) {
+		t.Errorf("decoded keys differ")
+	}
+
+	if _, err = ParsePrivateKey(encodedPublic); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidKey)
+	}
+
+	if _, err = ParsePublicKey(encodedPrivate); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidKey)
+	}
+
+	if _, err = ParsePublicKey([]byte("garbage")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidKey)
+	}
+}
@@ -0,0 +1,183 @@
+package rstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rfile"
+)
+
+// Add stores the content of r as the next version of the named file. If the
+// content did not change since the last version, that version is returned
+// along with ErrUnchanged.
+// Versions are stored as delta to the previous version, unless the previous
+// version is the end of a chain of SnapshotInterval deltas or the delta would
+// not be smaller than the content.
+func (s *Store) Add(ctx context.Context, name string, r io.Reader) (Version, error) {
+	dir, err := s.fileDir(name)
+	if err != nil {
+		return Version{}, err
+	}
+
+	versions, err := s.Log(name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Version{}, err
+	}
+
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return Version{}, err
+	}
+
+	content, version, err := stage(ctx, dir, r)
+	if err != nil {
+		return Version{}, err
+	}
+	defer func() {
+		_ = content.Close()
+		_ = os.Remove(content.Name())
+	}()
+
+	var last Version
+	if len(versions) > 0 {
+		last = versions[len(versions)-1]
+		if last.SHA256 == version.SHA256 && last.Size == version.Size {
+			return last, ErrUnchanged
+		}
+	}
+
+	version.Version = last.Version + 1
+	version.Time = time.Now().UTC()
+
+	stored := false
+	if len(versions) > 0 && deltasSinceSnapshot(versions) < s.settings.SnapshotInterval {
+		if version.Stored, stored, err = s.storeDelta(ctx, dir, versions, content, version); err != nil {
+			return Version{}, err
+		}
+	}
+
+	if !stored {
+		if err = content.Close(); err != nil {
+			return Version{}, err
+		}
+
+		version.Snapshot = true
+		version.Stored = version.Size
+		if err = os.Rename(content.Name(), dataPath(dir, version)); err != nil {
+			return Version{}, err
+		}
+	}
+
+	if err = rfile.WriteJSON(filepath.Join(dir, logName), append(versions, version)); err != nil {
+		return Version{}, err
+	}
+
+	s.config.Logger.WithField("file", name).Debugf("added version %d, snapshot %t, %d bytes stored", version.Version, version.Snapshot, version.Stored)
+
+	return version, nil
+}
+
+// stage copies r to a temporary file in dir and returns it along with the
+// size and digest of the content.
+func stage(ctx context.Context, dir string, r io.Reader) (*os.File, Version, error) {
+	content, err := os.CreateTemp(dir, ".add-*")
+	if err != nil {
+		return nil, Version{}, err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(content, hash), r)
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		_ = content.Close()
+		_ = os.Remove(content.Name())
+
+		return nil, Version{}, &rdiff.SourceError{Source: "updated", Op: "read", Err: err}
+	}
+
+	return content, Version{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// deltasSinceSnapshot returns the length of the chain of deltas at the end of
+// versions.
+func deltasSinceSnapshot(versions []Version) int {
+	n := 0
+	for i := len(versions) - 1; i >= 0 && !versions[i].Snapshot; i-- {
+		n++
+	}
+
+	return n
+}
+
+// storeDelta writes the delta from the last of versions to content. It returns
+// false if a snapshot should be stored instead.
+func (s *Store) storeDelta(ctx context.Context, dir string, versions []Version, content *os.File, version Version) (int64, bool, error) {
+	previous, err := os.CreateTemp(dir, ".previous-*")
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		_ = previous.Close()
+		_ = os.Remove(previous.Name())
+	}()
+
+	if err = s.checkout(ctx, dir, versions, versions[len(versions)-1].Version, previous); err != nil {
+		return 0, false, err
+	}
+
+	options := rdiff.Options{ChunkSize: s.settings.ChunkSize}
+	if _, err = previous.Seek(0, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+
+	signature, err := rdiff.NewSigner(options).SignHashes(ctx, previous)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+
+	delta, err := rdiff.NewDiffer(signature, options).DiffContext(ctx, content)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Weak hash collisions can produce a wrong delta, make sure it reproduces
+	// the content before relying on it.
+	hash := sha256.New()
+	if err = rdiff.Apply(previous, delta, hash); err != nil && !errors.Is(err, rdiff.ErrVerificationFailed) {
+		return 0, false, err
+	}
+
+	if err != nil || hex.EncodeToString(hash.Sum(nil)) != version.SHA256 {
+		s.config.Logger.WithField("version", version.Version).Warnln("delta does not reproduce the content, storing a snapshot")
+		return 0, false, nil
+	}
+
+	var stored int64
+	name := dataPath(dir, version)
+	err = rfile.WriteFile(name, 0o644, func(f *os.File) error {
+		stored, err = delta.WriteTo(f)
+		return err
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	if stored >= version.Size {
+		return 0, false, os.Remove(name)
+	}
+
+	return stored, true, nil
+}
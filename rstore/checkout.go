@@ -0,0 +1,119 @@
+package rstore
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+// Checkout writes the given version of the named file to w. The content is
+// verified against the digest it was added with after it has been written, w
+// must discard it if ErrVerificationFailed is returned.
+func (s *Store) Checkout(ctx context.Context, name string, version int, w io.Writer) error {
+	dir, err := s.fileDir(name)
+	if err != nil {
+		return err
+	}
+
+	versions, err := s.Log(name)
+	if err != nil {
+		return err
+	}
+
+	return s.checkout(ctx, dir, versions, version, w)
+}
+
+// checkout reconstructs version from the closest snapshot before it, applying
+// the deltas in between composed into one.
+func (s *Store) checkout(ctx context.Context, dir string, versions []Version, version int, w io.Writer) error {
+	i := sort.Search(len(versions), func(i int) bool {
+		return versions[i].Version >= version
+	})
+	if i == len(versions) || versions[i].Version != version {
+		return fmt.Errorf("%w: version %d", ErrNotFound, version)
+	}
+
+	base := i
+	for base >= 0 && !versions[base].Snapshot {
+		base--
+	}
+
+	if base < 0 {
+		return fmt.Errorf("%w: no snapshot before version %d", rdiff.ErrCorruptInput, version)
+	}
+
+	snapshot, err := os.Open(dataPath(dir, versions[base]))
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	hash := sha256.New()
+	buffered := bufio.NewWriter(io.MultiWriter(w, hash))
+	if base == i {
+		_, err = io.Copy(buffered, snapshot)
+	} else {
+		var delta rdiff.Delta
+		if delta, err = composeChain(ctx, dir, versions[base+1:i+1]); err == nil {
+			err = rdiff.Apply(snapshot, delta, buffered)
+		}
+	}
+
+	if err == nil {
+		err = buffered.Flush()
+	}
+
+	if err != nil && !errors.Is(err, rdiff.ErrVerificationFailed) {
+		return err
+	}
+
+	if err != nil || hex.EncodeToString(hash.Sum(nil)) != versions[i].SHA256 {
+		return fmt.Errorf("%w: version %d", ErrVerificationFailed, version)
+	}
+
+	return nil
+}
+
+// composeChain reads the deltas of versions and composes them into one.
+func composeChain(ctx context.Context, dir string, versions []Version) (rdiff.Delta, error) {
+	var composed rdiff.Delta
+	for i, version := range versions {
+		if err := ctx.Err(); err != nil {
+			return composed, err
+		}
+
+		delta, err := readDelta(dataPath(dir, version))
+		if err != nil {
+			return composed, err
+		}
+
+		if i == 0 {
+			composed = delta
+			continue
+		}
+
+		if composed, err = rdiff.Compose(composed, delta); err != nil {
+			return composed, fmt.Errorf("version %d: %w", version.Version, err)
+		}
+	}
+
+	return composed, nil
+}
+
+func readDelta(name string) (rdiff.Delta, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return rdiff.Delta{}, err
+	}
+	defer file.Close()
+
+	return rdiff.ReadDelta(bufio.NewReader(file))
+}
@@ -0,0 +1,72 @@
+package rstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sol1du2/rdetective/rfile"
+)
+
+// Prune drops all but the newest keep versions of the named file and returns
+// the dropped versions. If the oldest kept version is a delta, it is rebased
+// into a snapshot first. The log is only updated once the kept versions no
+// longer depend on dropped ones, and their data is removed last.
+func (s *Store) Prune(ctx context.Context, name string, keep int) ([]Version, error) {
+	if keep < 1 {
+		return nil, fmt.Errorf("%w: must keep at least one version, not %d", ErrInvalidArgument, keep)
+	}
+
+	dir, err := s.fileDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := s.Log(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(versions) <= keep {
+		return nil, nil
+	}
+
+	dropped := versions[:len(versions)-keep]
+	kept := append([]Version{}, versions[len(versions)-keep:]...)
+
+	var stale []string
+	if !kept[0].Snapshot {
+		rebased := kept[0]
+		rebased.Snapshot = true
+		rebased.Stored = rebased.Size
+
+		err = rfile.WriteFile(dataPath(dir, rebased), 0o644, func(f *os.File) error {
+			return s.checkout(ctx, dir, versions, rebased.Version, f)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to rebase version %d: %w", rebased.Version, err)
+		}
+
+		stale = append(stale, dataPath(dir, kept[0]))
+		kept[0] = rebased
+	}
+
+	if err = rfile.WriteJSON(filepath.Join(dir, logName), kept); err != nil {
+		return nil, err
+	}
+
+	for _, version := range dropped {
+		stale = append(stale, dataPath(dir, version))
+	}
+
+	for _, file := range stale {
+		if removeErr := os.Remove(file); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+			err = removeErr
+		}
+	}
+
+	s.config.Logger.WithField("file", name).Debugf("pruned %d versions", len(dropped))
+
+	return dropped, err
+}
@@ -0,0 +1,252 @@
+package rstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+func getStore(t *testing.T, settings Settings) *Store {
+	s, err := Init(&Config{
+		Logger: &logrus.Logger{
+			Out:       io.Discard,
+			Formatter: &logrus.TextFormatter{},
+			Level:     logrus.DebugLevel,
+		},
+		Dir: filepath.Join(t.TempDir(), "store"),
+	}, settings)
+	if err != nil {
+		t.Fatalf("error initializing store: %s", err.Error())
+	}
+
+	return s
+}
+
+// history returns versions of a file, each a small edit of the previous one.
+func history(count int, seed int64) [][]byte {
+	r := rand.New(rand.NewSource(seed))
+	data := make([]byte, 8192)
+	r.Read(data)
+
+	var versions [][]byte
+	for i := 0; i < count; i++ {
+		at := r.Intn(len(data))
+		edit := make([]byte, 1+r.Intn(64))
+		r.Read(edit)
+		data = append(append(append([]byte{}, data[:at]...), edit...), data[at:]...)
+
+		versions = append(versions, data)
+	}
+
+	return versions
+}
+
+func add(t *testing.T, s *Store, name string, data []byte) Version {
+	version, err := s.Add(context.Background(), name, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error adding version: %s", err.Error())
+	}
+
+	return version
+}
+
+func checkout(t *testing.T, s *Store, name string, version int) []byte {
+	var buf bytes.Buffer
+	if err := s.Checkout(context.Background(), name, version, &buf); err != nil {
+		t.Fatalf("error checking out version %d: %s", version, err.Error())
+	}
+
+	return buf.Bytes()
+}
+
+func TestAddCheckout(t *testing.T) {
+	s := getStore(t, Settings{ChunkSize: 256, SnapshotInterval: 3})
+	versions := history(10, 1)
+
+	for i, data := range versions {
+		version := add(t, s, "dir/file", data)
+		if version.Version != i+1 {
+			t.Errorf("unexpected version, got %d, expected %d", version.Version, i+1)
+		}
+
+		if expected := i%4 == 0; version.Snapshot != expected {
+			t.Errorf("version %d: unexpected snapshot, got %t, expected %t", version.Version, version.Snapshot, expected)
+		}
+
+		if !version.Snapshot && version.Stored >= version.Size {
+			t.Errorf("version %d: unexpected stored size %d of %d bytes", version.Version, version.Stored, version.Size)
+		}
+	}
+
+	for i, data := range versions {
+		if checkedOut := checkout(t, s, "dir/file", i+1); !bytes.Equal(checkedOut, data) {
+			t.Errorf("unexpected content of version %d", i+1)
+		}
+	}
+
+	files, err := s.Files()
+	if err != nil {
+		t.Fatalf("error listing files: %s", err.Error())
+	}
+
+	if len(files) != 1 || files[0] != "dir/file" {
+		t.Errorf("unexpected files, got %v, expected %v", files, []string{"dir/file"})
+	}
+}
+
+func TestAddUnchanged(t *testing.T) {
+	s := getStore(t, Settings{ChunkSize: 256, SnapshotInterval: 3})
+	version := add(t, s, "file", []byte("hello"))
+
+	unchanged, err := s.Add(context.Background(), "file", bytes.NewReader([]byte("hello")))
+	if !errors.Is(err, ErrUnchanged) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrUnchanged)
+	}
+
+	if unchanged.Version != version.Version {
+		t.Errorf("unexpected version, got %d, expected %d", unchanged.Version, version.Version)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	s := getStore(t, Settings{ChunkSize: 128, SnapshotInterval: 5})
+	add(t, s, "file", []byte("hello"))
+
+	opened, err := Open(s.config)
+	if err != nil {
+		t.Fatalf("error opening store: %s", err.Error())
+	}
+
+	if opened.Settings() != s.Settings() {
+		t.Errorf("unexpected settings, got %v, expected %v", opened.Settings(), s.Settings())
+	}
+
+	if checkedOut := checkout(t, opened, "file", 1); string(checkedOut) != "hello" {
+		t.Errorf("unexpected content, got %q, expected %q", checkedOut, "hello")
+	}
+
+	if _, err = Init(s.config, s.Settings()); !errors.Is(err, ErrExists) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrExists)
+	}
+
+	if _, err = Open(&Config{Dir: t.TempDir()}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestInvalidArguments(t *testing.T) {
+	if _, err := Init(&Config{Dir: t.TempDir()}, Settings{ChunkSize: 0}); !errors.Is(err, rdiff.ErrInvalidChunkSize) {
+		t.Errorf("unexpected error, got %v, expected %v", err, rdiff.ErrInvalidChunkSize)
+	}
+
+	s := getStore(t, Settings{ChunkSize: 128, SnapshotInterval: 5})
+	for _, name := range []string{"", ".", ".."} {
+		if _, err := s.Add(context.Background(), name, bytes.NewReader(nil)); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%q: unexpected error, got %v, expected %v", name, err, ErrInvalidArgument)
+		}
+	}
+
+	add(t, s, "file", []byte("hello"))
+	if err := s.Checkout(context.Background(), "file", 2, io.Discard); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrNotFound)
+	}
+
+	if err := s.Checkout(context.Background(), "other", 1, io.Discard); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrNotFound)
+	}
+
+	if _, err := s.Prune(context.Background(), "file", 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrInvalidArgument)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s := getStore(t, Settings{ChunkSize: 256, SnapshotInterval: 4})
+	versions := history(8, 2)
+	for _, data := range versions {
+		add(t, s, "file", data)
+	}
+
+	// Version 6 is a delta and becomes a snapshot.
+	dropped, err := s.Prune(context.Background(), "file", 3)
+	if err != nil {
+		t.Fatalf("error pruning: %s", err.Error())
+	}
+
+	if len(dropped) != 5 {
+		t.Errorf("unexpected number of dropped versions, got %d, expected %d", len(dropped), 5)
+	}
+
+	log, err := s.Log("file")
+	if err != nil {
+		t.Fatalf("error reading log: %s", err.Error())
+	}
+
+	if len(log) != 3 || log[0].Version != 6 || !log[0].Snapshot {
+		t.Fatalf("unexpected log after pruning %v", log)
+	}
+
+	for i := 6; i <= 8; i++ {
+		if checkedOut := checkout(t, s, "file", i); !bytes.Equal(checkedOut, versions[i-1]) {
+			t.Errorf("unexpected content of version %d", i)
+		}
+	}
+
+	if err = s.Checkout(context.Background(), "file", 5, io.Discard); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrNotFound)
+	}
+
+	// Only the data of the kept versions is left.
+	entries, err := os.ReadDir(filepath.Join(s.config.Dir, filesDir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 4 {
+		t.Errorf("unexpected number of files left, got %d, expected %d", len(entries), 4)
+	}
+
+	// New versions continue the history.
+	if version := add(t, s, "file", versions[0]); version.Version != 9 {
+		t.Errorf("unexpected version, got %d, expected %d", version.Version, 9)
+	}
+}
+
+func TestCheckoutCorrupt(t *testing.T) {
+	s := getStore(t, Settings{ChunkSize: 256, SnapshotInterval: 4})
+	versions := history(2, 3)
+	for _, data := range versions {
+		add(t, s, "file", data)
+	}
+
+	snapshot := filepath.Join(s.config.Dir, filesDir, "file", "1"+snapshotExtension)
+	data, err := os.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[0] ^= 0xff
+	if err = os.WriteFile(snapshot, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []int{1, 2} {
+		if err = s.Checkout(context.Background(), "file", version, io.Discard); !errors.Is(err, ErrVerificationFailed) {
+			t.Errorf("version %d: unexpected error, got %v, expected %v", version, err, ErrVerificationFailed)
+		}
+	}
+
+	// A version cannot be added on top of a corrupt one.
+	if _, err = s.Add(context.Background(), "file", bytes.NewReader(versions[0])); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrVerificationFailed)
+	}
+}
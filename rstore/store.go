@@ -0,0 +1,193 @@
+// Package rstore keeps the history of files as a snapshot followed by a chain
+// of deltas, each against the previous version.
+//
+// A store is an rfile store with its settings in store.json and a directory
+// per file below files, which holds the log of its versions (log.json) and
+// their data, either a full snapshot (<version>.snapshot) or a delta to the
+// previous version (<version>.delta).
+package rstore
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rfile"
+)
+
+const (
+	settingsName = "store.json"
+	filesDir     = "files"
+	logName      = "log.json"
+
+	snapshotExtension = ".snapshot"
+	deltaExtension    = ".delta"
+)
+
+var (
+	// ErrInvalidArgument is returned for invalid settings, file names and
+	// version counts.
+	ErrInvalidArgument = rfile.ErrInvalidArgument
+	// ErrNotFound is returned for stores, files and versions that do not exist.
+	ErrNotFound = rfile.ErrNotFound
+	// ErrExists is returned when initializing a store in a directory that
+	// already has one.
+	ErrExists = rfile.ErrExists
+	// ErrUnchanged is returned when adding a file that did not change since its
+	// last version.
+	ErrUnchanged = errors.New("unchanged")
+	// ErrVerificationFailed is returned when a checked out version does not
+	// match the digest it was added with. It wraps rdiff.ErrVerificationFailed.
+	ErrVerificationFailed = fmt.Errorf("%w", rdiff.ErrVerificationFailed)
+)
+
+// Settings are chosen when a store is initialized and apply to all its files.
+type Settings struct {
+	// ChunkSize of the signatures deltas are generated from.
+	ChunkSize int `json:"chunk_size"`
+	// SnapshotInterval is the maximum number of deltas that follow a snapshot,
+	// which caps the chains that have to be applied on checkout. With 0 every
+	// version is a snapshot.
+	SnapshotInterval int `json:"snapshot_interval"`
+}
+
+func (s Settings) Validate() error {
+	if s.ChunkSize <= 0 || s.ChunkSize > rdiff.MaxChunkSize {
+		return fmt.Errorf("%w: %d", rdiff.ErrInvalidChunkSize, s.ChunkSize)
+	}
+
+	if s.SnapshotInterval < 0 {
+		return fmt.Errorf("%w: snapshot interval %d", ErrInvalidArgument, s.SnapshotInterval)
+	}
+
+	return nil
+}
+
+// Version describes a version of a file.
+type Version struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	// Snapshot is set if the version is stored in full, otherwise it is stored
+	// as delta to the previous version.
+	Snapshot bool `json:"snapshot"`
+	// Stored is the size of the snapshot or delta.
+	Stored int64 `json:"stored"`
+}
+
+type Config struct {
+	Logger logrus.FieldLogger
+
+	// Dir is the directory of the store.
+	Dir string
+}
+
+// Store is an opened store.
+type Store struct {
+	config   *Config
+	settings Settings
+}
+
+// Init creates an empty store in config.Dir, creating the directory if needed.
+func Init(config *Config, settings Settings) (*Store, error) {
+	err := rfile.InitStore(config.Dir, settingsName, settings, func() error {
+		return os.MkdirAll(filepath.Join(config.Dir, filesDir), 0o755)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		config:   config,
+		settings: settings,
+	}, nil
+}
+
+// Open opens the store in config.Dir.
+func Open(config *Config) (*Store, error) {
+	var settings Settings
+	if err := rfile.OpenStore(config.Dir, settingsName, &settings); err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		config:   config,
+		settings: settings,
+	}, nil
+}
+
+func (s *Store) Settings() Settings {
+	return s.settings
+}
+
+// Files returns the names of all files in the store, sorted.
+func (s *Store) Files() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.config.Dir, filesDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name, err := url.PathUnescape(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Log returns all versions of the named file, oldest first.
+func (s *Store) Log(name string) ([]Version, error) {
+	dir, err := s.fileDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	if err := rfile.ReadJSON(filepath.Join(dir, logName), &versions); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s is not in the store", ErrNotFound, name)
+		}
+
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// fileDir returns the directory of the named file. Names are slash separated
+// paths, they are stored escaped so that every file has a flat directory.
+func (s *Store) fileDir(name string) (string, error) {
+	cleaned := path.Clean(name)
+	if name == "" || cleaned == "." || cleaned == ".." {
+		return "", fmt.Errorf("%w: file name %q", ErrInvalidArgument, name)
+	}
+
+	return filepath.Join(s.config.Dir, filesDir, url.PathEscape(cleaned)), nil
+}
+
+// dataPath returns the path of the snapshot or delta of version in dir.
+func dataPath(dir string, version Version) string {
+	extension := deltaExtension
+	if version.Snapshot {
+		extension = snapshotExtension
+	}
+
+	return filepath.Join(dir, strconv.Itoa(version.Version)+extension)
+}
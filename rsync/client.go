@@ -0,0 +1,190 @@
+package rsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rfile"
+)
+
+type ClientConfig struct {
+	Logger    logrus.FieldLogger
+	ChunkSize int
+
+	// Tree syncs in tree mode, which compares tree signatures of both files
+	// and only exchanges the subtrees that differ. It only finds chunks that
+	// are at the same position in both files, but needs far less traffic than
+	// a full signature if only a few chunks of a large file changed.
+	Tree bool
+}
+
+// Client is the receiving side of the protocol.
+type Client struct {
+	config *ClientConfig
+}
+
+func NewClient(config *ClientConfig) *Client {
+	return &Client{
+		config: config,
+	}
+}
+
+// Stats describes the outcome of a sync.
+type Stats struct {
+	// Size is the size of the synced file.
+	Size int64
+	// Literal is the amount of bytes that were sent as new data.
+	Literal int64
+	// Matched is the number of chunks reused from the local file.
+	Matched int
+	// Nodes is the number of tree nodes sent in tree mode.
+	Nodes int
+	// Full is set if the patched file did not verify and the whole file was
+	// transferred again.
+	Full bool
+}
+
+// Sync updates the file at local to the contents of the file at remote, using
+// the protocol over conn. A missing local file is created. The local file is
+// only replaced once the patched result is verified. If it does not verify the
+// whole file is transferred again.
+func (c *Client) Sync(ctx context.Context, conn io.ReadWriter, remote, local string) (Stats, error) {
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	original, err := os.Open(local)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Stats{}, &rdiff.SourceError{Source: "original", Op: "open", Err: err}
+	}
+
+	// A missing local file is synced like an empty one.
+	var base interface {
+		io.Reader
+		io.ReaderAt
+	} = bytes.NewReader(nil)
+	if original != nil {
+		defer original.Close()
+		base = original
+	}
+
+	reader := bufio.NewReader(conn)
+
+	var stats Stats
+	if c.config.Tree {
+		var tree *rdiff.TreeSignature
+		if tree, err = rdiff.NewSigner(c.options()).SignTree(ctx, base); err != nil {
+			return stats, err
+		}
+
+		stats, err = c.syncTree(ctx, conn, reader, remote, local, base, tree)
+	} else {
+		var signature rdiff.Signature
+		if signature, err = rdiff.NewSigner(c.options()).SignHashes(ctx, base); err != nil {
+			return stats, err
+		}
+
+		stats, err = c.syncDelta(conn, reader, remote, local, base, signature)
+	}
+
+	if !errors.Is(err, ErrVerificationFailed) {
+		return stats, err
+	}
+
+	// The local file changed while it was synced, or some chunks only matched
+	// by chance. The signature of an empty file makes the sender send it all.
+	c.config.Logger.WithError(err).Warnln("falling back to a full transfer")
+
+	stats, err = c.syncDelta(conn, reader, remote, local, base, rdiff.Signature{ChunkSize: c.config.ChunkSize})
+	stats.Full = true
+
+	return stats, err
+}
+
+// syncDelta syncs with signature, the receiver sends it and the sender answers
+// with the delta of its file.
+func (c *Client) syncDelta(conn io.Writer, reader *bufio.Reader, remote, local string, base io.ReaderAt, signature rdiff.Signature) (Stats, error) {
+	var stats Stats
+
+	request := append([]byte{protocolVersion}, remote...)
+	if err := writeFrame(conn, frameRequest, request); err != nil {
+		return stats, err
+	}
+
+	// The done frame verifies the result.
+	signature.Digest = nil
+
+	if err := writeFrameFrom(conn, frameSignature, signature); err != nil {
+		return stats, err
+	}
+
+	var delta rdiff.Delta
+	err := expectFrame(reader, unlimited, frameDelta, func(r io.Reader) (err error) {
+		delta, err = rdiff.ReadDelta(r)
+		return err
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	var digest []byte
+	err = expectFrame(reader, unlimited, frameDone, func(r io.Reader) (err error) {
+		stats.Size, digest, err = decodeDone(r)
+		return err
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	for _, change := range delta.Changes {
+		stats.Literal += int64(len(change.NewBytes))
+		if change.ChunkIndex < delta.ChunkCount {
+			stats.Matched++
+		}
+	}
+
+	return stats, patchFile(local, base, delta, stats.Size, digest)
+}
+
+func (c *Client) options() rdiff.Options {
+	return rdiff.Options{
+		Logger:    c.config.Logger,
+		ChunkSize: c.config.ChunkSize,
+	}
+}
+
+// patchFile applies delta to base into a temporary file next to local, and
+// replaces local with it once size and digest are verified.
+func patchFile(local string, base io.ReaderAt, delta rdiff.Delta, size int64, digest []byte) error {
+	return rfile.WriteFile(local, 0o644, func(tmp *os.File) error {
+		hash := sha256.New()
+		counter := &countingWriter{}
+		writer := bufio.NewWriter(io.MultiWriter(tmp, hash, counter))
+
+		if err := rdiff.Apply(base, delta, writer); err != nil {
+			if errors.Is(err, rdiff.ErrVerificationFailed) {
+				return fmt.Errorf("%w: patched file does not match the remote file", ErrVerificationFailed)
+			}
+
+			return err
+		}
+
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+
+		if counter.n != size || !bytes.Equal(hash.Sum(nil), digest) {
+			return fmt.Errorf("%w: patched file does not match the remote file", ErrVerificationFailed)
+		}
+
+		return nil
+	})
+}
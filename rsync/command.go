@@ -0,0 +1,62 @@
+package rsync
+
+import (
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// CommandConn runs the protocol over the stdin and stdout of a child process,
+// e.g. `ssh host rdetective server --stdio`.
+type CommandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// StartCommand starts cmd and connects to its stdin and stdout, which must not
+// be set yet. Close must be called to wait for the command to exit.
+func StartCommand(cmd *exec.Cmd) (*CommandConn, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		_ = stdin.Close()
+		return nil, err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &CommandConn{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: stdout,
+	}, nil
+}
+
+func (c *CommandConn) Read(p []byte) (int, error) {
+	return c.stdout.Read(p)
+}
+
+func (c *CommandConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// Close closes stdin of the command and waits for it to exit. It is safe to
+// call Close more than once.
+func (c *CommandConn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.stdin.Close()
+		c.closeErr = c.cmd.Wait()
+	})
+
+	return c.closeErr
+}
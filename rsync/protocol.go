@@ -0,0 +1,196 @@
+// Package rsync implements an rsync style protocol to sync a file: the
+// receiver sends the signature of its local copy, the sender answers with the
+// delta of its own copy and the receiver patches and verifies the local file.
+//
+// The protocol runs over any io.ReadWriter. Every message is a frame made of a
+// type byte, the payload length as unsigned varint and the payload:
+//
+//	receiver -> sender: request (version, path), signature
+//	sender -> receiver: delta, done (size, sha256) or error (message)
+//
+// With version 2 the receiver sends the chunk size of a tree signature (see
+// rdiff.TreeSignature) instead of a signature. The sender then walks down its
+// own tree, asking the receiver for the hashes of the nodes it needs on every
+// level, and only descends into subtrees that differ. Finally it sends the
+// chunks that differ:
+//
+//	receiver -> sender: request (version, path), tree (chunk size)
+//	sender -> receiver: query (level, indexes)
+//	receiver -> sender: nodes (hashes)
+//	...
+//	sender -> receiver: chunks (indexes, data), done (size, sha256) or error
+//
+// After the done frame the receiver can send another request on the same
+// connection. Receivers use that to fall back to a full transfer, with the
+// signature of an empty file, if the patched file does not match the file of
+// the sender.
+package rsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+const (
+	protocolVersion     = 1
+	treeProtocolVersion = 2
+)
+
+const (
+	frameRequest   byte = 'R'
+	frameSignature byte = 'S'
+	frameDelta     byte = 'D'
+	frameDone      byte = 'F'
+	frameError     byte = 'E'
+	frameTree      byte = 'T'
+	frameQuery     byte = 'Q'
+	frameNodes     byte = 'N'
+	frameChunks    byte = 'C'
+)
+
+// DefaultMaxFrameSize is used if ServerConfig.MaxFrameSize is not set. Like
+// rhttp.DefaultMaxPartSize, it fits the binary signature of a 3 GiB file with
+// 1 KiB chunks.
+const DefaultMaxFrameSize = 64 << 20
+
+// unlimited is the maximum frame size of receivers, which trust their sender
+// and receive deltas of any size.
+const unlimited = math.MaxInt64
+
+var (
+	// ErrProtocol is returned when the peer does not follow the protocol.
+	ErrProtocol = errors.New("protocol error")
+	// ErrVerificationFailed is returned when the patched file does not match
+	// the file of the sender. It wraps rdiff.ErrVerificationFailed.
+	ErrVerificationFailed = fmt.Errorf("%w", rdiff.ErrVerificationFailed)
+)
+
+// RemoteError is an error reported by the sender.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "remote: " + e.Message
+}
+
+func writeFrame(w io.Writer, frameType byte, payload []byte) error {
+	if err := writeFrameHeader(w, frameType, int64(len(payload))); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload)
+
+	return err
+}
+
+// writeFrameHeader writes the type and payload size of a frame, for payloads
+// that are written separately.
+func writeFrameHeader(w io.Writer, frameType byte, size int64) error {
+	header := make([]byte, 1+binary.MaxVarintLen64)
+	header[0] = frameType
+	n := binary.PutUvarint(header[1:], uint64(size))
+
+	_, err := w.Write(header[:1+n])
+
+	return err
+}
+
+// writeFrameFrom writes a frame with the payload serialized by writerTo.
+func writeFrameFrom(w io.Writer, frameType byte, writerTo io.WriterTo) error {
+	var payload bytes.Buffer
+	if _, err := writerTo.WriteTo(&payload); err != nil {
+		return err
+	}
+
+	return writeFrame(w, frameType, payload.Bytes())
+}
+
+// readFrame reads the header of the next frame, which must not be larger than
+// maxSize. The returned reader is limited to the payload and must be drained
+// before the next frame is read.
+func readFrame(r *bufio.Reader, maxSize int64) (byte, *io.LimitedReader, error) {
+	frameType, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrProtocol, err)
+	}
+
+	// The payload is not drained, the peer is not expected to send it anyway.
+	if size > uint64(maxSize) {
+		return 0, nil, fmt.Errorf("%w: frame %q of %d bytes exceeds %d", ErrProtocol, frameType, size, maxSize)
+	}
+
+	return frameType, &io.LimitedReader{R: r, N: int64(size)}, nil
+}
+
+// expectFrame reads the next frame, of at most maxSize bytes, and decodes its
+// payload with decode. Error frames are returned as RemoteError.
+func expectFrame(r *bufio.Reader, maxSize int64, expected byte, decode func(io.Reader) error) error {
+	_, err := expectFrames(r, maxSize, map[byte]func(io.Reader) error{expected: decode})
+
+	return err
+}
+
+// expectFrames is like expectFrame for a frame of any of the types in decoders,
+// it returns the type of the frame that was read.
+func expectFrames(r *bufio.Reader, maxSize int64, decoders map[byte]func(io.Reader) error) (byte, error) {
+	frameType, payload, err := readFrame(r, maxSize)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return 0, err
+	}
+
+	if decode, ok := decoders[frameType]; ok {
+		err = decode(payload)
+	} else if frameType == frameError {
+		var message []byte
+		if message, err = io.ReadAll(payload); err == nil {
+			err = &RemoteError{Message: string(message)}
+		}
+	} else {
+		err = fmt.Errorf("%w: unexpected frame %q", ErrProtocol, frameType)
+	}
+
+	// Drain whatever the decoder did not consume.
+	if _, drainErr := io.Copy(io.Discard, payload); err == nil {
+		err = drainErr
+	}
+
+	return frameType, err
+}
+
+// closeOnDone closes conn once ctx is done, which unblocks pending reads and
+// writes, if conn can be closed. The returned function stops the watch.
+func closeOnDone(ctx context.Context, conn interface{}) func() {
+	closer, ok := conn.(io.Closer)
+	if !ok {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = closer.Close()
+		case <-stop:
+		}
+	}()
+
+	return func() { close(stop) }
+}
@@ -0,0 +1,429 @@
+package rsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+func getLogger() logrus.FieldLogger {
+	return &logrus.Logger{
+		Out:       io.Discard,
+		Formatter: &logrus.TextFormatter{},
+		Level:     logrus.DebugLevel,
+	}
+}
+
+// startServer serves root on a loopback address until the test ends.
+func startServer(t *testing.T, root string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(&ServerConfig{Logger: getLogger(), Root: root}).Serve(ctx, listener)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("error serving: %s", err.Error())
+		}
+	})
+
+	return listener.Addr().String()
+}
+
+func syncFile(t *testing.T, addr, remote, local string) (Stats, error) {
+	return syncFileWith(t, &ClientConfig{Logger: getLogger(), ChunkSize: 64}, addr, remote, local)
+}
+
+func syncFileWith(t *testing.T, config *ClientConfig, addr, remote, local string) (Stats, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("error connecting: %s", err.Error())
+	}
+	defer conn.Close()
+
+	return NewClient(config).Sync(context.Background(), conn, remote, local)
+}
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+
+	return data
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkFile(t *testing.T, name string, expected []byte) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, expected) {
+		t.Errorf("unexpected content of %s, got %d bytes, expected %d bytes", name, len(data), len(expected))
+	}
+}
+
+func TestSyncLoopback(t *testing.T) {
+	root := t.TempDir()
+	local := t.TempDir()
+
+	updated := randomData(64*1024, 1)
+	writeFile(t, filepath.Join(root, "file"), updated)
+
+	original := append([]byte{}, updated[:20000]...)
+	original = append(original, randomData(100, 2)...)
+	original = append(original, updated[30000:]...)
+
+	tests := []struct {
+		name     string
+		original []byte
+		remote   []byte
+	}{
+		{"modified", original, updated},
+		{"identical", updated, updated},
+		{"missing", nil, updated},
+		{"truncate", updated, []byte{}},
+	}
+
+	addr := startServer(t, root)
+
+	for _, test := range tests {
+		remoteName := filepath.Join(root, test.name)
+		writeFile(t, remoteName, test.remote)
+
+		localName := filepath.Join(local, test.name)
+		if test.original != nil {
+			writeFile(t, localName, test.original)
+		}
+
+		stats, err := syncFile(t, addr, test.name, localName)
+		if err != nil {
+			t.Fatalf("%s: error syncing: %s", test.name, err.Error())
+		}
+
+		checkFile(t, localName, test.remote)
+
+		if stats.Size != int64(len(test.remote)) {
+			t.Errorf("%s: unexpected size, got %d, expected %d", test.name, stats.Size, len(test.remote))
+		}
+
+		if test.original != nil && len(test.remote) > 0 && stats.Literal > int64(len(test.remote))/2 {
+			t.Errorf("%s: too much data transferred, %d of %d bytes", test.name, stats.Literal, len(test.remote))
+		}
+	}
+}
+
+func TestSyncTree(t *testing.T) {
+	root := t.TempDir()
+	local := t.TempDir()
+
+	original := randomData(64*1024, 1)
+	modified := append([]byte{}, original...)
+	copy(modified[30000:], randomData(10, 2))
+
+	tests := []struct {
+		name     string
+		original []byte
+		remote   []byte
+		literal  int64
+	}{
+		{"modified", original, modified, 64},
+		{"identical", original, original, 0},
+		{"missing", nil, original, int64(len(original))},
+		{"truncate", original, original[:1000], 1000 - 960},
+		{"append", original[:1000], original[:5000], 5000 - 960},
+		{"empty", original, []byte{}, 0},
+	}
+
+	addr := startServer(t, root)
+	config := &ClientConfig{Logger: getLogger(), ChunkSize: 64, Tree: true}
+
+	for _, test := range tests {
+		writeFile(t, filepath.Join(root, test.name), test.remote)
+
+		localName := filepath.Join(local, test.name)
+		if test.original != nil {
+			writeFile(t, localName, test.original)
+		}
+
+		stats, err := syncFileWith(t, config, addr, test.name, localName)
+		if err != nil {
+			t.Fatalf("%s: error syncing: %s", test.name, err.Error())
+		}
+
+		checkFile(t, localName, test.remote)
+
+		if stats.Literal != test.literal {
+			t.Errorf("%s: unexpected literal bytes, got %d, expected %d", test.name, stats.Literal, test.literal)
+		}
+	}
+
+	// A single changed chunk out of 1024 is found by comparing two nodes per
+	// level of the tree at most.
+	writeFile(t, filepath.Join(local, "modified"), original)
+	stats, err := syncFileWith(t, config, addr, "modified", filepath.Join(local, "modified"))
+	if err != nil {
+		t.Fatalf("error syncing: %s", err.Error())
+	}
+
+	if maximum := 2*rdiff.TreeHeight(1024) + 1; stats.Nodes > maximum {
+		t.Errorf("unexpected number of nodes, got %d, expected at most %d", stats.Nodes, maximum)
+	}
+}
+
+func TestServeTreeInvalidChunkSize(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "file"), []byte("hello world"))
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(&ServerConfig{Logger: getLogger(), Root: root}).ServeConn(context.Background(), server)
+	}()
+
+	go func() {
+		_ = writeFrame(client, frameRequest, append([]byte{treeProtocolVersion}, "file"...))
+		_ = writeFrame(client, frameTree, appendUvarint(appendUvarint(nil, 1<<40), 1))
+	}()
+
+	var remoteErr *RemoteError
+	if err := expectFrame(bufio.NewReader(client), unlimited, frameQuery, nil); !errors.As(err, &remoteErr) {
+		t.Errorf("unexpected error, got %v, expected remote error", err)
+	}
+
+	if err := <-done; !errors.Is(err, rdiff.ErrInvalidChunkSize) {
+		t.Errorf("unexpected error, got %v, expected %v", err, rdiff.ErrInvalidChunkSize)
+	}
+}
+
+func TestServeMaxFrameSize(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "file"), []byte("hello world"))
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(&ServerConfig{Logger: getLogger(), Root: root, MaxFrameSize: 64}).ServeConn(context.Background(), server)
+	}()
+
+	// Only the header of the signature frame is sent, the server must not wait
+	// for its payload.
+	go func() {
+		_ = writeFrame(client, frameRequest, append([]byte{protocolVersion}, "file"...))
+		_ = writeFrameHeader(client, frameSignature, 1<<40)
+	}()
+
+	var remoteErr *RemoteError
+	if err := expectFrame(bufio.NewReader(client), unlimited, frameDelta, nil); !errors.As(err, &remoteErr) {
+		t.Errorf("unexpected error, got %v, expected remote error", err)
+	}
+
+	if err := <-done; !errors.Is(err, ErrProtocol) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrProtocol)
+	}
+}
+
+func TestSyncRemoteErrors(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, filepath.Join(dir, "secret"), []byte("secret"))
+	if err := os.Symlink(filepath.Join(dir, "secret"), filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := startServer(t, root)
+	local := filepath.Join(dir, "local")
+	writeFile(t, local, []byte("unchanged"))
+
+	for _, remote := range []string{"missing", "../secret", "/", "", "link"} {
+		var remoteErr *RemoteError
+		if _, err := syncFile(t, addr, remote, local); !errors.As(err, &remoteErr) {
+			t.Errorf("%q: unexpected error, got %v, expected remote error", remote, err)
+		}
+
+		checkFile(t, local, []byte("unchanged"))
+	}
+}
+
+// tamperConn calls tamper before the first read, after the receiver sent its
+// signature.
+type tamperConn struct {
+	net.Conn
+	tamper func()
+}
+
+func (c *tamperConn) Read(p []byte) (int, error) {
+	if c.tamper != nil {
+		c.tamper()
+		c.tamper = nil
+	}
+
+	return c.Conn.Read(p)
+}
+
+func TestSyncFallback(t *testing.T) {
+	root := t.TempDir()
+	updated := randomData(64*1024, 1)
+	writeFile(t, filepath.Join(root, "file"), updated)
+	addr := startServer(t, root)
+
+	for _, tree := range []bool{false, true} {
+		local := filepath.Join(t.TempDir(), "file")
+		writeFile(t, local, updated)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("error connecting: %s", err.Error())
+		}
+
+		// The local file changes after it was signed, so the patched file
+		// does not verify.
+		stats, err := NewClient(&ClientConfig{Logger: getLogger(), ChunkSize: 64, Tree: tree}).Sync(context.Background(),
+			&tamperConn{Conn: conn, tamper: func() { writeFile(t, local, randomData(len(updated), 2)) }}, "file", local)
+		conn.Close()
+		if err != nil {
+			t.Fatalf("tree %t: error syncing: %s", tree, err.Error())
+		}
+
+		if !stats.Full || stats.Literal != int64(len(updated)) {
+			t.Errorf("tree %t: unexpected stats, got %+v, expected a full transfer", tree, stats)
+		}
+
+		checkFile(t, local, updated)
+	}
+}
+
+// panicListener returns a connection that panics when it is read first.
+type panicListener struct {
+	net.Listener
+	panicked bool
+}
+
+type panicConn struct {
+	net.Conn
+}
+
+func (c panicConn) Read([]byte) (int, error) {
+	panic("read")
+}
+
+func (l *panicListener) Accept() (net.Conn, error) {
+	if !l.panicked {
+		l.panicked = true
+		conn, _ := net.Pipe()
+
+		return panicConn{Conn: conn}, nil
+	}
+
+	return l.Listener.Accept()
+}
+
+func TestServeRecovers(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "file"), []byte("hello world"))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = NewServer(&ServerConfig{Logger: getLogger(), Root: root}).Serve(ctx, &panicListener{Listener: listener})
+	}()
+
+	local := filepath.Join(t.TempDir(), "file")
+	if _, err = syncFile(t, listener.Addr().String(), "file", local); err != nil {
+		t.Fatalf("error syncing after a panic: %s", err.Error())
+	}
+
+	checkFile(t, local, []byte("hello world"))
+}
+
+func TestPatchFileVerification(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "local")
+	writeFile(t, local, []byte("hello world"))
+
+	delta := rdiff.Delta{
+		ChunkSize:  4,
+		ChunkCount: 3,
+		Changes: []rdiff.DeltaChunk{
+			{ChunkIndex: 3, NewBytes: []byte("hello there")},
+		},
+	}
+	digest := sha256.Sum256([]byte("something else"))
+
+	err := patchFile(local, bytes.NewReader([]byte("hello world")), delta, 11, digest[:])
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrVerificationFailed)
+	}
+
+	checkFile(t, local, []byte("hello world"))
+
+	entries, err := os.ReadDir(filepath.Dir(local))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Errorf("temporary file left behind, got %d files", len(entries))
+	}
+}
+
+func TestServeStopsOnCancel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(&ServerConfig{Logger: getLogger(), Root: t.TempDir()}).Serve(ctx, listener)
+	}()
+
+	cancel()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("unexpected error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+}
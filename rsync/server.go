@@ -0,0 +1,241 @@
+package rsync
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+type ServerConfig struct {
+	Logger logrus.FieldLogger
+
+	// Root is the directory containing the files that can be synced. Requested
+	// paths are always resolved inside of it.
+	Root string
+
+	// MaxFrameSize bounds the size of the frames sent by receivers, which
+	// includes their signatures, as frames are decoded in memory.
+	// DefaultMaxFrameSize is used if it is not set.
+	MaxFrameSize int64
+}
+
+// Server is the sending side of the protocol.
+type Server struct {
+	config *ServerConfig
+}
+
+func NewServer(config *ServerConfig) *Server {
+	return &Server{
+		config: config,
+	}
+}
+
+// Serve accepts connections on listener and serves each of them in its own
+// goroutine until ctx is done.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	stop := closeOnDone(ctx, listener)
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+
+			logger := s.config.Logger.WithField("remote", conn.RemoteAddr().String())
+
+			// A bug triggered by one client must not take down the others.
+			defer func() {
+				if r := recover(); r != nil {
+					logger.WithField("panic", r).Errorf("sync panicked\n%s", debug.Stack())
+				}
+			}()
+
+			if err := s.ServeConn(ctx, conn); err != nil {
+				logger.WithError(err).Warnln("sync failed")
+			} else {
+				logger.Debugln("sync done")
+			}
+		}()
+	}
+}
+
+// ServeConn serves sync requests on conn until the receiver closes it.
+func (s *Server) ServeConn(ctx context.Context, conn io.ReadWriter) error {
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	reader := bufio.NewReader(conn)
+	for {
+		if _, err := reader.Peek(1); err == io.EOF {
+			return nil
+		}
+
+		if err := s.serveRequest(ctx, conn, reader); err != nil {
+			return err
+		}
+	}
+}
+
+// serveRequest serves a single sync request on conn.
+func (s *Server) serveRequest(ctx context.Context, conn io.ReadWriter, reader *bufio.Reader) error {
+	var requested string
+	var version byte
+	err := expectFrame(reader, s.maxFrameSize(), frameRequest, func(r io.Reader) error {
+		payload, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		if len(payload) == 0 || (payload[0] != protocolVersion && payload[0] != treeProtocolVersion) {
+			return fmt.Errorf("%w: unsupported protocol version", ErrProtocol)
+		}
+
+		version = payload[0]
+		requested = string(payload[1:])
+
+		return nil
+	})
+	if err != nil {
+		return s.fail(conn, err)
+	}
+
+	if version == treeProtocolVersion {
+		return s.serveTree(ctx, conn, reader, requested)
+	}
+
+	var signature rdiff.Signature
+	err = expectFrame(reader, s.maxFrameSize(), frameSignature, func(r io.Reader) (err error) {
+		signature, err = rdiff.ReadSignature(r)
+		return err
+	})
+	if err != nil {
+		return s.fail(conn, err)
+	}
+
+	file, err := s.open(requested)
+	if err != nil {
+		return s.fail(conn, err)
+	}
+	defer file.Close()
+
+	// Hash the file while diffing, so it only needs to be read once.
+	hash := sha256.New()
+	counter := &countingWriter{}
+	delta, err := rdiff.NewDiffer(signature, rdiff.Options{Logger: s.config.Logger}).
+		DiffContext(ctx, io.TeeReader(file, io.MultiWriter(hash, counter)))
+	if err != nil {
+		return s.fail(conn, err)
+	}
+
+	if err = writeFrameFrom(conn, frameDelta, delta); err != nil {
+		return err
+	}
+
+	return writeDone(conn, counter.n, hash.Sum(nil))
+}
+
+func (s *Server) maxFrameSize() int64 {
+	if s.config.MaxFrameSize <= 0 {
+		return DefaultMaxFrameSize
+	}
+
+	return s.config.MaxFrameSize
+}
+
+// open opens the requested file below Root.
+func (s *Server) open(requested string) (*os.File, error) {
+	s.config.Logger.WithField("path", requested).Debugln("sync requested")
+
+	name, err := s.resolve(requested)
+	if err == nil {
+		var file *os.File
+		if file, err = os.Open(name); err == nil {
+			return file, nil
+		}
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: no such file", requested)
+	}
+
+	return nil, err
+}
+
+// writeDone writes the done frame with the size and sha256 digest of the file.
+func writeDone(w io.Writer, size int64, digest []byte) error {
+	done := make([]byte, binary.MaxVarintLen64)
+	done = done[:binary.PutUvarint(done, uint64(size))]
+
+	return writeFrame(w, frameDone, append(done, digest...))
+}
+
+// resolve maps a requested path to a file below Root. The path is cleaned as
+// if it was absolute and symlinks are resolved, so it can never point outside
+// of Root.
+func (s *Server) resolve(requested string) (string, error) {
+	cleaned := path.Clean("/" + requested)
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid path %q", requested)
+	}
+
+	root, err := filepath.EvalSymlinks(s.config.Root)
+	if err != nil {
+		return "", err
+	}
+
+	name, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(cleaned)))
+	if err != nil {
+		return "", err
+	}
+
+	if rel, err := filepath.Rel(root, name); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: outside of the root", requested)
+	}
+
+	return name, nil
+}
+
+// fail reports err to the receiver and returns it.
+func (s *Server) fail(conn io.Writer, err error) error {
+	_ = writeFrame(conn, frameError, []byte(err.Error()))
+
+	return err
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+
+	return len(p), nil
+}
@@ -0,0 +1,418 @@
+package rsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rfile"
+)
+
+// maxTreeLevel bounds the levels of queries, trees of files of any size are
+// lower.
+const maxTreeLevel = 64
+
+// serveTree serves a sync request in tree mode, after the request frame.
+func (s *Server) serveTree(ctx context.Context, conn io.ReadWriter, reader *bufio.Reader, requested string) error {
+	var chunkSize, leaves int
+	err := expectFrame(reader, s.maxFrameSize(), frameTree, func(r io.Reader) (err error) {
+		br := bufio.NewReader(r)
+		if chunkSize, err = readInt(br); err != nil {
+			return err
+		}
+
+		leaves, err = readInt(br)
+
+		return err
+	})
+	if err != nil {
+		return s.fail(conn, err)
+	}
+
+	// The chunk size goes into allocations, check it before the file is even
+	// opened.
+	options := rdiff.Options{Logger: s.config.Logger, ChunkSize: chunkSize}
+	if err = options.Validate(); err != nil {
+		return s.fail(conn, err)
+	}
+
+	file, err := s.open(requested)
+	if err != nil {
+		return s.fail(conn, err)
+	}
+	defer file.Close()
+
+	// Hash the file while signing it, so it only needs to be read once.
+	hash := sha256.New()
+	tree, err := rdiff.NewSigner(options).SignTree(ctx, io.TeeReader(file, hash))
+	if err != nil {
+		return s.fail(conn, err)
+	}
+
+	level := tree.Height()
+	if height := rdiff.TreeHeight(leaves); height > level {
+		level = height
+	}
+
+	indexes := []int{0}
+	for ; level >= 0 && len(indexes) > 0; level-- {
+		if err = writeFrame(conn, frameQuery, encodeQuery(level, indexes)); err != nil {
+			return err
+		}
+
+		var hashes []rdiff.TreeHash
+		var present []bool
+		err = expectFrame(reader, s.maxFrameSize(), frameNodes, func(r io.Reader) (err error) {
+			hashes, present, err = decodeNodes(bufio.NewReader(r), len(indexes))
+			return err
+		})
+		if err != nil {
+			return s.fail(conn, err)
+		}
+
+		indexes = tree.DiffLevel(level, indexes, hashes, present)
+	}
+
+	if err = writeChunks(conn, file, tree, indexes); err != nil {
+		return err
+	}
+
+	return writeDone(conn, tree.Size, hash.Sum(nil))
+}
+
+// syncTree is Sync in tree mode. The chunks that differ are written to the
+// temporary file that replaces local as they are received, the local chunks
+// are filled in once the size of the file is known.
+func (c *Client) syncTree(ctx context.Context, conn io.Writer, reader *bufio.Reader, remote, local string, base io.ReaderAt, tree *rdiff.TreeSignature) (Stats, error) {
+	var stats Stats
+
+	request := append([]byte{treeProtocolVersion}, remote...)
+	if err := writeFrame(conn, frameRequest, request); err != nil {
+		return stats, err
+	}
+
+	header := appendUvarint(nil, uint64(tree.ChunkSize))
+	header = appendUvarint(header, uint64(tree.Leaves()))
+	if err := writeFrame(conn, frameTree, header); err != nil {
+		return stats, err
+	}
+
+	err := rfile.WriteFile(local, 0o644, func(tmp *os.File) error {
+		// Answer queries until the sender sends the differing chunks.
+		var received map[int]bool
+		for received == nil {
+			var nodes []byte
+			_, err := expectFrames(reader, unlimited, map[byte]func(io.Reader) error{
+				frameQuery: func(r io.Reader) error {
+					level, indexes, err := decodeQuery(bufio.NewReader(r))
+					if err != nil {
+						return err
+					}
+
+					stats.Nodes += len(indexes)
+					nodes = encodeNodes(tree, level, indexes)
+
+					return nil
+				},
+				frameChunks: func(r io.Reader) (err error) {
+					received, stats.Literal, err = readChunks(bufio.NewReader(r), tmp, tree.ChunkSize)
+					return err
+				},
+			})
+			if err != nil {
+				return err
+			}
+
+			if nodes != nil {
+				if err = writeFrame(conn, frameNodes, nodes); err != nil {
+					return err
+				}
+			}
+
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		var digest []byte
+		err := expectFrame(reader, unlimited, frameDone, func(r io.Reader) (err error) {
+			stats.Size, digest, err = decodeDone(r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if stats.Matched, err = fillChunks(tmp, base, tree, received, stats.Size); err != nil {
+			return err
+		}
+
+		hash := sha256.New()
+		if _, err = io.Copy(hash, io.NewSectionReader(tmp, 0, stats.Size)); err != nil {
+			return err
+		}
+
+		if !bytes.Equal(hash.Sum(nil), digest) {
+			return fmt.Errorf("%w: patched file does not match the remote file", ErrVerificationFailed)
+		}
+
+		return nil
+	})
+
+	return stats, err
+}
+
+// fillChunks copies the local chunks that were not received from base to tmp
+// and cuts tmp to size. It returns the number of local chunks.
+func fillChunks(tmp *os.File, base io.ReaderAt, tree *rdiff.TreeSignature, received map[int]bool, size int64) (int, error) {
+	chunkSize := int64(tree.ChunkSize)
+	count := int((size + chunkSize - 1) / chunkSize)
+	for i := range received {
+		if i >= count {
+			return 0, fmt.Errorf("%w: chunk %d is beyond the end of the file", ErrProtocol, i)
+		}
+	}
+
+	matched := 0
+	chunk := make([]byte, chunkSize)
+	for i := 0; i < count; i++ {
+		if received[i] {
+			continue
+		}
+
+		if i >= tree.Leaves() {
+			return 0, fmt.Errorf("%w: chunk %d is missing", ErrProtocol, i)
+		}
+
+		offset := int64(i) * chunkSize
+		length := size - offset
+		if length > chunkSize {
+			length = chunkSize
+		}
+
+		// A short local chunk means the local file changed since it was
+		// signed, the result would not verify anyway.
+		if n, err := base.ReadAt(chunk[:length], offset); int64(n) < length {
+			return 0, fmt.Errorf("%w: local chunk %d changed: %v", ErrVerificationFailed, i, err)
+		}
+
+		if _, err := tmp.WriteAt(chunk[:length], offset); err != nil {
+			return 0, err
+		}
+
+		matched++
+	}
+
+	return matched, tmp.Truncate(size)
+}
+
+func encodeQuery(level int, indexes []int) []byte {
+	payload := appendUvarint(nil, uint64(level))
+	payload = appendUvarint(payload, uint64(len(indexes)))
+	for _, i := range indexes {
+		payload = appendUvarint(payload, uint64(i))
+	}
+
+	return payload
+}
+
+func decodeQuery(r *bufio.Reader) (int, []int, error) {
+	level, err := readInt(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if level > maxTreeLevel {
+		return 0, nil, fmt.Errorf("%w: invalid tree level %d", ErrProtocol, level)
+	}
+
+	count, err := readInt(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var indexes []int
+	for ; count > 0; count-- {
+		i, err := readInt(r)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		indexes = append(indexes, i)
+	}
+
+	return level, indexes, nil
+}
+
+// encodeNodes encodes the nodes at indexes on level as a byte that tells
+// whether the tree has the node, followed by its hash if it has.
+func encodeNodes(tree *rdiff.TreeSignature, level int, indexes []int) []byte {
+	payload := make([]byte, 0, len(indexes)*(1+sha256.Size))
+	for _, i := range indexes {
+		hash, ok := tree.Node(level, i)
+		if !ok {
+			payload = append(payload, 0)
+			continue
+		}
+
+		payload = append(payload, 1)
+		payload = append(payload, hash[:]...)
+	}
+
+	return payload
+}
+
+func decodeNodes(r *bufio.Reader, count int) ([]rdiff.TreeHash, []bool, error) {
+	hashes := make([]rdiff.TreeHash, count)
+	present := make([]bool, count)
+	for i := range hashes {
+		flag, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrProtocol, err)
+		}
+
+		if flag == 0 {
+			continue
+		}
+
+		if _, err = io.ReadFull(r, hashes[i][:]); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrProtocol, err)
+		}
+
+		present[i] = true
+	}
+
+	return hashes, present, nil
+}
+
+// writeChunks writes the chunks frame with the count of the chunks of file at
+// indexes, followed by the index, length and data of each. Only the size of the
+// frame is computed up front, the chunks are read one at a time while they are
+// written.
+func writeChunks(w io.Writer, file io.ReaderAt, tree *rdiff.TreeSignature, indexes []int) error {
+	chunkSize := int64(tree.ChunkSize)
+	length := func(i int) int64 {
+		if remaining := tree.Size - int64(i)*chunkSize; remaining < chunkSize {
+			return remaining
+		}
+
+		return chunkSize
+	}
+
+	chunkHeader := func(i int) []byte {
+		return appendUvarint(appendUvarint(nil, uint64(i)), uint64(length(i)))
+	}
+
+	header := appendUvarint(nil, uint64(len(indexes)))
+	size := int64(len(header))
+	for _, i := range indexes {
+		size += int64(len(chunkHeader(i))) + length(i)
+	}
+
+	writer := bufio.NewWriter(w)
+	if err := writeFrameHeader(writer, frameChunks, size); err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+
+	chunk := make([]byte, chunkSize)
+	for _, i := range indexes {
+		data := chunk[:length(i)]
+		if _, err := file.ReadAt(data, int64(i)*chunkSize); err != nil {
+			return err
+		}
+
+		if _, err := writer.Write(chunkHeader(i)); err != nil {
+			return err
+		}
+
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+// readChunks reads the chunks written by writeChunks and writes each one at
+// its offset to w. It returns the indexes of the chunks and their total size.
+func readChunks(r *bufio.Reader, w io.WriterAt, chunkSize int) (map[int]bool, int64, error) {
+	count, err := readInt(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	received := make(map[int]bool)
+	chunk := make([]byte, chunkSize)
+	var total int64
+	for ; count > 0; count-- {
+		i, err := readInt(r)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		length, err := readInt(r)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if length > chunkSize || int64(i) > math.MaxInt64/int64(chunkSize)-1 {
+			return nil, 0, fmt.Errorf("%w: invalid chunk %d with %d bytes", ErrProtocol, i, length)
+		}
+
+		if n, err := io.ReadFull(r, chunk[:length]); err != nil {
+			return nil, 0, fmt.Errorf("%w: chunk %d has %d of %d bytes", ErrProtocol, i, n, length)
+		}
+
+		if _, err = w.WriteAt(chunk[:length], int64(i)*int64(chunkSize)); err != nil {
+			return nil, 0, err
+		}
+
+		received[i] = true
+		total += int64(length)
+	}
+
+	return received, total, nil
+}
+
+func decodeDone(r io.Reader) (int64, []byte, error) {
+	br := bufio.NewReader(r)
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrProtocol, err)
+	}
+
+	digest, err := io.ReadAll(br)
+
+	return int64(size), digest, err
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	return append(b, buf[:binary.PutUvarint(buf, v)]...)
+}
+
+// readInt reads an unsigned varint that has to fit into an int.
+func readInt(r io.ByteReader) (int, error) {
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrProtocol, err)
+	}
+
+	if v > uint64(^uint(0)>>1) {
+		return 0, fmt.Errorf("%w: value %d out of range", ErrProtocol, v)
+	}
+
+	return int(v), nil
+}
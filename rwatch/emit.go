@@ -0,0 +1,84 @@
+package rwatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rfile"
+)
+
+// WriteDir returns an EmitFunc that writes every delta in the binary delta
+// format to dir, named after the sequence number of the change.
+func WriteDir(dir string) EmitFunc {
+	return func(change Change) error {
+		name := filepath.Join(dir, fmt.Sprintf("%06d.delta", change.Sequence))
+
+		return rfile.WriteFile(name, 0o644, func(f *os.File) error {
+			_, err := change.Delta.WriteTo(f)
+			return err
+		})
+	}
+}
+
+// WriteJSONLines returns an EmitFunc that writes every change as a line of
+// JSON to w.
+func WriteJSONLines(w io.Writer) EmitFunc {
+	encoder := json.NewEncoder(w)
+
+	return func(change Change) error {
+		return encoder.Encode(change)
+	}
+}
+
+// RunHook returns an EmitFunc that runs command with sh -c for every change,
+// with the delta in the binary delta format on its stdin. The change is
+// described by the environment variables RDETECTIVE_FILE,
+// RDETECTIVE_SEQUENCE, RDETECTIVE_SIZE and RDETECTIVE_SHA256.
+// Failing hooks are logged and do not stop watching.
+func RunHook(ctx context.Context, command string, logger logrus.FieldLogger) EmitFunc {
+	return func(change Change) error {
+		var delta bytes.Buffer
+		if _, err := change.Delta.WriteTo(&delta); err != nil {
+			return err
+		}
+
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Stdin = &delta
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(),
+			"RDETECTIVE_FILE="+change.File,
+			"RDETECTIVE_SEQUENCE="+strconv.Itoa(change.Sequence),
+			"RDETECTIVE_SIZE="+strconv.FormatInt(change.Size, 10),
+			"RDETECTIVE_SHA256="+change.SHA256,
+		)
+
+		if err := cmd.Run(); err != nil && ctx.Err() == nil {
+			logger.WithError(err).WithField("sequence", change.Sequence).Warnln("hook failed")
+		}
+
+		return nil
+	}
+}
+
+// Emitters returns an EmitFunc that calls all of emitters in order.
+func Emitters(emitters ...EmitFunc) EmitFunc {
+	return func(change Change) error {
+		for _, emit := range emitters {
+			if err := emit(change); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
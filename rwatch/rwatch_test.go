@@ -0,0 +1,224 @@
+package rwatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+var logger = &logrus.Logger{
+	Out:       io.Discard,
+	Formatter: &logrus.TextFormatter{},
+	Level:     logrus.DebugLevel,
+}
+
+// watch starts watching path and returns the channel changes are sent to.
+func watch(t *testing.T, path string) <-chan Change {
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan struct{})
+	changes := make(chan Change, 16)
+	errs := make(chan error, 1)
+
+	w := New(&Config{
+		Logger:    logger,
+		ChunkSize: 4,
+		Debounce:  50 * time.Millisecond,
+	})
+	w.ready = func() { close(ready) }
+
+	go func() {
+		errs <- w.Watch(ctx, path, func(change Change) error {
+			changes <- change
+			return nil
+		})
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errs; err != nil {
+			t.Errorf("unexpected watch error: %s", err.Error())
+		}
+	})
+
+	select {
+	case <-ready:
+	case err := <-errs:
+		t.Fatalf("error watching: %v", err)
+	}
+
+	return changes
+}
+
+func next(t *testing.T, changes <-chan Change) Change {
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for change")
+	}
+
+	return Change{}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// apply returns the result of applying the delta of change to previous.
+func apply(t *testing.T, previous string, change Change) string {
+	var patched bytes.Buffer
+	if err := rdiff.Apply(bytes.NewReader([]byte(previous)), change.Delta, &patched); err != nil {
+		t.Fatalf("error applying delta: %s", err.Error())
+	}
+
+	return patched.String()
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	writeFile(t, path, "hello world")
+
+	changes := watch(t, path)
+	content := "hello world"
+	for i, update := range []string{"hello there world", "hello world!", ""} {
+		writeFile(t, path, update)
+
+		change := next(t, changes)
+		if change.Sequence != i+1 {
+			t.Errorf("unexpected sequence, got %d, expected %d", change.Sequence, i+1)
+		}
+
+		if change.Size != int64(len(update)) {
+			t.Errorf("unexpected size, got %d, expected %d", change.Size, len(update))
+		}
+
+		if patched := apply(t, content, change); patched != update {
+			t.Errorf("unexpected patch result, got %q, expected %q", patched, update)
+		}
+
+		content = update
+	}
+}
+
+func TestWatchDebounce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	changes := watch(t, path)
+
+	// A burst of writes, the file does not exist before.
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, part := range []string{"hello", " ", "world"} {
+		if _, err = file.WriteString(part); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	file.Close()
+
+	if change := next(t, changes); apply(t, "", change) != "hello world" {
+		t.Errorf("unexpected patch result %q", apply(t, "", change))
+	}
+
+	select {
+	case change := <-changes:
+		t.Errorf("unexpected change %d", change.Sequence)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWatchRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	writeFile(t, path, "hello world")
+	changes := watch(t, path)
+
+	// Unchanged content is not emitted.
+	writeFile(t, path, "hello world")
+
+	// Editors often replace files by renaming a new file over them.
+	writeFile(t, filepath.Join(dir, "file.tmp"), "hello new world")
+	if err := os.Rename(filepath.Join(dir, "file.tmp"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	change := next(t, changes)
+	if change.Sequence != 1 {
+		t.Errorf("unexpected sequence, got %d, expected %d", change.Sequence, 1)
+	}
+
+	if patched := apply(t, "hello world", change); patched != "hello new world" {
+		t.Errorf("unexpected patch result, got %q, expected %q", patched, "hello new world")
+	}
+}
+
+func TestEmitters(t *testing.T) {
+	dir := t.TempDir()
+	change := Change{
+		Sequence: 7,
+		File:     "file",
+		Size:     5,
+		SHA256:   "digest",
+		Delta: rdiff.Delta{
+			ChunkSize: 4,
+			Changes:   []rdiff.DeltaChunk{{NewBytes: []byte("hello")}},
+		},
+	}
+
+	var lines bytes.Buffer
+	hookOutput := filepath.Join(dir, "hook")
+	emit := Emitters(
+		WriteDir(dir),
+		WriteJSONLines(&lines),
+		RunHook(context.Background(), `cat > "`+hookOutput+`" && echo "$RDETECTIVE_SEQUENCE $RDETECTIVE_SIZE" >> "`+hookOutput+`"`, logger),
+	)
+	if err := emit(change); err != nil {
+		t.Fatalf("error emitting change: %s", err.Error())
+	}
+
+	file, err := os.Open(filepath.Join(dir, "000007.delta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if delta, err := rdiff.ReadDelta(file); err != nil || string(delta.Changes[0].NewBytes) != "hello" {
+		t.Errorf("unexpected delta file %v, %v", delta, err)
+	}
+
+	var decoded Change
+	if err = json.Unmarshal(lines.Bytes(), &decoded); err != nil {
+		t.Fatalf("error decoding json line: %s", err.Error())
+	}
+
+	if decoded.Sequence != 7 || string(decoded.Delta.Changes[0].NewBytes) != "hello" {
+		t.Errorf("unexpected json line %s", lines.String())
+	}
+
+	hook, err := os.ReadFile(hookOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encoded bytes.Buffer
+	if _, err = change.Delta.WriteTo(&encoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := encoded.String() + "7 5\n"; string(hook) != expected {
+		t.Errorf("unexpected hook output, got %q, expected %q", hook, expected)
+	}
+}
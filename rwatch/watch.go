@@ -0,0 +1,218 @@
+// Package rwatch follows a file and emits the delta of every change to it.
+package rwatch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+type Config struct {
+	Logger    logrus.FieldLogger
+	ChunkSize int
+
+	// Debounce is how long the file has to be left alone after an event before
+	// its delta is generated, so that a burst of writes results in one delta.
+	Debounce time.Duration
+}
+
+// Change describes a change of the watched file. The Delta is relative to the
+// content of the previous change, or of the file when watching started.
+type Change struct {
+	Time     time.Time   `json:"time"`
+	Sequence int         `json:"sequence"`
+	File     string      `json:"file"`
+	Size     int64       `json:"size"`
+	SHA256   string      `json:"sha256"`
+	Delta    rdiff.Delta `json:"delta"`
+}
+
+// EmitFunc is called with every change. Errors stop watching.
+type EmitFunc func(Change) error
+
+type Watcher struct {
+	config *Config
+
+	// ready is called once events are watched, for tests.
+	ready func()
+}
+
+func New(config *Config) *Watcher {
+	return &Watcher{
+		config: config,
+	}
+}
+
+// Watch signs the file at path and then calls emit for every change of its
+// content until ctx is done. The file does not need to exist, a missing file
+// counts as empty. Events are watched on the directory of the file, so that
+// files replaced by renaming another file over them are followed as well.
+func (w *Watcher) Watch(ctx context.Context, path string, emit EmitFunc) error {
+	options := rdiff.Options{ChunkSize: w.config.ChunkSize}
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err = watcher.Add(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	file := &watchedFile{
+		path:      path,
+		options:   options,
+		signature: rdiff.Signature{ChunkSize: options.ChunkSize},
+	}
+
+	if _, _, err = file.update(ctx); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if w.ready != nil {
+		w.ready()
+	}
+
+	logger := w.config.Logger.WithField("file", path)
+
+	var debounced <-chan time.Time
+	sequence := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			if filepath.Clean(event.Name) != path || event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			debounced = time.After(w.config.Debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+
+			logger.WithError(err).Warnln("watch error")
+		case <-debounced:
+			debounced = nil
+
+			change, changed, err := file.update(ctx)
+			switch {
+			case ctx.Err() != nil:
+				return nil
+			case errors.Is(err, os.ErrNotExist):
+				logger.Debugln("file is gone")
+				continue
+			case err != nil:
+				logger.WithError(err).Warnln("failed to generate delta")
+				continue
+			case !changed:
+				continue
+			}
+
+			sequence++
+			change.Sequence = sequence
+			if err = emit(change); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watchedFile keeps the signature of the last seen content of a file.
+type watchedFile struct {
+	path    string
+	options rdiff.Options
+
+	signature rdiff.Signature
+	digest    string
+}
+
+// update generates the delta of the file against the last signature and signs
+// the new content along the way. It returns false if the content did not
+// change.
+func (f *watchedFile) update(ctx context.Context) (Change, bool, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return Change{}, false, err
+	}
+	defer file.Close()
+
+	type signed struct {
+		signature rdiff.Signature
+		err       error
+	}
+
+	// Sign the content while it is being diffed, so that both see the same
+	// data even if the file changes again.
+	content, tee := io.Pipe()
+	signatures := make(chan signed, 1)
+	go func() {
+		signature, err := rdiff.NewSigner(f.options).SignHashes(ctx, content)
+		// Unblock the Differ in case signing stopped early.
+		_ = content.CloseWithError(io.ErrClosedPipe)
+		signatures <- signed{signature, err}
+	}()
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	delta, err := rdiff.NewDiffer(f.signature, f.options).DiffContext(ctx, io.TeeReader(file, io.MultiWriter(tee, hash, counter)))
+	_ = tee.CloseWithError(err)
+
+	result := <-signatures
+	if err != nil {
+		return Change{}, false, err
+	}
+
+	if result.err != nil {
+		return Change{}, false, result.err
+	}
+
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if digest == f.digest {
+		return Change{}, false, nil
+	}
+
+	f.signature = result.signature
+	f.digest = digest
+
+	return Change{
+		Time:   time.Now(),
+		File:   f.path,
+		Size:   counter.n,
+		SHA256: digest,
+		Delta:  delta,
+	}, true, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,231 @@
+// Package rzsync distributes files from a plain HTTP server the way zsync
+// does: a control file with the signature and block hashes of a file is
+// published next to it, and a client holding an older copy only downloads the
+// blocks it cannot find locally using HTTP range requests.
+package rzsync
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+// Control files start with controlMagic followed by the version, the name,
+// size and sha256 of the file, its rdiff signature and the sha256 of every
+// block.
+const (
+	controlMagic   = "RDZS"
+	controlVersion = 1
+)
+
+// Control describes a published file.
+type Control struct {
+	// Name is the location of the file, relative to the control file.
+	Name   string
+	Size   int64
+	SHA256 [sha256.Size]byte
+
+	Signature rdiff.Signature
+	// Blocks holds the sha256 of every chunk of the signature, to verify
+	// blocks matched by their weak hash.
+	Blocks [][sha256.Size]byte
+}
+
+// Publish reads the file from r and returns its Control.
+func Publish(r io.Reader, name string, chunkSize int) (Control, error) {
+	control := Control{
+		Name: name,
+	}
+
+	blocks := &blockHasher{size: chunkSize, hash: sha256.New()}
+	file := sha256.New()
+
+	signature, err := rdiff.NewSigner(rdiff.Options{ChunkSize: chunkSize}).SignHashes(context.Background(), io.TeeReader(r, io.MultiWriter(file, blocks)))
+	if err != nil {
+		return control, err
+	}
+	blocks.flush()
+
+	control.Signature = signature
+	control.Blocks = blocks.sums
+	control.Size = blocks.total
+	copy(control.SHA256[:], file.Sum(nil))
+
+	return control, nil
+}
+
+// blockHasher computes the sha256 of every block of size bytes written to it.
+type blockHasher struct {
+	size  int
+	hash  hash.Hash
+	used  int
+	total int64
+	sums  [][sha256.Size]byte
+}
+
+func (b *blockHasher) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := b.size - b.used
+		if n > len(p) {
+			n = len(p)
+		}
+
+		b.hash.Write(p[:n])
+		b.used += n
+		b.total += int64(n)
+		p = p[n:]
+
+		if b.used == b.size {
+			b.flush()
+		}
+	}
+
+	return written, nil
+}
+
+// flush finishes the current block, if any.
+func (b *blockHasher) flush() {
+	if b.used == 0 {
+		return
+	}
+
+	var sum [sha256.Size]byte
+	copy(sum[:], b.hash.Sum(nil))
+	b.sums = append(b.sums, sum)
+
+	b.hash.Reset()
+	b.used = 0
+}
+
+// WriteTo serializes the control file to w.
+func (c Control) WriteTo(w io.Writer) (int64, error) {
+	buffered := bufio.NewWriter(w)
+	counter := &countingWriter{w: buffered}
+
+	header := make([]byte, 0, len(controlMagic)+1+2*binary.MaxVarintLen64)
+	header = append(header, controlMagic...)
+	header = append(header, controlVersion)
+	header = appendUvarint(header, uint64(len(c.Name)))
+	if _, err := counter.Write(header); err != nil {
+		return counter.n, err
+	}
+
+	if _, err := io.WriteString(counter, c.Name); err != nil {
+		return counter.n, err
+	}
+
+	if _, err := counter.Write(appendUvarint(nil, uint64(c.Size))); err != nil {
+		return counter.n, err
+	}
+
+	if _, err := counter.Write(c.SHA256[:]); err != nil {
+		return counter.n, err
+	}
+
+	if _, err := c.Signature.WriteTo(counter); err != nil {
+		return counter.n, err
+	}
+
+	for _, block := range c.Blocks {
+		if _, err := counter.Write(block[:]); err != nil {
+			return counter.n, err
+		}
+	}
+
+	return counter.n, buffered.Flush()
+}
+
+// ReadControl deserializes a control file written by Control.WriteTo.
+func ReadControl(r io.Reader) (Control, error) {
+	var control Control
+	reader := bufio.NewReader(r)
+
+	corrupt := func(err error) (Control, error) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return Control{}, fmt.Errorf("%w: control file: %v", rdiff.ErrCorruptInput, err)
+	}
+
+	header := make([]byte, len(controlMagic)+1)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return corrupt(err)
+	}
+
+	if string(header[:len(controlMagic)]) != controlMagic || header[len(controlMagic)] != controlVersion {
+		return corrupt(fmt.Errorf("unsupported format"))
+	}
+
+	nameLength, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return corrupt(err)
+	}
+
+	// Names are short, anything longer is not a control file.
+	if nameLength > 4096 {
+		return corrupt(fmt.Errorf("name too long"))
+	}
+
+	name := make([]byte, nameLength)
+	if _, err = io.ReadFull(reader, name); err != nil {
+		return corrupt(err)
+	}
+	control.Name = string(name)
+
+	size, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return corrupt(err)
+	}
+	control.Size = int64(size)
+
+	if _, err = io.ReadFull(reader, control.SHA256[:]); err != nil {
+		return corrupt(err)
+	}
+
+	if control.Signature, err = rdiff.ReadSignature(reader); err != nil {
+		return Control{}, err
+	}
+
+	for range control.Signature.Chunks {
+		var block [sha256.Size]byte
+		if _, err = io.ReadFull(reader, block[:]); err != nil {
+			return corrupt(err)
+		}
+
+		control.Blocks = append(control.Blocks, block)
+	}
+
+	expectedBlocks := (control.Size + int64(control.Signature.ChunkSize) - 1) / int64(control.Signature.ChunkSize)
+	if int64(len(control.Blocks)) != expectedBlocks {
+		return corrupt(fmt.Errorf("%d blocks for %d bytes", len(control.Blocks), control.Size))
+	}
+
+	return control, nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+
+	return append(b, buf[:n]...)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
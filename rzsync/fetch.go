@@ -0,0 +1,291 @@
+package rzsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+	"github.com/sol1du2/rdetective/rfile"
+)
+
+// ErrVerificationFailed is returned when the reconstructed file does not match
+// the control file. It wraps rdiff.ErrVerificationFailed.
+var ErrVerificationFailed = fmt.Errorf("%w", rdiff.ErrVerificationFailed)
+
+type FetcherConfig struct {
+	Logger logrus.FieldLogger
+
+	// Client is used for all requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// Fetcher reconstructs published files.
+type Fetcher struct {
+	config *FetcherConfig
+}
+
+func NewFetcher(config *FetcherConfig) *Fetcher {
+	return &Fetcher{
+		config: config,
+	}
+}
+
+// Stats describes the outcome of a fetch.
+type Stats struct {
+	Size int64
+	// Reused is the number of blocks taken from the local copy.
+	Reused int
+	// Downloaded is the amount of bytes fetched from the server.
+	Downloaded int64
+}
+
+// FetchControl downloads and decodes the control file at controlURL.
+func (f *Fetcher) FetchControl(ctx context.Context, controlURL string) (Control, error) {
+	response, err := f.get(ctx, controlURL, "")
+	if err != nil {
+		return Control{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return Control{}, fmt.Errorf("failed to fetch %s: %s", controlURL, response.Status)
+	}
+
+	return ReadControl(response.Body)
+}
+
+// Fetch reconstructs the file described by the control file at controlURL into
+// output. Blocks are reused from the older copy at local where possible, which
+// may be output itself or empty if there is none. output is only replaced once
+// the result matches the sha256 of the control file.
+func (f *Fetcher) Fetch(ctx context.Context, controlURL string, local string, output string) (Stats, error) {
+	var stats Stats
+
+	control, err := f.FetchControl(ctx, controlURL)
+	if err != nil {
+		return stats, err
+	}
+
+	fileURL, err := resolveURL(controlURL, control.Name)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Size = control.Size
+
+	// output keeps its mode, new files get 0644 minus the umask.
+	err = rfile.WriteFile(output, 0o644, func(tmp *os.File) error {
+		if err := tmp.Truncate(control.Size); err != nil {
+			return err
+		}
+
+		found, err := f.copyLocalBlocks(ctx, control, local, tmp)
+		if err != nil {
+			return err
+		}
+
+		// Download the missing blocks, merging neighbours into a single range.
+		for start := 0; start < len(found); {
+			if found[start] {
+				stats.Reused++
+				start++
+				continue
+			}
+
+			end := start
+			for end < len(found) && !found[end] {
+				end++
+			}
+
+			downloaded, err := f.fetchBlocks(ctx, fileURL, control, start, end, tmp)
+			stats.Downloaded += downloaded
+			if err != nil {
+				return err
+			}
+
+			start = end
+		}
+
+		return verifyFile(tmp, control.SHA256)
+	})
+
+	return stats, err
+}
+
+// copyLocalBlocks finds blocks of the published file in the local copy, using
+// the rolling signature match, and copies those that pass the sha256 check to
+// output. It returns which blocks were found.
+func (f *Fetcher) copyLocalBlocks(ctx context.Context, control Control, local string, output io.WriterAt) ([]bool, error) {
+	found := make([]bool, len(control.Blocks))
+	if local == "" {
+		return found, nil
+	}
+
+	file, err := os.Open(local)
+	if errors.Is(err, os.ErrNotExist) {
+		return found, nil
+	}
+
+	if err != nil {
+		return nil, &rdiff.SourceError{Source: "original", Op: "open", Err: err}
+	}
+	defer file.Close()
+
+	// The published file takes the role of the original here, matches tell
+	// where its blocks are in the local copy.
+	delta, err := rdiff.NewDiffer(control.Signature, rdiff.Options{Logger: f.config.Logger}).DiffContext(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize := int64(control.Signature.ChunkSize)
+	block := make([]byte, chunkSize)
+	for _, change := range delta.Changes {
+		index := change.ChunkIndex
+		if index >= len(control.Blocks) {
+			continue
+		}
+
+		length := chunkSize
+		if end := int64(index+1) * chunkSize; end > control.Size {
+			length = control.Size - int64(index)*chunkSize
+		}
+
+		localOffset := int64(change.Position + len(change.NewBytes))
+		if _, err = file.ReadAt(block[:length], localOffset); err != nil {
+			return nil, &rdiff.SourceError{Source: "original", Op: "read", Err: err}
+		}
+
+		// The weak hash may collide, only trust the block if the strong one
+		// matches as well.
+		if sha256.Sum256(block[:length]) != control.Blocks[index] {
+			continue
+		}
+
+		if _, err = output.WriteAt(block[:length], int64(index)*chunkSize); err != nil {
+			return nil, err
+		}
+
+		found[index] = true
+	}
+
+	return found, nil
+}
+
+// fetchBlocks downloads the blocks from start up to end of fileURL with a
+// single range request and writes them to output. Every block is checked
+// against the control file as soon as it is received, so only one block is
+// held in memory. It returns the amount of bytes that had to be downloaded,
+// which is more than the blocks for servers that ignore range requests.
+func (f *Fetcher) fetchBlocks(ctx context.Context, fileURL string, control Control, start, end int, output io.WriterAt) (int64, error) {
+	chunkSize := int64(control.Signature.ChunkSize)
+	offset := int64(start) * chunkSize
+	length := int64(end)*chunkSize - offset
+	if offset+length > control.Size {
+		length = control.Size - offset
+	}
+
+	response, err := f.get(ctx, fileURL, fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	skip := int64(0)
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The server ignored the range, skip to it in the whole file.
+		f.config.Logger.WithField("url", fileURL).Warnln("server does not support range requests")
+		skip = offset
+	default:
+		return 0, fmt.Errorf("failed to fetch range of %s: %s", fileURL, response.Status)
+	}
+
+	downloaded, err := io.CopyN(io.Discard, response.Body, skip)
+	if err != nil {
+		return downloaded, fmt.Errorf("failed to fetch range of %s: %w", fileURL, err)
+	}
+
+	block := make([]byte, chunkSize)
+	for i := start; i < end; i++ {
+		data := block
+		if remaining := control.Size - int64(i)*chunkSize; remaining < chunkSize {
+			data = block[:remaining]
+		}
+
+		n, err := io.ReadFull(response.Body, data)
+		downloaded += int64(n)
+		if err != nil {
+			return downloaded, fmt.Errorf("failed to fetch range of %s: %w", fileURL, err)
+		}
+
+		if sha256.Sum256(data) != control.Blocks[i] {
+			return downloaded, fmt.Errorf("%w: block %d of %s", ErrVerificationFailed, i, fileURL)
+		}
+
+		if _, err = output.WriteAt(data, int64(i)*chunkSize); err != nil {
+			return downloaded, err
+		}
+	}
+
+	return downloaded, nil
+}
+
+func (f *Fetcher) get(ctx context.Context, target, byteRange string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if byteRange != "" {
+		request.Header.Set("Range", byteRange)
+	}
+
+	client := f.config.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return client.Do(request)
+}
+
+func resolveURL(base, name string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	nameURL, err := url.Parse(name)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid name %q", rdiff.ErrCorruptInput, name)
+	}
+
+	return baseURL.ResolveReference(nameURL).String(), nil
+}
+
+func verifyFile(file io.ReadSeeker, expected [sha256.Size]byte) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(hash.Sum(nil), expected[:]) {
+		return fmt.Errorf("%w: reconstructed file does not match its sha256", ErrVerificationFailed)
+	}
+
+	return nil
+}
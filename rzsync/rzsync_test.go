@@ -0,0 +1,247 @@
+package rzsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/sol1du2/rdetective/rdiff"
+)
+
+func getFetcher() *Fetcher {
+	return NewFetcher(&FetcherConfig{
+		Logger: &logrus.Logger{
+			Out:       io.Discard,
+			Formatter: &logrus.TextFormatter{},
+			Level:     logrus.DebugLevel,
+		},
+	})
+}
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+
+	return data
+}
+
+// publish writes data and its control file to dir.
+func publish(t *testing.T, dir, name string, data []byte, chunkSize int) {
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	control, err := Publish(bytes.NewReader(data), name, chunkSize)
+	if err != nil {
+		t.Fatalf("error publishing: %s", err.Error())
+	}
+
+	file, err := os.Create(filepath.Join(dir, name+".rdz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err = control.WriteTo(file); err != nil {
+		t.Fatalf("error writing control file: %s", err.Error())
+	}
+}
+
+func TestFetch(t *testing.T) {
+	published := t.TempDir()
+	server := httptest.NewServer(http.FileServer(http.Dir(published)))
+	defer server.Close()
+
+	updated := randomData(50000, 1)
+	publish(t, published, "file", updated, 16)
+
+	older := append(append(append([]byte{}, updated[:10000]...), randomData(500, 2)...), updated[20000:]...)
+
+	tests := []struct {
+		name       string
+		local      []byte
+		downloaded int64
+	}{
+		{"older", older, 10000},
+		{"identical", updated, 0},
+		{"missing", nil, int64(len(updated))},
+		{"unrelated", randomData(50000, 3), int64(len(updated))},
+	}
+
+	// New files get the mode of a file created the usual way.
+	newFile := filepath.Join(t.TempDir(), "new")
+	if err := os.WriteFile(newFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(newFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newMode := info.Mode().Perm()
+
+	for _, test := range tests {
+		output := filepath.Join(t.TempDir(), "file")
+		mode := newMode
+		if test.local != nil {
+			mode = 0o640
+			if err := os.WriteFile(output, test.local, mode); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stats, err := getFetcher().Fetch(context.Background(), server.URL+"/file.rdz", output, output)
+		if err != nil {
+			t.Fatalf("%s: error fetching: %s", test.name, err.Error())
+		}
+
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, updated) {
+			t.Errorf("%s: unexpected content, got %d bytes, expected %d bytes", test.name, len(data), len(updated))
+		}
+
+		if info, err = os.Stat(output); err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Mode().Perm() != mode {
+			t.Errorf("%s: unexpected mode, got %v, expected %v", test.name, info.Mode().Perm(), mode)
+		}
+
+		// Block alignment may require a little more than the changed range.
+		if stats.Downloaded < test.downloaded || stats.Downloaded > test.downloaded+32 {
+			t.Errorf("%s: unexpected download size, got %d, expected %d", test.name, stats.Downloaded, test.downloaded)
+		}
+	}
+}
+
+func TestFetchEmpty(t *testing.T) {
+	published := t.TempDir()
+	server := httptest.NewServer(http.FileServer(http.Dir(published)))
+	defer server.Close()
+
+	publish(t, published, "empty", []byte{}, 16)
+
+	output := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(output, []byte("stale"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := getFetcher().Fetch(context.Background(), server.URL+"/empty.rdz", output, output); err != nil {
+		t.Fatalf("error fetching: %s", err.Error())
+	}
+
+	if data, _ := os.ReadFile(output); len(data) != 0 {
+		t.Errorf("unexpected content %q", data)
+	}
+}
+
+func TestFetchVerificationFailure(t *testing.T) {
+	published := t.TempDir()
+	server := httptest.NewServer(http.FileServer(http.Dir(published)))
+	defer server.Close()
+
+	publish(t, published, "file", randomData(1000, 1), 16)
+
+	// The file changed after the control file was published.
+	if err := os.WriteFile(filepath.Join(published, "file"), randomData(1000, 2), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(t.TempDir(), "file")
+	stats, err := getFetcher().Fetch(context.Background(), server.URL+"/file.rdz", "", output)
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("unexpected error, got %v, expected %v", err, ErrVerificationFailed)
+	}
+
+	// The range of all blocks is abandoned at the first block that differs.
+	if stats.Downloaded != 16 {
+		t.Errorf("unexpected download size, got %d, expected %d", stats.Downloaded, 16)
+	}
+
+	if _, err = os.Stat(output); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("output written despite failed verification")
+	}
+}
+
+func TestFetchWithoutRangeSupport(t *testing.T) {
+	published := t.TempDir()
+	files := http.FileServer(http.Dir(published))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Range")
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	updated := randomData(1000, 1)
+	publish(t, published, "file", updated, 16)
+
+	local := append(append([]byte{}, updated[:500]...), randomData(100, 2)...)
+	local = append(local, updated[600:]...)
+
+	output := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(output, local, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := getFetcher().Fetch(context.Background(), server.URL+"/file.rdz", output, output); err != nil {
+		t.Fatalf("error fetching: %s", err.Error())
+	}
+
+	if data, _ := os.ReadFile(output); !bytes.Equal(data, updated) {
+		t.Errorf("unexpected content, got %d bytes, expected %d bytes", len(data), len(updated))
+	}
+}
+
+func TestControlEncoding(t *testing.T) {
+	data := randomData(1000, 1)
+
+	control, err := Publish(bytes.NewReader(data), "dir/file name", 64)
+	if err != nil {
+		t.Fatalf("error publishing: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	if _, err = control.WriteTo(&buf); err != nil {
+		t.Fatalf("error writing control file: %s", err.Error())
+	}
+	encoded := buf.Bytes()
+
+	decoded, err := ReadControl(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("error reading control file: %s", err.Error())
+	}
+
+	if decoded.Name != control.Name || decoded.Size != control.Size || decoded.SHA256 != control.SHA256 {
+		t.Errorf("unexpected header, got %q/%d, expected %q/%d", decoded.Name, decoded.Size, control.Name, control.Size)
+	}
+
+	if len(decoded.Blocks) != 16 || len(decoded.Signature.Chunks) != 16 {
+		t.Errorf("unexpected number of blocks %d", len(decoded.Blocks))
+	}
+
+	for i := range control.Blocks {
+		if decoded.Blocks[i] != control.Blocks[i] {
+			t.Errorf("unexpected hash of block %d", i)
+		}
+	}
+
+	for _, corrupt := range [][]byte{encoded[:len(encoded)-1], encoded[:10], []byte("XXXX")} {
+		if _, err = ReadControl(bytes.NewReader(corrupt)); !errors.Is(err, rdiff.ErrCorruptInput) {
+			t.Errorf("unexpected error, got %v, expected %v", err, rdiff.ErrCorruptInput)
+		}
+	}
+}